@@ -3,18 +3,22 @@ module github.com/chammond14/muzz
 go 1.22.2
 
 require (
-	github.com/0x6flab/namegenerator v1.4.0 // indirect
-	github.com/gabriel-vasile/mimetype v1.4.3 // indirect
+	github.com/0x6flab/namegenerator v1.4.0
+	github.com/go-playground/validator/v10 v10.22.0
+	github.com/google/uuid v1.6.0
+	github.com/jackc/pgx v3.6.2+incompatible
+	github.com/joho/godotenv v1.5.1
+)
+
+require (
+	github.com/gabriel-vasile/mimetype v1.4.3
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
-	github.com/go-playground/validator/v10 v10.22.0 // indirect
-	github.com/google/uuid v1.6.0
-	github.com/jackc/pgx v3.6.2+incompatible // indirect
-	github.com/joho/godotenv v1.5.1 // indirect
 	github.com/leodido/go-urn v1.4.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	golang.org/x/crypto v0.19.0 // indirect
+	golang.org/x/crypto v0.19.0
+	golang.org/x/image v0.18.0
 	golang.org/x/net v0.21.0 // indirect
 	golang.org/x/sys v0.17.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 )
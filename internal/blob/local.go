@@ -0,0 +1,98 @@
+package blob
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore keeps blobs as files beneath a directory on the local filesystem.
+// The files are expected to be served by the app at BaseURL.
+type LocalStore struct {
+	Dir     string
+	BaseURL string
+}
+
+func NewLocalStore(dir string, baseURL string) (*LocalStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &LocalStore{Dir: dir, BaseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+func (ls *LocalStore) Put(ctx context.Context, key string, r io.Reader, contentType string) error {
+	filename, err := ls.filename(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+
+	// write to a temporary file first so readers never see a partially written blob
+	tmp, err := os.CreateTemp(filepath.Dir(filename), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+func (ls *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	filename, err := ls.filename(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return f, err
+}
+
+func (ls *LocalStore) Delete(ctx context.Context, key string) error {
+	filename, err := ls.filename(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(filename)
+	if err != nil && !os.IsNotExist(err) {
+		slog.Error("Error deleting blob", "key", key, "error", err)
+		return err
+	}
+
+	return nil
+}
+
+func (ls *LocalStore) URL(key string) string {
+	return ls.BaseURL + "/" + key
+}
+
+// filename maps a key to a path beneath Dir, refusing keys which would escape it.
+func (ls *LocalStore) filename(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if key == "" || cleaned != "/"+key {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(ls.Dir, filepath.FromSlash(cleaned)), nil
+}
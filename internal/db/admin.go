@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// AccountDetails describes a profile as seen by staff. It never contains the password.
+type AccountDetails struct {
+	Id             int32      `json:"id"`
+	Email          string     `json:"email"`
+	Name           string     `json:"name"`
+	Age            int        `json:"age"`
+	Gender         string     `json:"gender"`
+	Role           string     `json:"role"`
+	Warnings       int        `json:"warnings"`
+	SuspendedUntil *time.Time `json:"suspendedUntil,omitempty"`
+	BannedAt       *time.Time `json:"bannedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+var accountDetailsColumns = `id, COALESCE(email, ''), name, ` + ageSQL("dateOfBirth") + `, gender, role, warnings, suspendedUntil, bannedAt, createdAt`
+
+func (a *AccountDetails) scanRow(r rowScanner) error {
+	return r.Scan(
+		&a.Id,
+		&a.Email,
+		&a.Name,
+		&a.Age,
+		&a.Gender,
+		&a.Role,
+		&a.Warnings,
+		&a.SuspendedUntil,
+		&a.BannedAt,
+		&a.CreatedAt,
+	)
+}
+
+// AdminStore describes the data access required by the admin API
+type AdminStore interface {
+	SearchProfiles(context.Context, string, string, int) ([]*AccountDetails, error)
+	GetAccountDetails(context.Context, int32) (*AccountDetails, error)
+	RevokeSessions(context.Context, int32) error
+	SetRole(context.Context, int32, string) (*AccountDetails, error)
+	AuditStore
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// SearchProfiles finds profiles whose name or email contains the search term, optionally restricted to a role.
+func (ps *PostgresStore) SearchProfiles(ctx context.Context, term string, role string, limit int) ([]*AccountDetails, error) {
+	slog.Info("Searching profiles", "term", term, "role", role)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT ` + accountDetailsColumns + ` FROM profiles
+				WHERE ($1 = '' OR name ILIKE '%' || $1 || '%' OR email ILIKE '%' || $1 || '%')
+				AND ($2 = '' OR role = $2)
+				ORDER BY id
+				LIMIT $3`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, likeEscaper.Replace(term), role, limit)
+	if err != nil {
+		slog.Error("Error searching profiles", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	accounts := []*AccountDetails{}
+	for rows.Next() {
+		account := &AccountDetails{}
+		if err := account.scanRow(rows); err != nil {
+			slog.Error("Error scanning rows", "method", "SearchProfiles", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		accounts = append(accounts, account)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "SearchProfiles", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Searching profiles complete", "len", len(accounts))
+	return accounts, nil
+}
+
+func (ps *PostgresStore) GetAccountDetails(ctx context.Context, userId int32) (*AccountDetails, error) {
+	slog.Info("Getting account details", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT ` + accountDetailsColumns + ` FROM profiles WHERE id = $1`
+
+	account := &AccountDetails{}
+	err := account.scanRow(ps.PostgresConnection.QueryRowEx(ctx, query, nil, userId))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrProfileNotFound
+		}
+
+		slog.Error("Error getting account details", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting account details complete")
+	return account, nil
+}
+
+// RevokeSessions logs the user out everywhere.
+func (ps *PostgresStore) RevokeSessions(ctx context.Context, userId int32) error {
+	slog.Info("Revoking sessions", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	_, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM sessions WHERE userId = $1`, nil, userId)
+	if err != nil {
+		slog.Error("Error revoking sessions", "error", err)
+		return ErrDatabaseError
+	}
+
+	slog.Info("Revoking sessions complete")
+	return nil
+}
+
+func (ps *PostgresStore) SetRole(ctx context.Context, userId int32, role string) (*AccountDetails, error) {
+	slog.Info("Setting role", "user", userId, "role", role)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE profiles SET role = $2 WHERE id = $1 RETURNING ` + accountDetailsColumns
+
+	account := &AccountDetails{}
+	err := account.scanRow(ps.PostgresConnection.QueryRowEx(ctx, query, nil, userId, role))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrProfileNotFound
+		}
+
+		slog.Error("Error setting role", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Setting role complete")
+	return account, nil
+}
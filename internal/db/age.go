@@ -0,0 +1,24 @@
+package db
+
+import "time"
+
+// MinimumAge is the youngest a user can be. Profiles store their date of birth and their age is derived from it.
+const MinimumAge = 18
+
+// DateOfBirthLayout is the format dates of birth are read and written in
+const DateOfBirthLayout = "2006-01-02"
+
+// IsAdult reports whether someone born on dateOfBirth is at least MinimumAge on the day of now. Someone born on
+// 29 February turns 18 on 1 March in a year which is not a leap year, as Postgres' age function agrees.
+func IsAdult(dateOfBirth time.Time, now time.Time) bool {
+	year, month, day := dateOfBirth.Date()
+	adult := time.Date(year+MinimumAge, month, day, 0, 0, 0, 0, time.UTC)
+
+	year, month, day = now.Date()
+	return !adult.After(time.Date(year, month, day, 0, 0, 0, 0, time.UTC))
+}
+
+// ageSQL returns an expression for the age in whole years of someone born on the date in column, or 0 when it is null.
+func ageSQL(column string) string {
+	return `COALESCE(extract(year FROM age(` + column + `))::int, 0)`
+}
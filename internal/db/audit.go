@@ -0,0 +1,134 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// AuditEntry describes an entry in the audit trail. ActorId is nil for actions taken by the system
+// or by someone who is not logged in.
+type AuditEntry struct {
+	Id        int64             `json:"id"`
+	ActorId   *int32            `json:"actorId,omitempty"`
+	TargetId  *int32            `json:"targetId,omitempty"`
+	Action    string            `json:"action"`
+	Details   map[string]string `json:"details,omitempty"`
+	IP        string            `json:"ip"`
+	UserAgent string            `json:"userAgent"`
+	CreatedAt time.Time         `json:"createdAt"`
+}
+
+func (a *AuditEntry) scanRow(r rowScanner) error {
+	return r.Scan(
+		&a.Id,
+		&a.ActorId,
+		&a.TargetId,
+		&a.Action,
+		&a.Details,
+		&a.IP,
+		&a.UserAgent,
+		&a.CreatedAt,
+	)
+}
+
+// AuditFilter narrows the entries returned from the audit trail. Zero values are not filtered on.
+type AuditFilter struct {
+	ActorId  *int32
+	TargetId *int32
+	Action   string
+	Since    *time.Time
+	Until    *time.Time
+	Limit    int
+}
+
+// AuditStore describes the data access required to keep an audit trail
+type AuditStore interface {
+	RecordAudit(context.Context, AuditEntry) error
+	GetAuditLog(context.Context, AuditFilter) ([]*AuditEntry, error)
+	PruneAuditLog(context.Context, time.Time) (int64, error)
+}
+
+// RecordAudit appends an entry to the audit trail.
+func (ps *PostgresStore) RecordAudit(ctx context.Context, entry AuditEntry) error {
+	slog.Info("Recording audit entry", "action", entry.Action)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	details, err := json.Marshal(entry.Details)
+	if err != nil || entry.Details == nil {
+		details = []byte("{}")
+	}
+
+	query := `INSERT INTO audit_log (actorId, targetId, action, details, ip, userAgent) VALUES ($1, $2, $3, $4::jsonb, $5, $6)`
+
+	_, err = ps.PostgresConnection.ExecEx(ctx, query, nil, entry.ActorId, entry.TargetId, entry.Action, string(details), entry.IP, entry.UserAgent)
+	if err != nil {
+		slog.Error("Error recording audit entry", "action", entry.Action, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+// GetAuditLog returns entries matching the filter, newest first.
+func (ps *PostgresStore) GetAuditLog(ctx context.Context, filter AuditFilter) ([]*AuditEntry, error) {
+	slog.Info("Getting audit log")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT id, actorId, targetId, action, details, ip, userAgent, createdAt FROM audit_log
+				WHERE ($1::integer IS NULL OR actorId = $1)
+				AND ($2::integer IS NULL OR targetId = $2)
+				AND ($3 = '' OR action = $3)
+				AND ($4::timestamp IS NULL OR createdAt >= $4)
+				AND ($5::timestamp IS NULL OR createdAt < $5)
+				ORDER BY id DESC
+				LIMIT $6`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, filter.ActorId, filter.TargetId, filter.Action, filter.Since, filter.Until, filter.Limit)
+	if err != nil {
+		slog.Error("Error retrieving audit log", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		entry := &AuditEntry{}
+		if err := entry.scanRow(rows); err != nil {
+			slog.Error("Error scanning rows", "method", "GetAuditLog", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		entries = append(entries, entry)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "GetAuditLog", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting audit log complete", "len", len(entries))
+	return entries, nil
+}
+
+// PruneAuditLog removes entries created before the cutoff and returns how many were removed.
+func (ps *PostgresStore) PruneAuditLog(ctx context.Context, cutoff time.Time) (int64, error) {
+	slog.Info("Pruning audit log", "cutoff", cutoff)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM audit_log WHERE createdAt < $1`, nil, cutoff.UTC())
+	if err != nil {
+		slog.Error("Error pruning audit log", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	slog.Info("Pruning audit log complete", "removed", tag.RowsAffected())
+	return tag.RowsAffected(), nil
+}
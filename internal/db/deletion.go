@@ -0,0 +1,306 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/chammond14/muzz/internal/mail"
+	"github.com/jackc/pgx"
+)
+
+// DeletedProfileEmail identifies the anonymised placeholder profile which takes the place of purged profiles in
+// matches and reports, so those rows survive without holding on to the deleted user's id.
+const DeletedProfileEmail = "deleted@muzz.invalid"
+
+// PurgedProfile describes a profile removed by PurgeDeletedProfiles. The blob prefixes of its photos and the keys of
+// its data exports are returned so the caller can remove them from the blob store.
+type PurgedProfile struct {
+	Id            int32
+	PhotoPrefixes []string
+	ExportKeys    []string
+}
+
+// DeletionStore describes the data access required to delete accounts
+type DeletionStore interface {
+	RequestDeletion(context.Context, int32, string, time.Duration) (time.Time, error)
+	RequestPhoneDeletion(context.Context, int32, string, time.Duration) (time.Time, error)
+	PurgeDeletedProfiles(context.Context) ([]PurgedProfile, error)
+}
+
+// RequestDeletion schedules the profile to be purged once the grace period has passed, provided the password
+// is correct, ends its session, unregisters its devices and emails the user. Logging in again before then cancels
+// the deletion.
+func (ps *PostgresStore) RequestDeletion(ctx context.Context, userId int32, password string, gracePeriod time.Duration) (time.Time, error) {
+	slog.Info("Requesting deletion", "user", userId)
+
+	return ps.requestDeletion(ctx, userId, gracePeriod, func(storedPassword string, _ string) error {
+		if matches, _ := checkPassword(storedPassword, password); !matches {
+			return ErrPasswordIncorrect
+		}
+
+		return nil
+	})
+}
+
+// RequestPhoneDeletion schedules the profile to be purged like RequestDeletion, for a caller who has proved they hold
+// the phone by entering a code sent to it. Profiles which signed up with a phone have no password they know.
+func (ps *PostgresStore) RequestPhoneDeletion(ctx context.Context, userId int32, phone string, gracePeriod time.Duration) (time.Time, error) {
+	slog.Info("Requesting deletion with phone", "user", userId)
+
+	return ps.requestDeletion(ctx, userId, gracePeriod, func(_ string, storedPhone string) error {
+		if storedPhone == "" || storedPhone != phone {
+			return ErrPhoneCodeInvalid
+		}
+
+		return nil
+	})
+}
+
+// requestDeletion schedules the deletion once confirm accepts the profile's stored password hash and phone number.
+func (ps *PostgresStore) requestDeletion(ctx context.Context, userId int32, gracePeriod time.Duration, confirm func(string, string) error) (time.Time, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return time.Time{}, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var stored, phone, name, email, locale string
+	query := `SELECT password, COALESCE(phone, ''), name, COALESCE(email, ''), locale FROM profiles WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRowEx(ctx, query, nil, userId).Scan(&stored, &phone, &name, &email, &locale)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return time.Time{}, ErrProfileNotFound
+		}
+
+		slog.Error("Error loading profile for deletion", "error", err)
+		return time.Time{}, ErrDatabaseError
+	}
+
+	if err := confirm(stored, phone); err != nil {
+		return time.Time{}, err
+	}
+
+	var scheduledFor time.Time
+	query = `UPDATE profiles SET deletionScheduledFor = $2 WHERE id = $1 RETURNING deletionScheduledFor`
+	if err := tx.QueryRowEx(ctx, query, nil, userId, time.Now().UTC().Add(gracePeriod)).Scan(&scheduledFor); err != nil {
+		slog.Error("Error scheduling deletion", "error", err)
+		return time.Time{}, ErrDatabaseError
+	}
+
+	if _, err := tx.ExecEx(ctx, `DELETE FROM sessions WHERE userId = $1`, nil, userId); err != nil {
+		slog.Error("Error ending session for deleted profile", "user", userId, "error", err)
+		return time.Time{}, ErrDatabaseError
+	}
+
+	if _, err := tx.ExecEx(ctx, `DELETE FROM devices WHERE userId = $1`, nil, userId); err != nil {
+		slog.Error("Error unregistering devices for deleted profile", "user", userId, "error", err)
+		return time.Time{}, ErrDatabaseError
+	}
+
+	data := map[string]string{"name": name, "scheduledFor": scheduledFor.Format("2006-01-02")}
+	if err := insertMail(ctx, tx, email, mail.TemplateAccountDeletion, locale, data); err != nil {
+		return time.Time{}, err
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing deletion request", "error", err)
+		return time.Time{}, ErrDatabaseError
+	}
+
+	slog.Info("Requesting deletion complete", "user", userId, "scheduledFor", scheduledFor)
+	return scheduledFor, nil
+}
+
+// PurgeDeletedProfiles erases every profile whose deletion grace period has passed. Each profile is purged in its
+// own transaction, so a failure leaves the remaining profiles to the next run.
+func (ps *PostgresStore) PurgeDeletedProfiles(ctx context.Context) ([]PurgedProfile, error) {
+	slog.Info("Purging deleted profiles")
+
+	userIds, err := ps.dueDeletions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	purged := []PurgedProfile{}
+	for _, userId := range userIds {
+		profile, err := ps.purgeProfile(ctx, userId)
+		if err != nil {
+			return purged, err
+		}
+
+		if profile != nil {
+			purged = append(purged, *profile)
+		}
+	}
+
+	slog.Info("Purging deleted profiles complete", "len", len(purged))
+	return purged, nil
+}
+
+// dueDeletions returns the ids of the profiles whose deletion grace period has passed.
+func (ps *PostgresStore) dueDeletions(ctx context.Context) ([]int32, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT id FROM profiles WHERE deletionScheduledFor <= now() AND email IS DISTINCT FROM $1`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, DeletedProfileEmail)
+	if err != nil {
+		slog.Error("Error retrieving deleted profiles", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	var userIds []int32
+	for rows.Next() {
+		var userId int32
+		if err := rows.Scan(&userId); err != nil {
+			slog.Error("Error scanning rows", "method", "PurgeDeletedProfiles", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		userIds = append(userIds, userId)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "PurgeDeletedProfiles", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	return userIds, nil
+}
+
+// purgeProfile hard deletes a profile and everything belonging to it. Other profiles' swipe history forgets it,
+// matches and reports are handed to the placeholder profile and its audit entries are stripped of personal data.
+// It returns nil if the deletion was cancelled meanwhile. Each profile gets its own timeout, so a large backlog
+// can't run the last purges out of time.
+func (ps *PostgresStore) purgeProfile(ctx context.Context, userId int32) (*PurgedProfile, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var due bool
+	err = tx.QueryRowEx(ctx, `SELECT deletionScheduledFor <= now() FROM profiles WHERE id = $1 FOR UPDATE`, nil, userId).Scan(&due)
+	if err == pgx.ErrNoRows || err == nil && !due {
+		return nil, nil
+	}
+
+	if err != nil {
+		slog.Error("Error locking deleted profile", "user", userId, "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	// the placeholder is created by the first purge rather than with the schema, so it never takes an id the seed
+	// data expects. It is banned and paused, so it can never log in or be shown.
+	query := `INSERT INTO profiles (email, password, name, gender, visibility, bannedAt)
+				VALUES ($1, md5(random()::text), 'Deleted user', 'other', 'paused', now())
+				ON CONFLICT (email) DO NOTHING`
+
+	if _, err := tx.ExecEx(ctx, query, nil, DeletedProfileEmail); err != nil {
+		slog.Error("Error creating placeholder profile", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	var placeholderId int32
+	err = tx.QueryRowEx(ctx, `SELECT id FROM profiles WHERE email = $1`, nil, DeletedProfileEmail).Scan(&placeholderId)
+	if err != nil {
+		slog.Error("Error finding placeholder profile", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	profile := &PurgedProfile{Id: userId}
+	err = tx.QueryRowEx(ctx, `SELECT COALESCE(array_agg(blobPrefix), '{}') FROM photos WHERE userId = $1`, nil, userId).Scan(&profile.PhotoPrefixes)
+	if err != nil {
+		slog.Error("Error finding photos of deleted profile", "user", userId, "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	err = tx.QueryRowEx(ctx, `SELECT COALESCE(array_agg(blobKey), '{}') FROM data_exports WHERE userId = $1 AND blobKey IS NOT NULL`, nil, userId).Scan(&profile.ExportKeys)
+	if err != nil {
+		slog.Error("Error finding exports of deleted profile", "user", userId, "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	reassignQueries := []string{
+		`UPDATE matches SET user1Id = $2 WHERE user1Id = $1`,
+		`UPDATE matches SET user2Id = $2 WHERE user2Id = $1`,
+		`UPDATE reports SET reporterId = $2 WHERE reporterId = $1`,
+		`UPDATE reports SET reportedId = $2, details = '' WHERE reportedId = $1`,
+		`UPDATE reports SET claimedBy = $2 WHERE claimedBy = $1`,
+		`UPDATE reports SET resolvedBy = $2 WHERE resolvedBy = $1`,
+		`UPDATE photo_matches SET resolvedBy = $2 WHERE resolvedBy = $1`,
+		`UPDATE webhook_subscriptions SET createdBy = $2 WHERE createdBy = $1`,
+	}
+
+	for _, query := range reassignQueries {
+		if _, err := tx.ExecEx(ctx, query, nil, userId, placeholderId); err != nil {
+			slog.Error("Error reassigning rows of deleted profile", "user", userId, "error", err)
+			return nil, ErrDatabaseError
+		}
+	}
+
+	// the audit log is append only, so the update is allowed by a setting that only lasts for this transaction.
+	// Entries keep their ids and action, but lose the IP address, user agent and any login name typed for them.
+	if _, err := tx.ExecEx(ctx, `SELECT set_config('muzz.erasing_profile', 'on', true)`, nil); err != nil {
+		slog.Error("Error allowing audit log scrub", "user", userId, "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	query = `UPDATE audit_log SET ip = '', userAgent = '', details = details - 'username'
+				WHERE actorId = $1 OR targetId = $1
+					OR details->>'username' IN (SELECT email FROM profiles WHERE id = $1 UNION SELECT phone FROM profiles WHERE id = $1)`
+
+	if _, err := tx.ExecEx(ctx, query, nil, userId); err != nil {
+		slog.Error("Error scrubbing audit log of deleted profile", "user", userId, "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	deleteQueries := []string{
+		`UPDATE profiles SET swipedOn = array_remove(swipedOn, $1), swipedYesBy = array_remove(swipedYesBy, $1)
+			WHERE $1 = ANY (swipedOn) OR $1 = ANY (swipedYesBy)`,
+		`DELETE FROM sessions WHERE userId = $1`,
+		`DELETE FROM password_resets WHERE userId = $1`,
+		`DELETE FROM login_challenges WHERE userId = $1`,
+		`DELETE FROM recovery_codes WHERE userId = $1`,
+		`DELETE FROM two_factor WHERE userId = $1`,
+		`DELETE FROM federated_identities WHERE userId = $1`,
+		`DELETE FROM oauth_states WHERE userId = $1`,
+		`DELETE FROM preferences WHERE userId = $1`,
+		`DELETE FROM profile_prompts WHERE userId = $1`,
+		`DELETE FROM photos WHERE userId = $1`,
+		`DELETE FROM data_exports WHERE userId = $1`,
+		`DELETE FROM devices WHERE userId = $1`,
+		`DELETE FROM notification_preferences WHERE userId = $1`,
+		`DELETE FROM notifications WHERE userId = $1`,
+		`DELETE FROM phone_codes WHERE phone = (SELECT phone FROM profiles WHERE id = $1)`,
+		`DELETE FROM mail_outbox WHERE recipient = (SELECT email FROM profiles WHERE id = $1)`,
+		`DELETE FROM profiles WHERE id = $1`,
+	}
+
+	for _, query := range deleteQueries {
+		if _, err := tx.ExecEx(ctx, query, nil, userId); err != nil {
+			slog.Error("Error purging deleted profile", "user", userId, "error", err)
+			return nil, ErrDatabaseError
+		}
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing profile purge", "user", userId, "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	return profile, nil
+}
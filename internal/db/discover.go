@@ -8,19 +8,40 @@ import (
 )
 
 type DiscoverProfile struct {
-	Id             int32   `json:"id"`
-	Age            int     `json:"age"`
-	Name           string  `json:"name"`
-	Gender         string  `json:"gender"`
-	DistanceFromMe int     `json:"distanceFromMe"`
-	Lat            float64 `json:"-"`
-	Long           float64 `json:"-"`
+	Id              int32          `json:"id"`
+	Age             int            `json:"age"`
+	Name            string         `json:"name"`
+	Gender          string         `json:"gender"`
+	Bio             string         `json:"bio"`
+	Interests       []string       `json:"interests"`
+	Prompts         []PromptAnswer `json:"prompts"`
+	DistanceFromMe  int            `json:"distanceFromMe"`
+	SharedInterests int            `json:"sharedInterests"`
+	Verified        bool           `json:"verified"`
+	Lat             float64        `json:"-"`
+	Long            float64        `json:"-"`
+	// PhotoPrefixes locate the profile's photos in the blob store, primary first. The server turns them into Photos.
+	PhotoPrefixes []string            `json:"-"`
+	Photos        []map[string]string `json:"photos"`
 }
 
 type DiscoverFilters struct {
-	MinAge  int
-	MaxAge  int
-	Genders []string
+	MinAge        int
+	MaxAge        int
+	Genders       []string
+	MaxDistanceKm int
+	Location      Location
+	Mutual        bool
+	// Interests returns only profiles sharing at least one of the interests, when not empty
+	Interests []string
+}
+
+// distanceKmSQL returns an expression for the great circle distance in km between the candidate profile's lat and
+// long columns and the given parameters. It must agree with getDistanceInKm in the server package, which sorts the results.
+func distanceKmSQL(latParam string, longParam string) string {
+	return `(acos(least(1, sin(radians(` + latParam + `)) * sin(radians(p.lat))
+		+ cos(radians(` + latParam + `)) * cos(radians(p.lat)) * cos(radians(` + longParam + ` - p.long))))
+		* 180 / pi() * 60 * 1.1515 * 1.609344)`
 }
 
 func scanDiscoverRows(r *pgx.Rows) (*DiscoverProfile, error) {
@@ -30,10 +51,16 @@ func scanDiscoverRows(r *pgx.Rows) (*DiscoverProfile, error) {
 		&profile.Age,
 		&profile.Name,
 		&profile.Gender,
+		&profile.Bio,
+		&profile.Interests,
+		&profile.Prompts,
+		&profile.Verified,
 		&profile.Lat,
 		&profile.Long,
+		&profile.PhotoPrefixes,
 	)
 
+	profile.Prompts = withQuestions(profile.Prompts)
 	return profile, err
 }
 
@@ -47,14 +74,39 @@ func (ps *PostgresStore) GetDiscoverProfiles(ctx context.Context, id int32, filt
 	if len(filters.Genders) == 0 {
 		filters.Genders = []string{"male", "female", "other"}
 	}
-	query := `SELECT id, age, name, gender, lat, long FROM profiles 
-				WHERE id NOT IN (SELECT unnest(swipedOn) FROM profiles WHERE id = $1)
-				AND ($2 = 0 OR age <= $2)
-				AND ($3 = 0 OR age >= $3)
-				AND gender = ANY ($4)`
+	// paused profiles are never shown, and incognito profiles only to viewers they have liked.
+	// in mutual mode the viewer must also satisfy each candidate's stored preferences,
+	// using the location supplied with the request as the viewer's location
+	// ages are compared as dates of birth, e.g. a profile is at most 30 if it was born after this day 31 years ago
+	query := `SELECT p.id, ` + ageSQL("p.dateOfBirth") + `, p.name, p.gender, p.bio, p.interests,
+				COALESCE((SELECT json_agg(json_build_object('prompt', pa.promptId, 'answer', pa.answer) ORDER BY pa.position)
+					FROM profile_prompts pa WHERE pa.userId = p.id), '[]'),
+				(p.emailVerifiedAt IS NOT NULL OR p.phone IS NOT NULL),
+				p.lat, p.long,
+				COALESCE((SELECT array_agg(ph.blobPrefix ORDER BY ph.isPrimary DESC, ph.position) FROM photos ph WHERE ph.userId = p.id), '{}')
+				FROM profiles p
+				LEFT JOIN preferences cp ON cp.userId = p.id
+				CROSS JOIN (SELECT dateOfBirth, gender, swipedYesBy FROM profiles WHERE id = $1) viewer
+				WHERE p.id NOT IN (SELECT unnest(swipedOn) FROM profiles WHERE id = $1)
+				AND p.bannedAt IS NULL
+				AND p.deletionScheduledFor IS NULL
+				AND (p.suspendedUntil IS NULL OR p.suspendedUntil <= now())
+				AND (p.visibility = 'visible' OR (p.visibility = 'incognito' AND p.id = ANY (viewer.swipedYesBy)))
+				AND ($2 = 0 OR p.dateOfBirth > current_date - ($2 + 1) * interval '1 year')
+				AND ($3 = 0 OR p.dateOfBirth <= current_date - $3 * interval '1 year')
+				AND p.gender = ANY ($4)
+				AND ($5 = 0 OR ` + distanceKmSQL("$6", "$7") + ` <= $5)
+				AND (cardinality($9::text[]) = 0 OR p.interests && $9)
+				AND (NOT $8 OR (
+					(cp.minAge IS NULL OR viewer.dateOfBirth <= current_date - cp.minAge * interval '1 year')
+					AND (cp.maxAge IS NULL OR viewer.dateOfBirth > current_date - (cp.maxAge + 1) * interval '1 year')
+					AND (cp.genders IS NULL OR cardinality(cp.genders) = 0 OR viewer.gender = ANY (cp.genders))
+					AND (cp.maxDistanceKm IS NULL OR ` + distanceKmSQL("$6", "$7") + ` <= cp.maxDistanceKm)
+				))`
 
 	slog.Info("Discover query", "q", query)
-	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, id, filters.MaxAge, filters.MinAge, filters.Genders)
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, id, filters.MaxAge, filters.MinAge, filters.Genders,
+		filters.MaxDistanceKm, filters.Location.Lat, filters.Location.Long, filters.Mutual, filters.Interests)
 	if err != nil {
 		slog.Error("Error retrieving discover profiles", "error", err)
 		return nil, ErrDatabaseError
@@ -3,9 +3,38 @@ package db
 import "errors"
 
 var (
-	ErrQueryTimedOut       = errors.New("query timed out")
-	ErrDatabaseError       = errors.New("could not access data store")
-	ErrSwipeRequestInvalid = errors.New("failed to swipe on profile")
-	ErrNoValidSession      = errors.New("no valid session")
-	ErrLoginFailed         = errors.New("could not log in")
+	ErrQueryTimedOut           = errors.New("query timed out")
+	ErrDatabaseError           = errors.New("could not access data store")
+	ErrSwipeRequestInvalid     = errors.New("failed to swipe on profile")
+	ErrNoValidSession          = errors.New("no valid session")
+	ErrLoginFailed             = errors.New("could not log in")
+	ErrAccountSuspended        = errors.New("account is suspended")
+	ErrAccountBanned           = errors.New("account is banned")
+	ErrReportInvalid           = errors.New("could not report profile")
+	ErrReportNotFound          = errors.New("report not found")
+	ErrReportNotClaimable      = errors.New("report is not open or is claimed by another moderator")
+	ErrProfileNotFound         = errors.New("profile not found")
+	ErrPhotoNotFound           = errors.New("photo not found")
+	ErrPhotoLimitReached       = errors.New("profile already has the maximum number of photos")
+	ErrPhotoOrderInvalid       = errors.New("photo order must contain each photo exactly once")
+	ErrPhotoMatchNotFound      = errors.New("photo match not found or already resolved")
+	ErrProfilePaused           = errors.New("profile is paused, make it visible to swipe")
+	ErrPasswordIncorrect       = errors.New("password is incorrect")
+	ErrExportNotFound          = errors.New("export not found")
+	ErrWebhookNotFound         = errors.New("webhook not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found or not dead")
+	ErrDeviceNotFound          = errors.New("device not found")
+	ErrResetTokenInvalid       = errors.New("password reset link is invalid or has expired")
+	ErrEmailAlreadyVerified    = errors.New("email is already verified")
+	ErrTwoFactorEnabled        = errors.New("two factor authentication is already enabled")
+	ErrTwoFactorNotSetUp       = errors.New("two factor authentication has not been set up")
+	ErrTwoFactorCodeInvalid    = errors.New("code is incorrect")
+	ErrLoginChallengeInvalid   = errors.New("login has expired, please log in again")
+	ErrOAuthStateInvalid       = errors.New("sign in is invalid or has expired, please try again")
+	ErrIdentityNotFound        = errors.New("identity not found")
+	ErrIdentityLinked          = errors.New("an account from this provider is already linked")
+	ErrIdentityEmailInUse      = errors.New("an account already uses this email, log in and link the provider instead")
+	ErrPhoneCodeInvalid        = errors.New("code is incorrect or has expired")
+	ErrPhoneInUse              = errors.New("phone number is used by another account")
+	ErrUnderage                = errors.New("you must be 18 or over to use Muzz")
 )
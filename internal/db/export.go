@@ -0,0 +1,304 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx"
+)
+
+const (
+	ExportStatusPending = "pending"
+	ExportStatusReady   = "ready"
+	ExportStatusFailed  = "failed"
+)
+
+// DataExport describes a request for a copy of a user's data, built in the background.
+type DataExport struct {
+	Id          string     `json:"id"`
+	UserId      int32      `json:"-"`
+	Status      string     `json:"status"`
+	BlobKey     *string    `json:"-"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+const exportColumns = `id, userId, status, blobKey, createdAt, completedAt`
+
+func (e *DataExport) scanRow(r rowScanner) error {
+	return r.Scan(
+		&e.Id,
+		&e.UserId,
+		&e.Status,
+		&e.BlobKey,
+		&e.CreatedAt,
+		&e.CompletedAt,
+	)
+}
+
+// SwipeHistory describes the swipes a user has made. Likes which became matches appear in the user's matches instead.
+type SwipeHistory struct {
+	SwipedOn []int32 `json:"swipedOn"`
+	Liked    []int32 `json:"liked"`
+}
+
+// MatchRecord describes one of a user's matches from their point of view.
+type MatchRecord struct {
+	Id        int       `json:"id"`
+	UserId    int32     `json:"user"`
+	MatchedAt time.Time `json:"matchedAt"`
+}
+
+// SessionRecord describes a user's session without its token.
+type SessionRecord struct {
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// ExportData describes everything stored about a user that is included in their data export.
+type ExportData struct {
+	Profile     *PrivateProfile
+	Preferences *Preferences
+	Swipes      SwipeHistory
+	Matches     []MatchRecord
+	Sessions    []SessionRecord
+	Photos      []*Photo
+}
+
+// ExportStore describes the data access required to build personal data exports
+type ExportStore interface {
+	CreateExport(context.Context, int32) (*DataExport, bool, error)
+	ClaimStaleExports(context.Context, time.Duration) ([]*DataExport, error)
+	GetExport(context.Context, string) (*DataExport, error)
+	CompleteExport(context.Context, string, string, string) error
+	GetExportData(context.Context, int32) (*ExportData, error)
+}
+
+// CreateExport starts a new export for the user, or returns their pending export if they already have one. It
+// reports whether the export was created, in which case the caller must build it.
+func (ps *PostgresStore) CreateExport(ctx context.Context, userId int32) (*DataExport, bool, error) {
+	slog.Info("Creating export", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return nil, false, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	// locking the profile stops two requests at once from both creating an export
+	if _, err := tx.ExecEx(ctx, `SELECT 1 FROM profiles WHERE id = $1 FOR UPDATE`, nil, userId); err != nil {
+		slog.Error("Error locking profile for export", "user", userId, "error", err)
+		return nil, false, ErrDatabaseError
+	}
+
+	export := &DataExport{}
+	query := `SELECT ` + exportColumns + ` FROM data_exports WHERE userId = $1 AND status = 'pending' ORDER BY createdAt LIMIT 1`
+	err = export.scanRow(tx.QueryRowEx(ctx, query, nil, userId))
+	if err == nil {
+		slog.Info("Creating export complete, already pending", "export", export.Id)
+		return export, false, nil
+	}
+
+	if err != pgx.ErrNoRows {
+		slog.Error("Error finding pending export", "error", err)
+		return nil, false, ErrDatabaseError
+	}
+
+	query = `INSERT INTO data_exports (id, userId) VALUES ($1, $2)
+				RETURNING ` + exportColumns
+
+	if err := export.scanRow(tx.QueryRowEx(ctx, query, nil, uuid.New().String(), userId)); err != nil {
+		slog.Error("Error creating export", "error", err)
+		return nil, false, ErrDatabaseError
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing export", "error", err)
+		return nil, false, ErrDatabaseError
+	}
+
+	slog.Info("Creating export complete", "export", export.Id)
+	return export, true, nil
+}
+
+// ClaimStaleExports returns the exports which have been pending for longer than staleAfter, such as those whose
+// build was cut off by a restart, restarting the clock on each so they are only claimed once.
+func (ps *PostgresStore) ClaimStaleExports(ctx context.Context, staleAfter time.Duration) ([]*DataExport, error) {
+	slog.Info("Claiming stale exports")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE data_exports SET attemptedAt = now()
+				WHERE status = 'pending' AND attemptedAt <= now() - $1 * interval '1 second'
+				RETURNING ` + exportColumns
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, staleAfter.Seconds())
+	if err != nil {
+		slog.Error("Error claiming stale exports", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	exports := []*DataExport{}
+	for rows.Next() {
+		export := &DataExport{}
+		if err := export.scanRow(rows); err != nil {
+			slog.Error("Error scanning rows", "method", "ClaimStaleExports", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		exports = append(exports, export)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "ClaimStaleExports", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Claiming stale exports complete", "len", len(exports))
+	return exports, nil
+}
+
+func (ps *PostgresStore) GetExport(ctx context.Context, id string) (*DataExport, error) {
+	slog.Info("Getting export", "export", id)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT ` + exportColumns + ` FROM data_exports WHERE id = $1`
+
+	export := &DataExport{}
+	err := export.scanRow(ps.PostgresConnection.QueryRowEx(ctx, query, nil, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrExportNotFound
+		}
+
+		slog.Error("Error getting export", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting export complete")
+	return export, nil
+}
+
+// CompleteExport records the outcome of building an export. The blob key is only kept when the status is ready.
+func (ps *PostgresStore) CompleteExport(ctx context.Context, id string, status string, blobKey string) error {
+	slog.Info("Completing export", "export", id, "status", status)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE data_exports SET status = $2, blobKey = NULLIF($3, ''), completedAt = now() WHERE id = $1`
+
+	if _, err := ps.PostgresConnection.ExecEx(ctx, query, nil, id, status, blobKey); err != nil {
+		slog.Error("Error completing export", "error", err)
+		return ErrDatabaseError
+	}
+
+	slog.Info("Completing export complete")
+	return nil
+}
+
+// GetExportData collects everything stored about the user, apart from photo files which the caller reads from the blob store.
+func (ps *PostgresStore) GetExportData(ctx context.Context, userId int32) (*ExportData, error) {
+	slog.Info("Getting export data", "user", userId)
+
+	profile, err := ps.GetProfile(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	data := &ExportData{Profile: profile.Private()}
+	data.Profile.Prompts, err = ps.GetPromptAnswers(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	data.Preferences, err = ps.GetPreferences(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	data.Photos, err = ps.GetPhotos(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT COALESCE(swipedOn, '{}'),
+				COALESCE((SELECT array_agg(id ORDER BY id) FROM profiles WHERE $1 = ANY (swipedYesBy)), '{}')
+				FROM profiles WHERE id = $1`
+
+	err = ps.PostgresConnection.QueryRowEx(ctx, query, nil, userId).Scan(&data.Swipes.SwipedOn, &data.Swipes.Liked)
+	if err != nil {
+		slog.Error("Error getting swipes for export", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	query = `SELECT id, CASE WHEN user1Id = $1 THEN user2Id ELSE user1Id END, matchedAt
+				FROM matches WHERE user1Id = $1 OR user2Id = $1 ORDER BY matchedAt`
+
+	data.Matches = []MatchRecord{}
+	err = ps.queryExportRows(ctx, "matches", query, userId, func(r rowScanner) error {
+		match := MatchRecord{}
+		if err := r.Scan(&match.Id, &match.UserId, &match.MatchedAt); err != nil {
+			return err
+		}
+
+		data.Matches = append(data.Matches, match)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data.Sessions = []SessionRecord{}
+	err = ps.queryExportRows(ctx, "sessions", `SELECT expiresAt FROM sessions WHERE userId = $1`, userId, func(r rowScanner) error {
+		session := SessionRecord{}
+		if err := r.Scan(&session.ExpiresAt); err != nil {
+			return err
+		}
+
+		data.Sessions = append(data.Sessions, session)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Getting export data complete")
+	return data, nil
+}
+
+func (ps *PostgresStore) queryExportRows(ctx context.Context, name string, query string, userId int32, scan func(rowScanner) error) error {
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, userId)
+	if err != nil {
+		slog.Error("Error getting export data", "data", name, "error", err)
+		return ErrDatabaseError
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			slog.Error("Error scanning rows", "method", "GetExportData", "data", name, "error", err)
+			return ErrDatabaseError
+		}
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "GetExportData", "data", name, "error", rows.Err())
+		return ErrDatabaseError
+	}
+
+	return nil
+}
@@ -0,0 +1,337 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/chammond14/muzz/internal/events"
+	"github.com/chammond14/muzz/internal/oidc"
+	"github.com/jackc/pgx"
+)
+
+// FederatedIdentity describes an account at an OpenID Connect provider linked to a profile.
+type FederatedIdentity struct {
+	Provider  string    `json:"provider"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// OAuthState is kept between starting a sign in with a provider and its callback. UserId is set when a logged in
+// user is linking the provider to their profile rather than logging in.
+type OAuthState struct {
+	StateHash string
+	Provider  string
+	Nonce     string
+	Verifier  string
+	UserId    *int32
+}
+
+// IdentityStore describes the data access required to log in with and link OpenID Connect providers
+type IdentityStore interface {
+	CreateOAuthState(context.Context, OAuthState, time.Duration) error
+	ConsumeOAuthState(context.Context, string, string) (*OAuthState, error)
+	FederatedLogin(context.Context, string, oidc.Identity) (int32, bool, error)
+	CreateFederatedProfile(context.Context, string, oidc.Identity, time.Time, string, string, string, Location) (*Profile, error)
+	LinkIdentity(context.Context, int32, string, oidc.Identity) error
+	UnlinkIdentity(context.Context, int32, string) error
+	GetIdentities(context.Context, int32) ([]*FederatedIdentity, error)
+	PurgeExpiredOAuthStates(context.Context) (int64, error)
+}
+
+func (ps *PostgresStore) CreateOAuthState(ctx context.Context, state OAuthState, ttl time.Duration) error {
+	slog.Info("Creating oauth state", "provider", state.Provider)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `INSERT INTO oauth_states (stateHash, provider, nonce, verifier, userId, expiresAt) VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := ps.PostgresConnection.ExecEx(ctx, query, nil, state.StateHash, state.Provider, state.Nonce, state.Verifier, state.UserId, time.Now().UTC().Add(ttl))
+	if err != nil {
+		slog.Error("Error creating oauth state", "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+// ConsumeOAuthState removes and returns an unexpired state for the provider, so each callback can only be used once.
+func (ps *PostgresStore) ConsumeOAuthState(ctx context.Context, stateHash string, provider string) (*OAuthState, error) {
+	slog.Info("Consuming oauth state", "provider", provider)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `DELETE FROM oauth_states WHERE stateHash = $1 AND provider = $2 AND expiresAt > now()
+				RETURNING stateHash, provider, nonce, verifier, userId`
+
+	state := &OAuthState{}
+	err := ps.PostgresConnection.QueryRowEx(ctx, query, nil, stateHash, provider).
+		Scan(&state.StateHash, &state.Provider, &state.Nonce, &state.Verifier, &state.UserId)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrOAuthStateInvalid
+		}
+
+		slog.Error("Error consuming oauth state", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	return state, nil
+}
+
+// FederatedLogin finds the profile linked to the identity, returning its id and whether it also needs a second factor.
+// An identity which is not linked yet is linked to the profile with the same email, as long as both the provider and
+// the profile have verified it. ErrIdentityNotFound is returned when there is no such profile, and
+// ErrIdentityEmailInUse when a profile has the email but it cannot be linked.
+func (ps *PostgresStore) FederatedLogin(ctx context.Context, provider string, identity oidc.Identity) (int32, bool, error) {
+	slog.Info("Logging in with identity", "provider", provider)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return 0, false, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var userId int32
+	query := `UPDATE federated_identities SET email = $3 WHERE provider = $1 AND subject = $2 RETURNING userId`
+	err = tx.QueryRowEx(ctx, query, nil, provider, identity.Subject, identity.Email).Scan(&userId)
+	if err == pgx.ErrNoRows {
+		userId, err = linkIdentityByEmail(ctx, tx, provider, identity)
+		if err != nil {
+			return 0, false, err
+		}
+	} else if err != nil {
+		slog.Error("Error finding identity", "provider", provider, "error", err)
+		return 0, false, ErrDatabaseError
+	}
+
+	var twoFactor bool
+	query = `SELECT EXISTS (SELECT 1 FROM two_factor WHERE userId = $1 AND confirmedAt IS NOT NULL)`
+	if err := tx.QueryRowEx(ctx, query, nil, userId).Scan(&twoFactor); err != nil {
+		slog.Error("Error checking two factor authentication", "user", userId, "error", err)
+		return 0, false, ErrDatabaseError
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing identity login", "error", err)
+		return 0, false, ErrDatabaseError
+	}
+
+	slog.Info("Logging in with identity complete", "provider", provider, "user", userId)
+	return userId, twoFactor, nil
+}
+
+// CreateFederatedProfile creates a profile for an identity which is not linked to one, linked to the identity.
+// The email counts as verified when the provider has verified it.
+func (ps *PostgresStore) CreateFederatedProfile(ctx context.Context, provider string, identity oidc.Identity, dateOfBirth time.Time, name string, gender string, password string, location Location) (*Profile, error) {
+	slog.Info("Creating profile for identity", "provider", provider)
+
+	if !IsAdult(dateOfBirth, time.Now()) {
+		return nil, ErrUnderage
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	query := `INSERT INTO profiles (dateOfBirth, name, gender, email, password, lat, long, emailVerifiedAt)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, CASE WHEN $8 THEN now() END)
+				ON CONFLICT (email) DO NOTHING
+				RETURNING ` + profileColumns
+
+	row := tx.QueryRowEx(ctx, query, nil, dateOfBirth, name, gender, identity.Email, hash, location.Lat, location.Long, identity.EmailVerified)
+	profile := &Profile{}
+	err = profile.scanRow(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrIdentityEmailInUse
+		}
+
+		slog.Error("Error creating profile", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	if err := insertIdentity(ctx, tx, profile.Id, provider, identity); err != nil {
+		return nil, err
+	}
+
+	err = insertEvent(ctx, tx, events.TypeProfileCreated, events.AggregateProfile, strconv.Itoa(int(profile.Id)), events.ProfileCreated{ProfileId: profile.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing profile", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Creating profile for identity complete", "provider", provider, "user", profile.Id)
+	return profile, nil
+}
+
+// LinkIdentity links an identity to the profile. A profile can link one identity from each provider, and an identity
+// already linked to another profile cannot be linked.
+func (ps *PostgresStore) LinkIdentity(ctx context.Context, userId int32, provider string, identity oidc.Identity) error {
+	slog.Info("Linking identity", "user", userId, "provider", provider)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var linked bool
+	query := `SELECT EXISTS (SELECT 1 FROM federated_identities WHERE provider = $1 AND (subject = $2 OR userId = $3))`
+	if err := tx.QueryRowEx(ctx, query, nil, provider, identity.Subject, userId).Scan(&linked); err != nil {
+		slog.Error("Error finding linked identities", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	if linked {
+		return ErrIdentityLinked
+	}
+
+	if err := insertIdentity(ctx, tx, userId, provider, identity); err != nil {
+		return err
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing identity", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	slog.Info("Linking identity complete", "user", userId, "provider", provider)
+	return nil
+}
+
+// UnlinkIdentity removes the profile's identity from the provider.
+func (ps *PostgresStore) UnlinkIdentity(ctx context.Context, userId int32, provider string) error {
+	slog.Info("Unlinking identity", "user", userId, "provider", provider)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM federated_identities WHERE userId = $1 AND provider = $2`, nil, userId, provider)
+	if err != nil {
+		slog.Error("Error unlinking identity", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrIdentityNotFound
+	}
+
+	slog.Info("Unlinking identity complete", "user", userId, "provider", provider)
+	return nil
+}
+
+// GetIdentities returns the identities linked to the profile, oldest first.
+func (ps *PostgresStore) GetIdentities(ctx context.Context, userId int32) ([]*FederatedIdentity, error) {
+	slog.Info("Getting identities", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT provider, email, createdAt FROM federated_identities WHERE userId = $1 ORDER BY createdAt`
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, userId)
+	if err != nil {
+		slog.Error("Error retrieving identities", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	identities := []*FederatedIdentity{}
+	for rows.Next() {
+		identity := &FederatedIdentity{}
+		if err := rows.Scan(&identity.Provider, &identity.Email, &identity.CreatedAt); err != nil {
+			slog.Error("Error scanning rows", "method", "GetIdentities", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		identities = append(identities, identity)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "GetIdentities", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting identities complete", "len", len(identities))
+	return identities, nil
+}
+
+// PurgeExpiredOAuthStates removes sign ins which were started but never completed, returning how many were removed.
+func (ps *PostgresStore) PurgeExpiredOAuthStates(ctx context.Context) (int64, error) {
+	slog.Info("Purging expired oauth states")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM oauth_states WHERE expiresAt <= now()`, nil)
+	if err != nil {
+		slog.Error("Error purging expired oauth states", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	slog.Info("Purging expired oauth states complete", "removed", tag.RowsAffected())
+	return tag.RowsAffected(), nil
+}
+
+// linkIdentityByEmail links the identity to the profile with the same email, as long as both the provider and the
+// profile have verified it, returning the id of the profile.
+func linkIdentityByEmail(ctx context.Context, tx *pgx.Tx, provider string, identity oidc.Identity) (int32, error) {
+	var userId int32
+	var emailVerified bool
+	err := tx.QueryRowEx(ctx, `SELECT id, emailVerifiedAt IS NOT NULL FROM profiles WHERE email = $1`, nil, identity.Email).
+		Scan(&userId, &emailVerified)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, ErrIdentityNotFound
+		}
+
+		slog.Error("Error finding profile for identity", "provider", provider, "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	// linking without both sides verifying the email would let whoever registered it first take the other's account
+	if !identity.EmailVerified || !emailVerified {
+		slog.Info("Identity email belongs to a profile which cannot be linked", "provider", provider, "user", userId)
+		return 0, ErrIdentityEmailInUse
+	}
+
+	return userId, insertIdentity(ctx, tx, userId, provider, identity)
+}
+
+func insertIdentity(ctx context.Context, tx *pgx.Tx, userId int32, provider string, identity oidc.Identity) error {
+	query := `INSERT INTO federated_identities (provider, subject, userId, email) VALUES ($1, $2, $3, $4)`
+	if _, err := tx.ExecEx(ctx, query, nil, provider, identity.Subject, userId, identity.Email); err != nil {
+		slog.Error("Error linking identity", "user", userId, "provider", provider, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package db
+
+import (
+	"context"
+	"hash/fnv"
+	"log/slog"
+	"time"
+)
+
+const (
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// JobState describes the last and next run of a background job, as stored in the db.
+type JobState struct {
+	Name       string     `json:"name"`
+	NextRunAt  time.Time  `json:"nextRunAt"`
+	LastRunAt  *time.Time `json:"lastRunAt,omitempty"`
+	LastStatus *string    `json:"lastStatus,omitempty"`
+	LastError  *string    `json:"lastError,omitempty"`
+	DurationMs *int64     `json:"durationMs,omitempty"`
+	RunCount   int64      `json:"runCount"`
+}
+
+// JobStore describes the data access required to coordinate background jobs between replicas
+type JobStore interface {
+	RunJob(context.Context, string, func(time.Time) time.Time, func(context.Context) error) (bool, error)
+	GetJobs(context.Context) ([]*JobState, error)
+	PurgeExpiredSessions(context.Context) (int64, error)
+}
+
+// jobLockKey derives the advisory lock key for a job, namespaced so it cannot collide with other advisory locks.
+func jobLockKey(name string) int64 {
+	hash := fnv.New64a()
+	hash.Write([]byte("muzz.job." + name))
+
+	return int64(hash.Sum64())
+}
+
+// RunJob runs the job if it is due, holding a transaction level advisory lock for the job throughout so only one
+// replica can run it. The job's own queries are not part of the transaction, so a run whose outcome fails to be
+// recorded is repeated, giving at least once execution.
+func (ps *PostgresStore) RunJob(ctx context.Context, name string, next func(time.Time) time.Time, run func(context.Context) error) (bool, error) {
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return false, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var locked bool
+	if err := tx.QueryRowEx(ctx, `SELECT pg_try_advisory_xact_lock($1)`, nil, jobLockKey(name)).Scan(&locked); err != nil {
+		slog.Error("Error locking job", "job", name, "error", err)
+		return false, ErrDatabaseError
+	}
+
+	if !locked {
+		return false, nil
+	}
+
+	now := time.Now().UTC()
+	query := `INSERT INTO jobs (name, nextRunAt) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING`
+	if _, err := tx.ExecEx(ctx, query, nil, name, next(now)); err != nil {
+		slog.Error("Error registering job", "job", name, "error", err)
+		return false, ErrDatabaseError
+	}
+
+	var nextRunAt time.Time
+	if err := tx.QueryRowEx(ctx, `SELECT nextRunAt FROM jobs WHERE name = $1`, nil, name).Scan(&nextRunAt); err != nil {
+		slog.Error("Error reading job", "job", name, "error", err)
+		return false, ErrDatabaseError
+	}
+
+	if nextRunAt.After(now) {
+		return false, nil
+	}
+
+	slog.Info("Running job", "job", name, "due", nextRunAt)
+	runErr := run(ctx)
+	finished := time.Now().UTC()
+
+	status, lastError := JobStatusSucceeded, (*string)(nil)
+	if runErr != nil {
+		message := runErr.Error()
+		status, lastError = JobStatusFailed, &message
+	} else {
+		nextRunAt = next(finished)
+	}
+
+	query = `UPDATE jobs SET nextRunAt = $2, lastRunAt = $3, lastStatus = $4, lastError = $5, durationMs = $6, runCount = runCount + 1
+				WHERE name = $1`
+	_, err = tx.ExecEx(ctx, query, nil, name, nextRunAt, now, status, lastError, finished.Sub(now).Milliseconds())
+	if err != nil {
+		slog.Error("Error recording job run", "job", name, "error", err)
+		return true, ErrDatabaseError
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing job run", "job", name, "error", err)
+		return true, ErrDatabaseError
+	}
+
+	return true, runErr
+}
+
+// GetJobs returns the state of every job which has been registered by a replica.
+func (ps *PostgresStore) GetJobs(ctx context.Context) ([]*JobState, error) {
+	slog.Info("Getting jobs")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT name, nextRunAt, lastRunAt, lastStatus, lastError, durationMs, runCount FROM jobs ORDER BY name`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil)
+	if err != nil {
+		slog.Error("Error retrieving jobs", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	jobs := []*JobState{}
+	for rows.Next() {
+		job := &JobState{}
+		err := rows.Scan(&job.Name, &job.NextRunAt, &job.LastRunAt, &job.LastStatus, &job.LastError, &job.DurationMs, &job.RunCount)
+		if err != nil {
+			slog.Error("Error scanning rows", "method", "GetJobs", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "GetJobs", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting jobs complete", "len", len(jobs))
+	return jobs, nil
+}
+
+// PurgeExpiredSessions deletes sessions which have expired, returning how many were removed.
+func (ps *PostgresStore) PurgeExpiredSessions(ctx context.Context) (int64, error) {
+	slog.Info("Purging expired sessions")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	result, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM sessions WHERE expiresAt <= now()`, nil)
+	if err != nil {
+		slog.Error("Error purging expired sessions", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	slog.Info("Purging expired sessions complete", "removed", result.RowsAffected())
+	return result.RowsAffected(), nil
+}
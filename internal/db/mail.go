@@ -0,0 +1,132 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/chammond14/muzz/internal/mail"
+	"github.com/jackc/pgx"
+)
+
+// MailStore describes the data access required to queue and send email
+type MailStore interface {
+	ClaimMail(context.Context, int, time.Duration) ([]mail.Queued, error)
+	RecordMailAttempt(context.Context, int64, mail.Result) error
+	PruneMail(context.Context, time.Time) (int64, error)
+}
+
+// insertMail queues an email as part of the transaction making the change it describes, so it is sent exactly
+// when the change is committed. The template is rendered with data when the email is sent.
+// Nothing is queued for profiles without an email, which signed up with a phone number.
+func insertMail(ctx context.Context, tx *pgx.Tx, to string, template string, locale string, data any) error {
+	if to == "" {
+		slog.Info("Not queueing email for profile without an email", "template", template)
+		return nil
+	}
+
+	body, err := json.Marshal(data)
+	if err != nil {
+		slog.Error("Error encoding email data", "template", template, "error", err)
+		return ErrDatabaseError
+	}
+
+	query := `INSERT INTO mail_outbox (recipient, template, locale, data) VALUES ($1, $2, $3, $4::jsonb)`
+	if _, err := tx.ExecEx(ctx, query, nil, to, template, locale, string(body)); err != nil {
+		slog.Error("Error queueing email", "template", template, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+// ClaimMail returns due emails, pushing their next attempt back by the lease so that other replicas skip them
+// while they are sent. An email whose outcome is never recorded is sent again after the lease.
+func (ps *PostgresStore) ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]mail.Queued, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE mail_outbox SET nextAttemptAt = $2
+				WHERE id IN (
+					SELECT id FROM mail_outbox
+					WHERE status = 'pending' AND nextAttemptAt <= now()
+					ORDER BY id
+					LIMIT $1
+					FOR UPDATE SKIP LOCKED
+				)
+				RETURNING id, recipient, template, locale, data::text, attempts`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, limit, time.Now().UTC().Add(lease))
+	if err != nil {
+		slog.Error("Error claiming email", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	queued := []mail.Queued{}
+	for rows.Next() {
+		email := mail.Queued{}
+		var data string
+		if err := rows.Scan(&email.Id, &email.To, &email.Template, &email.Locale, &data, &email.Attempts); err != nil {
+			slog.Error("Error scanning rows", "method", "ClaimMail", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		email.Data = json.RawMessage(data)
+		queued = append(queued, email)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "ClaimMail", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	return queued, nil
+}
+
+// RecordMailAttempt stores the outcome of an attempt to send an email. Once an email is sent or dead its data is
+// cleared, since it can contain links which must not outlive the email.
+func (ps *PostgresStore) RecordMailAttempt(ctx context.Context, id int64, result mail.Result) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	var nextAttemptAt *time.Time
+	if result.Status == mail.StatusPending {
+		next := result.NextAttemptAt.UTC()
+		nextAttemptAt = &next
+	}
+
+	query := `UPDATE mail_outbox SET
+				status = $2,
+				attempts = attempts + CASE WHEN $2 = 'sent' THEN 0 ELSE 1 END,
+				lastError = $3,
+				nextAttemptAt = COALESCE($4, nextAttemptAt),
+				data = CASE WHEN $2 = 'pending' THEN data ELSE '{}' END,
+				sentAt = CASE WHEN $2 = 'sent' THEN now() END
+				WHERE id = $1`
+
+	if _, err := ps.PostgresConnection.ExecEx(ctx, query, nil, id, result.Status, result.Error, nextAttemptAt); err != nil {
+		slog.Error("Error recording email attempt", "email", id, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+// PruneMail removes sent and dead emails created before the cutoff, returning how many were removed.
+func (ps *PostgresStore) PruneMail(ctx context.Context, cutoff time.Time) (int64, error) {
+	slog.Info("Pruning mail outbox", "cutoff", cutoff)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM mail_outbox WHERE status <> 'pending' AND createdAt < $1`, nil, cutoff.UTC())
+	if err != nil {
+		slog.Error("Error pruning mail outbox", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	slog.Info("Pruning mail outbox complete", "removed", tag.RowsAffected())
+	return tag.RowsAffected(), nil
+}
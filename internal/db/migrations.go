@@ -0,0 +1,90 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/jackc/pgx"
+)
+
+// migration is a one-off change to existing data. Unlike the schema, which is applied on every start and must be safe
+// to repeat, each migration is applied once and recorded in schema_migrations.
+type migration struct {
+	name  string
+	query string
+}
+
+// migrations are applied in order. Append new ones, and never change or remove one which may have been applied.
+var migrations = []migration{
+	// profiles stored an age, which never grew, before dates of birth. The date of birth is estimated from the age when
+	// the profile was created, and profiles which said they were under 18 are queued for moderators to review.
+	{"0001_date_of_birth", `
+		UPDATE profiles SET dateOfBirth = (createdAt - age * interval '1 year')::date
+			WHERE dateOfBirth IS NULL AND age IS NOT NULL AND email IS DISTINCT FROM '` + DeletedProfileEmail + `';
+
+		INSERT INTO reports (reportedId, reason, details)
+			SELECT id, 'underage', 'Flagged when dates of birth were introduced, as the profile gave its age as ' || age
+			FROM profiles
+			WHERE age < 18 AND email IS DISTINCT FROM '` + DeletedProfileEmail + `';`},
+}
+
+// applyMigrations applies the migrations which have not been applied yet. Replicas starting together wait on each
+// other's transaction, so each migration is only applied once.
+func applyMigrations(tx *pgx.Tx) error {
+	for _, m := range migrations {
+		tag, err := tx.Exec(`INSERT INTO schema_migrations (name) VALUES ($1) ON CONFLICT (name) DO NOTHING`, m.name)
+		if err != nil {
+			return err
+		}
+
+		if tag.RowsAffected() == 0 {
+			continue
+		}
+
+		slog.Info("Applying migration", "migration", m.name)
+		if _, err := tx.Exec(m.query); err != nil {
+			slog.Error("Error applying migration", "migration", m.name, "error", err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PendingMigrations returns the names of the migrations which have not been applied, in order.
+func (ps *PostgresStore) PendingMigrations(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, `SELECT name FROM schema_migrations`, nil)
+	if err != nil {
+		slog.Error("Error getting applied migrations", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	applied := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			slog.Error("Error scanning applied migrations", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		applied[name] = true
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error getting applied migrations", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	pending := []string{}
+	for _, m := range migrations {
+		if !applied[m.name] {
+			pending = append(pending, m.name)
+		}
+	}
+
+	return pending, nil
+}
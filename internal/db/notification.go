@@ -0,0 +1,269 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/chammond14/muzz/internal/notify"
+	"github.com/jackc/pgx"
+)
+
+// Device describes a device registered to receive a user's notifications.
+type Device struct {
+	Token     string    `json:"token"`
+	Platform  string    `json:"platform"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// NotificationStore describes the data access required to register devices and queue push notifications
+type NotificationStore interface {
+	RegisterDevice(context.Context, int32, string, string) (*Device, error)
+	DeleteDevice(context.Context, int32, string) error
+	GetNotificationPreferences(context.Context, int32) (notify.Preferences, error)
+	SetNotificationPreferences(context.Context, int32, notify.Preferences) (notify.Preferences, error)
+	EnqueueMatchNotification(context.Context, int32, int) error
+	EnqueueLikeNotification(context.Context, int32, int32, time.Time) error
+	PruneNotifications(context.Context, time.Time) (int64, error)
+	ClaimNotifications(context.Context, int, time.Duration) ([]notify.Pending, error)
+	RecordNotification(context.Context, int64, string, *time.Time) error
+	RemoveDevice(context.Context, string) error
+}
+
+// RegisterDevice records a device token for the user. A token already registered, to this or another user,
+// is moved to the user since it now belongs to whoever last logged in on the device.
+func (ps *PostgresStore) RegisterDevice(ctx context.Context, userId int32, token string, platform string) (*Device, error) {
+	slog.Info("Registering device", "user", userId, "platform", platform)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `INSERT INTO devices (token, userId, platform) VALUES ($1, $2, $3)
+				ON CONFLICT (token) DO UPDATE SET userId = $2, platform = $3, createdAt = DEFAULT
+				RETURNING token, platform, createdAt`
+
+	device := &Device{}
+	err := ps.PostgresConnection.QueryRowEx(ctx, query, nil, token, userId, platform).Scan(&device.Token, &device.Platform, &device.CreatedAt)
+	if err != nil {
+		slog.Error("Error registering device", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Registering device complete")
+	return device, nil
+}
+
+// DeleteDevice unregisters one of the user's devices.
+func (ps *PostgresStore) DeleteDevice(ctx context.Context, userId int32, token string) error {
+	slog.Info("Deleting device", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM devices WHERE token = $1 AND userId = $2`, nil, token, userId)
+	if err != nil {
+		slog.Error("Error deleting device", "error", err)
+		return ErrDatabaseError
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrDeviceNotFound
+	}
+
+	slog.Info("Deleting device complete")
+	return nil
+}
+
+// RemoveDevice unregisters a device token a provider has rejected, whoever it belongs to.
+func (ps *PostgresStore) RemoveDevice(ctx context.Context, token string) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	if _, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM devices WHERE token = $1`, nil, token); err != nil {
+		slog.Error("Error removing device", "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+// GetNotificationPreferences returns the user's notification preferences, or the defaults if none have been set.
+func (ps *PostgresStore) GetNotificationPreferences(ctx context.Context, userId int32) (notify.Preferences, error) {
+	slog.Info("Getting notification preferences", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT matches, likes, quietStart, quietEnd, timezone FROM notification_preferences WHERE userId = $1`
+
+	preferences := notify.Preferences{}
+	err := ps.PostgresConnection.QueryRowEx(ctx, query, nil, userId).Scan(
+		&preferences.Matches, &preferences.Likes, &preferences.QuietStart, &preferences.QuietEnd, &preferences.Timezone)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return notify.DefaultPreferences(), nil
+		}
+
+		slog.Error("Error getting notification preferences", "error", err)
+		return notify.Preferences{}, ErrDatabaseError
+	}
+
+	slog.Info("Getting notification preferences complete")
+	return preferences, nil
+}
+
+// SetNotificationPreferences replaces the user's notification preferences.
+func (ps *PostgresStore) SetNotificationPreferences(ctx context.Context, userId int32, preferences notify.Preferences) (notify.Preferences, error) {
+	slog.Info("Setting notification preferences", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `INSERT INTO notification_preferences (userId, matches, likes, quietStart, quietEnd, timezone)
+				VALUES ($1, $2, $3, $4, $5, $6)
+				ON CONFLICT (userId) DO UPDATE SET
+				matches = $2, likes = $3, quietStart = $4, quietEnd = $5, timezone = $6, updatedAt = DEFAULT
+				RETURNING matches, likes, quietStart, quietEnd, timezone`
+
+	stored := notify.Preferences{}
+	err := ps.PostgresConnection.QueryRowEx(ctx, query, nil, userId,
+		preferences.Matches, preferences.Likes, preferences.QuietStart, preferences.QuietEnd, preferences.Timezone).Scan(
+		&stored.Matches, &stored.Likes, &stored.QuietStart, &stored.QuietEnd, &stored.Timezone)
+	if err != nil {
+		slog.Error("Error setting notification preferences", "error", err)
+		return notify.Preferences{}, ErrDatabaseError
+	}
+
+	slog.Info("Setting notification preferences complete")
+	return stored, nil
+}
+
+// EnqueueMatchNotification queues a notification of the match for the user. A match already queued for the
+// user is skipped, so an event delivered twice notifies once.
+func (ps *PostgresStore) EnqueueMatchNotification(ctx context.Context, userId int32, matchId int) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `INSERT INTO notifications (userId, kind, sourceIds)
+				SELECT $1, 'match', ARRAY[$2::integer]
+				WHERE NOT EXISTS (SELECT 1 FROM notifications WHERE userId = $1 AND kind = 'match' AND $2 = ANY (sourceIds))`
+
+	if _, err := ps.PostgresConnection.ExecEx(ctx, query, nil, userId, matchId); err != nil {
+		slog.Error("Error queueing match notification", "user", userId, "match", matchId, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+// EnqueueLikeNotification adds a like to the user's pending likes digest, starting a digest to be sent at
+// sendAfter if none is pending. Each liker is counted once however often the like is delivered.
+func (ps *PostgresStore) EnqueueLikeNotification(ctx context.Context, userId int32, likerId int32, sendAfter time.Time) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `INSERT INTO notifications (userId, kind, sourceIds, sendAfter) VALUES ($1, 'likes', ARRAY[$2::integer], $3)
+				ON CONFLICT (userId) WHERE kind = 'likes' AND sentAt IS NULL DO UPDATE SET
+				sourceIds = CASE WHEN $2 = ANY (notifications.sourceIds) THEN notifications.sourceIds
+					ELSE array_append(notifications.sourceIds, $2) END`
+
+	if _, err := ps.PostgresConnection.ExecEx(ctx, query, nil, userId, likerId, sendAfter); err != nil {
+		slog.Error("Error queueing like notification", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+// ClaimNotifications returns due notifications with the user's devices and preferences, pushing them back by the
+// lease so that other replicas skip them while they are sent.
+func (ps *PostgresStore) ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]notify.Pending, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE notifications n SET sendAfter = $2
+				FROM profiles p
+				LEFT JOIN notification_preferences np ON np.userId = p.id
+				WHERE p.id = n.userId
+				AND n.id IN (
+					SELECT id FROM notifications
+					WHERE sentAt IS NULL AND sendAfter <= now()
+					ORDER BY sendAfter
+					LIMIT $1
+					FOR UPDATE SKIP LOCKED
+				)
+				RETURNING n.id, n.userId, n.kind, cardinality(n.sourceIds),
+				COALESCE(np.matches, true), COALESCE(np.likes, true), np.quietStart, np.quietEnd, COALESCE(np.timezone, 'UTC'),
+				COALESCE((SELECT array_agg(d.token ORDER BY d.token) FROM devices d WHERE d.userId = n.userId), '{}'),
+				COALESCE((SELECT array_agg(d.platform ORDER BY d.token) FROM devices d WHERE d.userId = n.userId), '{}')`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, limit, time.Now().UTC().Add(lease))
+	if err != nil {
+		slog.Error("Error claiming notifications", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	pending := []notify.Pending{}
+	for rows.Next() {
+		notification := notify.Pending{}
+		var tokens, platforms []string
+		err := rows.Scan(&notification.Id, &notification.UserId, &notification.Kind, &notification.Count,
+			&notification.Preferences.Matches, &notification.Preferences.Likes, &notification.Preferences.QuietStart,
+			&notification.Preferences.QuietEnd, &notification.Preferences.Timezone, &tokens, &platforms)
+		if err != nil {
+			slog.Error("Error scanning rows", "method", "ClaimNotifications", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		for i := range tokens {
+			notification.Devices = append(notification.Devices, notify.Device{Token: tokens[i], Platform: platforms[i]})
+		}
+
+		pending = append(pending, notification)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "ClaimNotifications", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	return pending, nil
+}
+
+// RecordNotification closes a claimed notification with its outcome, or postpones it until sendAfter when set.
+func (ps *PostgresStore) RecordNotification(ctx context.Context, id int64, outcome string, sendAfter *time.Time) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	var err error
+	if sendAfter != nil {
+		_, err = ps.PostgresConnection.ExecEx(ctx, `UPDATE notifications SET sendAfter = $2 WHERE id = $1`, nil, id, sendAfter.UTC())
+	} else {
+		_, err = ps.PostgresConnection.ExecEx(ctx, `UPDATE notifications SET outcome = $2, sentAt = now() WHERE id = $1`, nil, id, outcome)
+	}
+
+	if err != nil {
+		slog.Error("Error recording notification", "notification", id, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+// PruneNotifications removes notifications sent before the cutoff, returning how many were removed.
+func (ps *PostgresStore) PruneNotifications(ctx context.Context, cutoff time.Time) (int64, error) {
+	slog.Info("Pruning notifications", "cutoff", cutoff)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM notifications WHERE sentAt < $1`, nil, cutoff.UTC())
+	if err != nil {
+		slog.Error("Error pruning notifications", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	slog.Info("Pruning notifications complete", "removed", tag.RowsAffected())
+	return tag.RowsAffected(), nil
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/chammond14/muzz/internal/events"
+	"github.com/jackc/pgx"
+)
+
+// OutboxStore describes the data access required to relay events written to the outbox
+type OutboxStore interface {
+	ClaimEvents(context.Context, int, time.Duration) ([]events.Event, error)
+	RecordEventAttempt(context.Context, int64, events.Attempt) error
+	PruneOutbox(context.Context, time.Time) (int64, error)
+}
+
+// insertEvent writes an event to the outbox as part of the transaction making the change it describes.
+func insertEvent(ctx context.Context, tx *pgx.Tx, eventType string, aggregateType string, aggregateId string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		slog.Error("Error encoding event", "type", eventType, "error", err)
+		return ErrDatabaseError
+	}
+
+	query := `INSERT INTO outbox (type, aggregateType, aggregateId, payload) VALUES ($1, $2, $3, $4::jsonb)`
+	if _, err := tx.ExecEx(ctx, query, nil, eventType, aggregateType, aggregateId, string(body)); err != nil {
+		slog.Error("Error writing event to outbox", "type", eventType, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+// ClaimEvents returns the oldest pending event of each aggregate, up to limit events, hiding them from other claims
+// until the lease ends. An event stays pending until RecordEventAttempt publishes it, so a relay which crashes part
+// way through has its events claimed again once the lease ends, and later events of the aggregate keep waiting.
+func (ps *PostgresStore) ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]events.Event, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE outbox SET nextAttemptAt = $2
+				WHERE id IN (
+					SELECT id FROM outbox o
+					WHERE publishedAt IS NULL AND nextAttemptAt <= now()
+					AND NOT EXISTS (
+						SELECT 1 FROM outbox earlier
+						WHERE earlier.publishedAt IS NULL
+						AND earlier.aggregateType = o.aggregateType
+						AND earlier.aggregateId = o.aggregateId
+						AND earlier.id < o.id
+					)
+					ORDER BY id
+					LIMIT $1
+					FOR UPDATE SKIP LOCKED
+				)
+				RETURNING id, type, aggregateType, aggregateId, payload::text, createdAt, attempts`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, limit, time.Now().UTC().Add(lease))
+	if err != nil {
+		slog.Error("Error claiming events", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	claimed := []events.Event{}
+	for rows.Next() {
+		event := events.Event{}
+		var payload string
+		err := rows.Scan(&event.Id, &event.Type, &event.AggregateType, &event.AggregateId, &payload, &event.CreatedAt, &event.Attempts)
+		if err != nil {
+			slog.Error("Error scanning rows", "method", "ClaimEvents", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		event.Payload = json.RawMessage(payload)
+		claimed = append(claimed, event)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "ClaimEvents", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	// RETURNING doesn't keep the order of the subquery
+	slices.SortFunc(claimed, func(a, b events.Event) int { return cmp.Compare(a.Id, b.Id) })
+	return claimed, nil
+}
+
+// RecordEventAttempt marks a claimed event published, or schedules another attempt when delivery failed.
+func (ps *PostgresStore) RecordEventAttempt(ctx context.Context, id int64, attempt events.Attempt) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	var err error
+	if attempt.Error == nil {
+		_, err = ps.PostgresConnection.ExecEx(ctx, `UPDATE outbox SET publishedAt = now() WHERE id = $1`, nil, id)
+	} else {
+		query := `UPDATE outbox SET attempts = attempts + 1, lastError = $2, nextAttemptAt = $3 WHERE id = $1`
+		_, err = ps.PostgresConnection.ExecEx(ctx, query, nil, id, *attempt.Error, attempt.NextAttemptAt.UTC())
+	}
+
+	if err != nil {
+		slog.Error("Error recording event delivery", "event", id, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+// PruneOutbox removes published events older than the cutoff, returning how many were removed.
+func (ps *PostgresStore) PruneOutbox(ctx context.Context, cutoff time.Time) (int64, error) {
+	slog.Info("Pruning outbox", "cutoff", cutoff)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM outbox WHERE publishedAt < $1`, nil, cutoff.UTC())
+	if err != nil {
+		slog.Error("Error pruning outbox", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	slog.Info("Pruning outbox complete", "removed", tag.RowsAffected())
+	return tag.RowsAffected(), nil
+}
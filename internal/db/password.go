@@ -0,0 +1,168 @@
+package db
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/chammond14/muzz/internal/mail"
+	"github.com/jackc/pgx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// unknownUserHash is compared against when no profile matches, so a login for an unknown email takes as long as one
+// with a wrong password
+var unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+
+// PasswordStore describes the data access required to reset forgotten passwords
+type PasswordStore interface {
+	RequestPasswordReset(context.Context, string, string, string, time.Duration) (*int32, error)
+	ResetPassword(context.Context, string, string) (int32, error)
+	PurgeExpiredPasswordResets(context.Context) (int64, error)
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		slog.Error("Error hashing password", "error", err)
+		return "", ErrDatabaseError
+	}
+
+	return string(hash), nil
+}
+
+// checkPassword reports whether the password matches the stored hash. Profiles created before passwords were hashed
+// store them in plaintext, which is reported as legacy so the caller can replace it with a hash.
+func checkPassword(stored string, password string) (matches bool, legacy bool) {
+	if strings.HasPrefix(stored, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil, false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1, true
+}
+
+// RequestPasswordReset stores a reset token for the profile with the email, replacing any earlier token, and emails
+// the reset link. Banned profiles and unknown emails are ignored without an error, so callers cannot tell whether an
+// account exists. The id of the profile is returned when a reset was requested.
+func (ps *PostgresStore) RequestPasswordReset(ctx context.Context, email string, tokenHash string, resetURL string, ttl time.Duration) (*int32, error) {
+	slog.Info("Requesting password reset")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var userId int32
+	var name, locale string
+	err = tx.QueryRowEx(ctx, `SELECT id, name, email, locale FROM profiles WHERE email = $1 AND bannedAt IS NULL`, nil, email).
+		Scan(&userId, &name, &email, &locale)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			slog.Info("Password reset requested for unknown email")
+			return nil, nil
+		}
+
+		slog.Error("Error finding profile for password reset", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	if _, err := tx.ExecEx(ctx, `DELETE FROM password_resets WHERE userId = $1`, nil, userId); err != nil {
+		slog.Error("Error replacing password reset", "user", userId, "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	query := `INSERT INTO password_resets (tokenHash, userId, expiresAt) VALUES ($1, $2, $3)`
+	if _, err := tx.ExecEx(ctx, query, nil, tokenHash, userId, time.Now().UTC().Add(ttl)); err != nil {
+		slog.Error("Error creating password reset", "user", userId, "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	data := map[string]any{"name": name, "resetUrl": resetURL, "expiresInMinutes": int(ttl.Minutes())}
+	if err := insertMail(ctx, tx, email, mail.TemplatePasswordReset, locale, data); err != nil {
+		return nil, err
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing password reset", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Requesting password reset complete", "user", userId)
+	return &userId, nil
+}
+
+// ResetPassword consumes an unexpired reset token, sets the profile's new password and ends its session,
+// returning the id of the profile.
+func (ps *PostgresStore) ResetPassword(ctx context.Context, tokenHash string, password string) (int32, error) {
+	slog.Info("Resetting password")
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var userId int32
+	query := `DELETE FROM password_resets WHERE tokenHash = $1 AND expiresAt > now() RETURNING userId`
+	if err := tx.QueryRowEx(ctx, query, nil, tokenHash).Scan(&userId); err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, ErrResetTokenInvalid
+		}
+
+		slog.Error("Error consuming password reset", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	if _, err := tx.ExecEx(ctx, `UPDATE profiles SET password = $2 WHERE id = $1`, nil, userId, hash); err != nil {
+		slog.Error("Error setting password", "user", userId, "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	if _, err := tx.ExecEx(ctx, `DELETE FROM sessions WHERE userId = $1`, nil, userId); err != nil {
+		slog.Error("Error ending sessions after password reset", "user", userId, "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing password reset", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	slog.Info("Resetting password complete", "user", userId)
+	return userId, nil
+}
+
+// PurgeExpiredPasswordResets removes reset tokens which can no longer be used, returning how many were removed.
+func (ps *PostgresStore) PurgeExpiredPasswordResets(ctx context.Context) (int64, error) {
+	slog.Info("Purging expired password resets")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM password_resets WHERE expiresAt <= now()`, nil)
+	if err != nil {
+		slog.Error("Error purging expired password resets", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	slog.Info("Purging expired password resets complete", "removed", tag.RowsAffected())
+	return tag.RowsAffected(), nil
+}
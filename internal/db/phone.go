@@ -0,0 +1,227 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/chammond14/muzz/internal/events"
+	"github.com/jackc/pgx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// maxPhoneCodeAttempts is how many codes can be tried against a phone code before it is discarded
+const maxPhoneCodeAttempts = 5
+
+// PhoneStore describes the data access required to sign up and log in with a phone number
+type PhoneStore interface {
+	CreatePhoneCode(context.Context, string, string, time.Duration) error
+	VerifyPhoneCode(context.Context, string, string) error
+	PhoneLogin(context.Context, string) (int32, bool, error)
+	CreatePhoneProfile(context.Context, string, time.Time, string, string, string, Location) (*Profile, error)
+	SetPhone(context.Context, int32, string) (*Profile, error)
+	PurgeExpiredPhoneCodes(context.Context) (int64, error)
+}
+
+// CreatePhoneCode stores the hash of a one time code sent to the phone, replacing any earlier code.
+func (ps *PostgresStore) CreatePhoneCode(ctx context.Context, phone string, code string, ttl time.Duration) error {
+	slog.Info("Creating phone code")
+
+	hash, err := hashPassword(code)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `INSERT INTO phone_codes (phone, codeHash, expiresAt) VALUES ($1, $2, $3)
+				ON CONFLICT (phone) DO UPDATE SET codeHash = $2, attempts = 0, expiresAt = $3, createdAt = now()`
+
+	if _, err := ps.PostgresConnection.ExecEx(ctx, query, nil, phone, hash, time.Now().UTC().Add(ttl)); err != nil {
+		slog.Error("Error creating phone code", "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+// VerifyPhoneCode checks a code against the unexpired code sent to the phone, consuming it on success. The code is
+// discarded after maxPhoneCodeAttempts incorrect codes.
+func (ps *PostgresStore) VerifyPhoneCode(ctx context.Context, phone string, code string) error {
+	slog.Info("Verifying phone code")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var codeHash string
+	query := `SELECT codeHash FROM phone_codes WHERE phone = $1 AND expiresAt > now() AND attempts < $2 FOR UPDATE`
+	if err := tx.QueryRowEx(ctx, query, nil, phone, maxPhoneCodeAttempts).Scan(&codeHash); err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrPhoneCodeInvalid
+		}
+
+		slog.Error("Error finding phone code", "error", err)
+		return ErrDatabaseError
+	}
+
+	query = `DELETE FROM phone_codes WHERE phone = $1`
+	matches := bcrypt.CompareHashAndPassword([]byte(codeHash), []byte(code)) == nil
+	if !matches {
+		// the failed attempt is committed so guesses are counted
+		query = `UPDATE phone_codes SET attempts = attempts + 1 WHERE phone = $1`
+	}
+
+	if _, err := tx.ExecEx(ctx, query, nil, phone); err != nil {
+		slog.Error("Error updating phone code", "error", err)
+		return ErrDatabaseError
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing phone code", "error", err)
+		return ErrDatabaseError
+	}
+
+	if !matches {
+		slog.Info("Incorrect phone code")
+		return ErrPhoneCodeInvalid
+	}
+
+	slog.Info("Verifying phone code complete")
+	return nil
+}
+
+// PhoneLogin finds the profile with the phone number, returning its id and whether it also needs a second factor.
+// The caller must have verified a code sent to the phone.
+func (ps *PostgresStore) PhoneLogin(ctx context.Context, phone string) (int32, bool, error) {
+	slog.Info("Logging in with phone")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	var userId int32
+	var twoFactor bool
+	query := `SELECT p.id, EXISTS (SELECT 1 FROM two_factor t WHERE t.userId = p.id AND t.confirmedAt IS NOT NULL)
+				FROM profiles p WHERE p.phone = $1`
+
+	if err := ps.PostgresConnection.QueryRowEx(ctx, query, nil, phone).Scan(&userId, &twoFactor); err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, false, ErrProfileNotFound
+		}
+
+		slog.Error("Error finding profile by phone", "error", err)
+		return 0, false, ErrDatabaseError
+	}
+
+	slog.Info("Logging in with phone complete", "user", userId)
+	return userId, twoFactor, nil
+}
+
+// CreatePhoneProfile creates a profile without an email for a verified phone number.
+func (ps *PostgresStore) CreatePhoneProfile(ctx context.Context, phone string, dateOfBirth time.Time, name string, gender string, password string, location Location) (*Profile, error) {
+	slog.Info("Creating profile for phone")
+
+	if !IsAdult(dateOfBirth, time.Now()) {
+		return nil, ErrUnderage
+	}
+
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	query := `INSERT INTO profiles (dateOfBirth, name, gender, phone, password, lat, long)
+				VALUES ($1, $2, $3, $4, $5, $6, $7)
+				ON CONFLICT (phone) WHERE phone IS NOT NULL DO NOTHING
+				RETURNING ` + profileColumns
+
+	row := tx.QueryRowEx(ctx, query, nil, dateOfBirth, name, gender, phone, hash, location.Lat, location.Long)
+	profile := &Profile{}
+	err = profile.scanRow(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrPhoneInUse
+		}
+
+		slog.Error("Error creating profile", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	err = insertEvent(ctx, tx, events.TypeProfileCreated, events.AggregateProfile, strconv.Itoa(int(profile.Id)), events.ProfileCreated{ProfileId: profile.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing profile", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Creating profile for phone complete", "user", profile.Id)
+	return profile, nil
+}
+
+// SetPhone sets the profile's phone number, which the caller must have verified. A number used by another profile
+// cannot be set.
+func (ps *PostgresStore) SetPhone(ctx context.Context, userId int32, phone string) (*Profile, error) {
+	slog.Info("Setting phone", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE profiles SET phone = $2 WHERE id = $1
+				AND NOT EXISTS (SELECT 1 FROM profiles WHERE phone = $2 AND id <> $1)
+				RETURNING ` + profileColumns
+
+	profile := &Profile{}
+	err := profile.scanRow(ps.PostgresConnection.QueryRowEx(ctx, query, nil, userId, phone))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrPhoneInUse
+		}
+
+		// two profiles setting the same number at once are caught by the unique index
+		slog.Error("Error setting phone", "user", userId, "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Setting phone complete", "user", userId)
+	return profile, nil
+}
+
+// PurgeExpiredPhoneCodes removes codes which can no longer be used, returning how many were removed.
+func (ps *PostgresStore) PurgeExpiredPhoneCodes(ctx context.Context) (int64, error) {
+	slog.Info("Purging expired phone codes")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM phone_codes WHERE expiresAt <= now() OR attempts >= $1`, nil, maxPhoneCodeAttempts)
+	if err != nil {
+		slog.Error("Error purging expired phone codes", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	slog.Info("Purging expired phone codes complete", "removed", tag.RowsAffected())
+	return tag.RowsAffected(), nil
+}
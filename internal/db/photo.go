@@ -0,0 +1,491 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+// Photo describes a profile photo as stored in the db. The image files themselves live in a blob store
+// beneath BlobPrefix, one per standard size.
+type Photo struct {
+	Id         int       `json:"id"`
+	UserId     int32     `json:"-"`
+	BlobPrefix string    `json:"-"`
+	Position   int       `json:"position"`
+	IsPrimary  bool      `json:"isPrimary"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+const photoColumns = `id, userId, blobPrefix, position, isPrimary, createdAt`
+
+func (p *Photo) scanRow(r rowScanner) error {
+	return r.Scan(
+		&p.Id,
+		&p.UserId,
+		&p.BlobPrefix,
+		&p.Position,
+		&p.IsPrimary,
+		&p.CreatedAt,
+	)
+}
+
+// PhotoStore describes the data access required to manage profile photos
+type PhotoStore interface {
+	AddPhoto(context.Context, NewPhoto, int) (*Photo, error)
+	GetPhotos(context.Context, int32) ([]*Photo, error)
+	DeletePhoto(context.Context, int32, int) (*Photo, error)
+	SetPrimaryPhoto(context.Context, int32, int) ([]*Photo, error)
+	ReorderPhotos(context.Context, int32, []int) ([]*Photo, error)
+	GetPhotoMatches(context.Context, string) ([]*PhotoMatch, error)
+	ResolvePhotoMatch(context.Context, int, int32, string) error
+	GetSimilarPhotos(context.Context, int, int) ([]*SimilarPhoto, error)
+}
+
+// NewPhoto describes a photo about to be added to a profile.
+type NewPhoto struct {
+	UserId     int32
+	BlobPrefix string
+	// Hash is the perceptual hash of the image, used to find the same image on other profiles.
+	Hash uint64
+	// MatchDistance is the largest Hamming distance at which another profile's photo is flagged as a match.
+	// It is capped at MaxPhashDistance.
+	MatchDistance int
+}
+
+// AddPhoto adds a photo after the user's existing photos, as long as they have fewer than maxPhotos.
+// A user's first photo becomes their primary photo. Photos on other profiles with a similar perceptual
+// hash are flagged in photo_matches for review.
+func (ps *PostgresStore) AddPhoto(ctx context.Context, newPhoto NewPhoto, maxPhotos int) (*Photo, error) {
+	userId := newPhoto.UserId
+	slog.Info("Adding photo", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	// lock the profile so concurrent uploads cannot exceed the limit
+	if _, err := tx.ExecEx(ctx, `SELECT id FROM profiles WHERE id = $1 FOR UPDATE`, nil, userId); err != nil {
+		slog.Error("Error locking profile", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	var count, lastPosition int
+	err = tx.QueryRowEx(ctx, `SELECT count(*), COALESCE(max(position), 0) FROM photos WHERE userId = $1`, nil, userId).Scan(&count, &lastPosition)
+	if err != nil {
+		slog.Error("Error counting photos", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	if count >= maxPhotos {
+		return nil, ErrPhotoLimitReached
+	}
+
+	query := `INSERT INTO photos (userId, blobPrefix, position, isPrimary, phash) VALUES ($1, $2, $3, $4, $5) RETURNING ` + photoColumns
+
+	hash := int64(newPhoto.Hash)
+	photo := &Photo{}
+	err = photo.scanRow(tx.QueryRowEx(ctx, query, nil, userId, newPhoto.BlobPrefix, lastPosition+1, count == 0, hash))
+	if err != nil {
+		slog.Error("Error adding photo", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	query = `INSERT INTO photo_matches (photoId, matchedPhotoId, distance)
+				SELECT $1, p.id, ` + hammingDistanceSQL("$3") + ` FROM photos p
+				WHERE p.userId <> $2
+				AND ` + phashBandsSQL("$3") + `
+				AND ` + hammingDistanceSQL("$3") + ` <= $4`
+
+	tag, err := tx.ExecEx(ctx, query, nil, photo.Id, userId, hash, min(newPhoto.MatchDistance, MaxPhashDistance))
+	if err != nil {
+		slog.Error("Error flagging similar photos", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	if tag.RowsAffected() > 0 {
+		slog.Info("Photo is similar to photos on other profiles", "photo", photo.Id, "matches", tag.RowsAffected())
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing photo", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Adding photo complete", "photo", photo.Id)
+	return photo, nil
+}
+
+// GetPhotos returns the user's photos in display order.
+func (ps *PostgresStore) GetPhotos(ctx context.Context, userId int32) ([]*Photo, error) {
+	slog.Info("Getting photos", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	photos, err := queryPhotos(ctx, ps.PostgresConnection.QueryEx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Getting photos complete", "len", len(photos))
+	return photos, nil
+}
+
+// DeletePhoto removes one of the user's photos and returns it, so its files can be removed.
+// If it was the primary photo, the next photo in order becomes primary.
+func (ps *PostgresStore) DeletePhoto(ctx context.Context, userId int32, photoId int) (*Photo, error) {
+	slog.Info("Deleting photo", "user", userId, "photo", photoId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	query := `DELETE FROM photos WHERE id = $1 AND userId = $2 RETURNING ` + photoColumns
+
+	photo := &Photo{}
+	err = photo.scanRow(tx.QueryRowEx(ctx, query, nil, photoId, userId))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrPhotoNotFound
+		}
+
+		slog.Error("Error deleting photo", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	if photo.IsPrimary {
+		query = `UPDATE photos SET isPrimary = true
+					WHERE id = (SELECT id FROM photos WHERE userId = $1 ORDER BY position LIMIT 1)`
+
+		if _, err := tx.ExecEx(ctx, query, nil, userId); err != nil {
+			slog.Error("Error promoting primary photo", "error", err)
+			return nil, ErrDatabaseError
+		}
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing photo deletion", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Deleting photo complete")
+	return photo, nil
+}
+
+// SetPrimaryPhoto makes one of the user's photos their primary photo and returns all of their photos.
+func (ps *PostgresStore) SetPrimaryPhoto(ctx context.Context, userId int32, photoId int) ([]*Photo, error) {
+	slog.Info("Setting primary photo", "user", userId, "photo", photoId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	// clear the old primary first, as only one photo per user may be primary at a time
+	if _, err := tx.ExecEx(ctx, `UPDATE photos SET isPrimary = false WHERE userId = $1 AND isPrimary AND id <> $2`, nil, userId, photoId); err != nil {
+		slog.Error("Error clearing primary photo", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	tag, err := tx.ExecEx(ctx, `UPDATE photos SET isPrimary = true WHERE userId = $1 AND id = $2`, nil, userId, photoId)
+	if err != nil {
+		slog.Error("Error setting primary photo", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	if tag.RowsAffected() == 0 {
+		return nil, ErrPhotoNotFound
+	}
+
+	photos, err := queryPhotos(ctx, tx.QueryEx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing primary photo", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Setting primary photo complete")
+	return photos, nil
+}
+
+// ReorderPhotos sets the display order of the user's photos. photoIds must contain each of their photos exactly once.
+func (ps *PostgresStore) ReorderPhotos(ctx context.Context, userId int32, photoIds []int) ([]*Photo, error) {
+	slog.Info("Reordering photos", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	photos, err := queryPhotos(ctx, tx.QueryEx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := make([]int, 0, len(photos))
+	for _, photo := range photos {
+		existing = append(existing, photo.Id)
+	}
+
+	requested := slices.Clone(photoIds)
+	slices.Sort(existing)
+	slices.Sort(requested)
+	if !slices.Equal(existing, requested) {
+		return nil, ErrPhotoOrderInvalid
+	}
+
+	for position, photoId := range photoIds {
+		if _, err := tx.ExecEx(ctx, `UPDATE photos SET position = $3 WHERE userId = $1 AND id = $2`, nil, userId, photoId, position+1); err != nil {
+			slog.Error("Error reordering photos", "error", err)
+			return nil, ErrDatabaseError
+		}
+	}
+
+	photos, err = queryPhotos(ctx, tx.QueryEx, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing photo order", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Reordering photos complete")
+	return photos, nil
+}
+
+type queryFunc func(context.Context, string, *pgx.QueryExOptions, ...interface{}) (*pgx.Rows, error)
+
+func queryPhotos(ctx context.Context, query queryFunc, userId int32) ([]*Photo, error) {
+	rows, err := query(ctx, `SELECT `+photoColumns+` FROM photos WHERE userId = $1 ORDER BY position`, nil, userId)
+	if err != nil {
+		slog.Error("Error retrieving photos", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	photos := []*Photo{}
+	for rows.Next() {
+		photo := &Photo{}
+		if err := photo.scanRow(rows); err != nil {
+			slog.Error("Error scanning rows", "method", "queryPhotos", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		photos = append(photos, photo)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "queryPhotos", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	return photos, nil
+}
+
+const (
+	PhotoMatchStatusOpen      = "open"
+	PhotoMatchStatusConfirmed = "confirmed"
+	PhotoMatchStatusDismissed = "dismissed"
+
+	// MaxPhashDistance is the furthest apart two perceptual hashes can be and still be found by phashBandsSQL.
+	MaxPhashDistance = 7
+)
+
+// PhotoMatch describes a pair of photos on different profiles with similar perceptual hashes.
+type PhotoMatch struct {
+	Id                 int       `json:"id"`
+	PhotoId            int       `json:"photoId"`
+	UserId             int32     `json:"userId"`
+	PhotoPrefix        string    `json:"-"`
+	MatchedPhotoId     int       `json:"matchedPhotoId"`
+	MatchedUserId      int32     `json:"matchedUserId"`
+	MatchedPhotoPrefix string    `json:"-"`
+	Distance           int       `json:"distance"`
+	Status             string    `json:"status"`
+	CreatedAt          time.Time `json:"createdAt"`
+}
+
+// SimilarPhoto describes a photo found to be similar to another.
+type SimilarPhoto struct {
+	PhotoId     int    `json:"photoId"`
+	UserId      int32  `json:"userId"`
+	PhotoPrefix string `json:"-"`
+	Distance    int    `json:"distance"`
+}
+
+// phashBandsSQL returns a condition matching photos which share at least one byte of their perceptual hash with
+// the hash parameter, at the same position. Any two hashes within MaxPhashDistance bits must share a byte, so this
+// lets the per byte indexes narrow the search before the exact distance is checked.
+func phashBandsSQL(hashParam string) string {
+	bands := make([]string, 0, 8)
+	for shift := 0; shift < 64; shift += 8 {
+		bands = append(bands, fmt.Sprintf("((p.phash >> %d) & 255) = ((%s::bigint >> %d) & 255)", shift, hashParam, shift))
+	}
+
+	return "(" + strings.Join(bands, " OR ") + ")"
+}
+
+func hammingDistanceSQL(hashParam string) string {
+	return "length(replace(((p.phash # " + hashParam + "::bigint)::bit(64))::text, '0', ''))"
+}
+
+// GetPhotoMatches returns flagged photo matches with the given status, oldest first. An empty status returns every match.
+func (ps *PostgresStore) GetPhotoMatches(ctx context.Context, status string) ([]*PhotoMatch, error) {
+	slog.Info("Getting photo matches", "status", status)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT m.id, m.photoId, p1.userId, p1.blobPrefix, m.matchedPhotoId, p2.userId, p2.blobPrefix,
+				m.distance, m.status, m.createdAt
+				FROM photo_matches m
+				JOIN photos p1 ON p1.id = m.photoId
+				JOIN photos p2 ON p2.id = m.matchedPhotoId
+				WHERE ($1 = '' OR m.status = $1)
+				ORDER BY m.createdAt`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, status)
+	if err != nil {
+		slog.Error("Error retrieving photo matches", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	matches := []*PhotoMatch{}
+	for rows.Next() {
+		match := &PhotoMatch{}
+		err := rows.Scan(&match.Id, &match.PhotoId, &match.UserId, &match.PhotoPrefix, &match.MatchedPhotoId,
+			&match.MatchedUserId, &match.MatchedPhotoPrefix, &match.Distance, &match.Status, &match.CreatedAt)
+		if err != nil {
+			slog.Error("Error scanning rows", "method", "GetPhotoMatches", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		matches = append(matches, match)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "GetPhotoMatches", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting photo matches complete", "len", len(matches))
+	return matches, nil
+}
+
+// ResolvePhotoMatch closes an open photo match as confirmed or dismissed.
+func (ps *PostgresStore) ResolvePhotoMatch(ctx context.Context, matchId int, moderatorId int32, status string) error {
+	slog.Info("Resolving photo match", "match", matchId, "status", status)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE photo_matches SET status = $3, resolvedBy = $2, resolvedAt = now() WHERE id = $1 AND status = 'open'`
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, query, nil, matchId, moderatorId, status)
+	if err != nil {
+		slog.Error("Error resolving photo match", "error", err)
+		return ErrDatabaseError
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrPhotoMatchNotFound
+	}
+
+	slog.Info("Resolving photo match complete")
+	return nil
+}
+
+// GetSimilarPhotos returns photos on other profiles within maxDistance of the photo's perceptual hash, closest first.
+func (ps *PostgresStore) GetSimilarPhotos(ctx context.Context, photoId int, maxDistance int) ([]*SimilarPhoto, error) {
+	slog.Info("Getting similar photos", "photo", photoId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	var userId int32
+	var hash *int64
+	err := ps.PostgresConnection.QueryRowEx(ctx, `SELECT userId, phash FROM photos WHERE id = $1`, nil, photoId).Scan(&userId, &hash)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrPhotoNotFound
+		}
+
+		slog.Error("Error getting photo hash", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	similar := []*SimilarPhoto{}
+	if hash == nil {
+		return similar, nil
+	}
+
+	query := `SELECT p.id, p.userId, p.blobPrefix, ` + hammingDistanceSQL("$3") + ` AS distance FROM photos p
+				WHERE p.userId <> $1
+				AND p.id <> $2
+				AND ` + phashBandsSQL("$3") + `
+				AND ` + hammingDistanceSQL("$3") + ` <= $4
+				ORDER BY distance, p.id`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, userId, photoId, *hash, min(maxDistance, MaxPhashDistance))
+	if err != nil {
+		slog.Error("Error retrieving similar photos", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		photo := &SimilarPhoto{}
+		if err := rows.Scan(&photo.PhotoId, &photo.UserId, &photo.PhotoPrefix, &photo.Distance); err != nil {
+			slog.Error("Error scanning rows", "method", "GetSimilarPhotos", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		similar = append(similar, photo)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "GetSimilarPhotos", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting similar photos complete", "len", len(similar))
+	return similar, nil
+}
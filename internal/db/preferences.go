@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/jackc/pgx"
+)
+
+// Preferences describes who a user wants to discover. Nil and empty fields mean the user has no preference.
+// A dealbreaker preference is always applied, even when a discover request asks for something wider.
+type Preferences struct {
+	MinAge              *int     `json:"minAge,omitempty"`
+	MaxAge              *int     `json:"maxAge,omitempty"`
+	Genders             []string `json:"genders,omitempty"`
+	MaxDistanceKm       *int     `json:"maxDistanceKm,omitempty"`
+	AgeDealbreaker      bool     `json:"ageDealbreaker"`
+	GenderDealbreaker   bool     `json:"genderDealbreaker"`
+	DistanceDealbreaker bool     `json:"distanceDealbreaker"`
+}
+
+const preferencesColumns = `minAge, maxAge, genders, maxDistanceKm, ageDealbreaker, genderDealbreaker, distanceDealbreaker`
+
+func (p *Preferences) scanRow(r rowScanner) error {
+	return r.Scan(
+		&p.MinAge,
+		&p.MaxAge,
+		&p.Genders,
+		&p.MaxDistanceKm,
+		&p.AgeDealbreaker,
+		&p.GenderDealbreaker,
+		&p.DistanceDealbreaker,
+	)
+}
+
+// PreferencesStore describes the data access required to persist discovery preferences
+type PreferencesStore interface {
+	GetPreferences(context.Context, int32) (*Preferences, error)
+	SetPreferences(context.Context, int32, Preferences) (*Preferences, error)
+}
+
+// GetPreferences returns the user's stored preferences, or empty preferences if none have been set.
+func (ps *PostgresStore) GetPreferences(ctx context.Context, userId int32) (*Preferences, error) {
+	slog.Info("Getting preferences", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT ` + preferencesColumns + ` FROM preferences WHERE userId = $1`
+
+	preferences := &Preferences{}
+	err := preferences.scanRow(ps.PostgresConnection.QueryRowEx(ctx, query, nil, userId))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return &Preferences{}, nil
+		}
+
+		slog.Error("Error getting preferences", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting preferences complete")
+	return preferences, nil
+}
+
+// SetPreferences replaces the user's stored preferences.
+func (ps *PostgresStore) SetPreferences(ctx context.Context, userId int32, preferences Preferences) (*Preferences, error) {
+	slog.Info("Setting preferences", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `INSERT INTO preferences (userId, ` + preferencesColumns + `)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+				ON CONFLICT (userId) DO UPDATE SET
+				minAge = $2, maxAge = $3, genders = $4, maxDistanceKm = $5,
+				ageDealbreaker = $6, genderDealbreaker = $7, distanceDealbreaker = $8, updatedAt = DEFAULT
+				RETURNING ` + preferencesColumns
+
+	row := ps.PostgresConnection.QueryRowEx(ctx, query, nil, userId,
+		preferences.MinAge, preferences.MaxAge, preferences.Genders, preferences.MaxDistanceKm,
+		preferences.AgeDealbreaker, preferences.GenderDealbreaker, preferences.DistanceDealbreaker)
+
+	stored := &Preferences{}
+	err := stored.scanRow(row)
+	if err != nil {
+		slog.Error("Error setting preferences", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Setting preferences complete")
+	return stored, nil
+}
@@ -4,53 +4,153 @@ import (
 	"context"
 	"log/slog"
 	"os"
+	"strconv"
 	"time"
 
+	"github.com/chammond14/muzz/internal/events"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx"
+	"golang.org/x/crypto/bcrypt"
 )
 
-// Checkout describes a checkout as stored in the db.
+const (
+	// VisibilityVisible profiles are shown in discover to everyone
+	VisibilityVisible = "visible"
+	// VisibilityPaused profiles are hidden from discover and cannot swipe
+	VisibilityPaused = "paused"
+	// VisibilityIncognito profiles are only shown in discover to profiles they have already liked
+	VisibilityIncognito = "incognito"
+)
+
+// Profile describes a profile as stored in the db. It contains the email and password and must never be
+// written to a response, use Public or Private to build the view for the caller.
 type Profile struct {
-	Id       int32
-	Age      int
-	Name     string
-	Gender   string
-	Email    string
-	Password string
-	Location Location
+	Id int32
+	// Age is derived from DateOfBirth when the profile is read
+	Age         int
+	DateOfBirth *time.Time
+	Name        string
+	Gender      string
+	Bio         string
+	Interests   []string
+	Visibility  string
+	Locale      string
+	// EmailVerified is set once the user has followed the link in their verification email
+	EmailVerified bool
+	// Email is empty for profiles which signed up with a phone number
+	Email string `json:"-"`
+	// Phone is in E.164 format, and only set once the user has entered a code sent to it
+	Phone    string   `json:"-"`
+	Password string   `json:"-"`
+	Location Location `json:"-"`
 }
 
 type Location struct {
-	Lat  float64
-	Long float64
+	Lat  float64 `json:"lat"`
+	Long float64 `json:"long"`
+}
+
+// PublicProfile describes a profile as seen by other users.
+type PublicProfile struct {
+	Id        int32          `json:"id"`
+	Age       int            `json:"age"`
+	Name      string         `json:"name"`
+	Gender    string         `json:"gender"`
+	Bio       string         `json:"bio"`
+	Interests []string       `json:"interests"`
+	Prompts   []PromptAnswer `json:"prompts"`
+	Verified  bool           `json:"verified"`
+}
+
+// PrivateProfile describes a profile as seen by its owner.
+type PrivateProfile struct {
+	PublicProfile
+	Email       string   `json:"email"`
+	Phone       string   `json:"phone,omitempty"`
+	DateOfBirth string   `json:"dateOfBirth,omitempty"`
+	Location    Location `json:"location"`
+	Visibility  string   `json:"visibility"`
+	Locale      string   `json:"locale"`
+}
+
+// ProfileUpdate describes changes to a profile. Nil fields are left unchanged.
+type ProfileUpdate struct {
+	Name *string
+	// DateOfBirth must be at least MinimumAge years ago
+	DateOfBirth *time.Time
+	Gender      *string
+	Bio         *string
+	Interests   []string
+	Visibility  *string
+	Locale      *string
+	Location    *Location
 }
 
-func (p *Profile) scanRow(r *pgx.Row) error {
+var profileColumns = `id, ` + ageSQL("dateOfBirth") + `, dateOfBirth, name, gender, bio, interests, visibility, locale, emailVerifiedAt IS NOT NULL,
+	COALESCE(email, ''), COALESCE(phone, ''), password, lat, long`
+
+func (p *Profile) scanRow(r rowScanner) error {
 	return r.Scan(
 		&p.Id,
 		&p.Age,
+		&p.DateOfBirth,
 		&p.Name,
 		&p.Gender,
+		&p.Bio,
+		&p.Interests,
+		&p.Visibility,
+		&p.Locale,
+		&p.EmailVerified,
 		&p.Email,
+		&p.Phone,
 		&p.Password,
 		&p.Location.Lat,
 		&p.Location.Long,
 	)
 }
 
+// Verified reports whether the profile has a verified email or phone number.
+func (p *Profile) Verified() bool {
+	return p.EmailVerified || p.Phone != ""
+}
+
+func (p *Profile) Public() *PublicProfile {
+	return &PublicProfile{
+		Id:        p.Id,
+		Age:       p.Age,
+		Name:      p.Name,
+		Gender:    p.Gender,
+		Bio:       p.Bio,
+		Interests: p.Interests,
+		Prompts:   []PromptAnswer{},
+		Verified:  p.Verified(),
+	}
+}
+
+func (p *Profile) Private() *PrivateProfile {
+	private := &PrivateProfile{
+		PublicProfile: *p.Public(),
+		Email:         p.Email,
+		Phone:         p.Phone,
+		Location:      p.Location,
+		Visibility:    p.Visibility,
+		Locale:        p.Locale,
+	}
+
+	if p.DateOfBirth != nil {
+		private.DateOfBirth = p.DateOfBirth.Format(DateOfBirthLayout)
+	}
+
+	return private
+}
+
 type Session struct {
 	Token     string
 	UserId    int32
 	Timestamp time.Time
-}
-
-func (s *Session) scanRow(r *pgx.Row) error {
-	return r.Scan(
-		&s.Token,
-		&s.UserId,
-		&s.Timestamp,
-	)
+	Role      string
+	// Verified is set when the profile has a verified email or phone number
+	Verified bool
 }
 
 type Match struct {
@@ -65,88 +165,302 @@ func (m *Match) scanRow(r *pgx.Row) error {
 
 // ProfileStore describes an interface which any data store must implement to achieve required functionality
 type ProfileStore interface {
-	CreateProfile(context.Context, int, string, string, string, string, Location) (*Profile, error)
+	CreateProfile(context.Context, time.Time, string, string, string, string, Location) (*Profile, error)
+	GetProfile(context.Context, int32) (*Profile, error)
+	GetPublicProfile(context.Context, int32, int32) (*PublicProfile, error)
+	UpdateProfile(context.Context, int32, ProfileUpdate) (*Profile, error)
 	GetDiscoverProfiles(context.Context, int32, DiscoverFilters) ([]*DiscoverProfile, error)
-	GetSession(context.Context, string) (int32, error)
-	Login(context.Context, string, string) (string, error)
+	GetSession(context.Context, string) (*Session, error)
+	CheckCredentials(context.Context, string, string) (int32, bool, error)
+	StartSession(context.Context, int32) (*Session, error)
 	Swipe(context.Context, int32, int32, bool) (bool, int, error)
+	PreferencesStore
+	PhotoStore
+	PromptStore
+	DeletionStore
+	ExportStore
+	JobStore
+	OutboxStore
+	WebhookStore
+	NotificationStore
+	MailStore
+	PasswordStore
+	VerificationStore
+	TwoFactorStore
+	IdentityStore
+	PhoneStore
+	RateLimitStore
+	HealthStore
+	ReportStore
+	AdminStore
 }
 
-func (ps *PostgresStore) CreateProfile(ctx context.Context, age int, name string, gender string, email string, password string, location Location) (*Profile, error) {
+// CreateProfile creates a profile with an email and password. Profiles younger than MinimumAge cannot be created.
+func (ps *PostgresStore) CreateProfile(ctx context.Context, dateOfBirth time.Time, name string, gender string, email string, password string, location Location) (*Profile, error) {
 	slog.Info("Creating profile")
 
+	if !IsAdult(dateOfBirth, time.Now()) {
+		return nil, ErrUnderage
+	}
+
 	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
 	defer cancel()
 
-	query := `INSERT INTO profiles (age, name, gender, email, password, lat, long) 
+	hash, err := hashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	query := `INSERT INTO profiles (dateOfBirth, name, gender, email, password, lat, long) 
 				VALUES ($1, $2, $3, $4, $5, $6, $7)
-				RETURNING id, age, name, gender, email, password, lat, long`
+				RETURNING ` + profileColumns
 
-	row := ps.PostgresConnection.QueryRowEx(ctx, query, nil, age, name, gender, email, password, location.Lat, location.Long)
+	row := tx.QueryRowEx(ctx, query, nil, dateOfBirth, name, gender, email, hash, location.Lat, location.Long)
 	profile := &Profile{}
-	err := profile.scanRow(row)
+	err = profile.scanRow(row)
 	if err != nil {
 		slog.Error("Error creating profile", "error", err)
 		return nil, ErrDatabaseError
 	}
 
+	err = insertEvent(ctx, tx, events.TypeProfileCreated, events.AggregateProfile, strconv.Itoa(int(profile.Id)), events.ProfileCreated{ProfileId: profile.Id})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing profile", "error", err)
+		return nil, ErrDatabaseError
+	}
+
 	slog.Info("Creating profile complete")
 	return profile, nil
 }
 
-func (ps *PostgresStore) Login(ctx context.Context, email string, password string) (string, error) {
-	slog.Info("Logging in")
+func (ps *PostgresStore) GetProfile(ctx context.Context, id int32) (*Profile, error) {
+	slog.Info("Getting profile", "user", id)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT ` + profileColumns + ` FROM profiles WHERE id = $1`
+
+	profile := &Profile{}
+	err := profile.scanRow(ps.PostgresConnection.QueryRowEx(ctx, query, nil, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrProfileNotFound
+		}
+
+		slog.Error("Error getting profile", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting profile complete")
+	return profile, nil
+}
+
+// GetPublicProfile returns the public view of a profile to the viewer. Banned, suspended and deleted profiles are not
+// found, and neither are profiles discover would hide from the viewer: paused profiles, and incognito profiles which
+// have not liked or matched with them. Viewers can always see their own profile.
+func (ps *PostgresStore) GetPublicProfile(ctx context.Context, viewerId int32, id int32) (*PublicProfile, error) {
+	slog.Info("Getting public profile", "viewer", viewerId, "user", id)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT ` + profileColumns + ` FROM profiles p
+				WHERE p.id = $1
+				AND p.bannedAt IS NULL
+				AND p.deletionScheduledFor IS NULL
+				AND (p.suspendedUntil IS NULL OR p.suspendedUntil <= now())
+				AND (p.id = $2 OR p.visibility = 'visible' OR (p.visibility = 'incognito' AND (
+					EXISTS (SELECT 1 FROM profiles viewer WHERE viewer.id = $2 AND p.id = ANY (viewer.swipedYesBy))
+					OR EXISTS (SELECT 1 FROM matches m WHERE (m.user1Id = $1 AND m.user2Id = $2) OR (m.user1Id = $2 AND m.user2Id = $1))
+				)))`
+
+	profile := &Profile{}
+	err := profile.scanRow(ps.PostgresConnection.QueryRowEx(ctx, query, nil, id, viewerId))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrProfileNotFound
+		}
+
+		slog.Error("Error getting public profile", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	public := profile.Public()
+	public.Prompts, err = ps.GetPromptAnswers(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Getting public profile complete")
+	return public, nil
+}
+
+func (ps *PostgresStore) UpdateProfile(ctx context.Context, id int32, update ProfileUpdate) (*Profile, error) {
+	slog.Info("Updating profile", "user", id)
+
+	if update.DateOfBirth != nil && !IsAdult(*update.DateOfBirth, time.Now()) {
+		return nil, ErrUnderage
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	var lat, long *float64
+	if update.Location != nil {
+		lat, long = &update.Location.Lat, &update.Location.Long
+	}
+
+	query := `UPDATE profiles SET
+				name = COALESCE($2, name),
+				gender = COALESCE($3, gender),
+				bio = COALESCE($4, bio),
+				lat = COALESCE($5, lat),
+				long = COALESCE($6, long),
+				interests = COALESCE($7, interests),
+				visibility = COALESCE($8, visibility),
+				locale = COALESCE($9, locale),
+				dateOfBirth = COALESCE($10, dateOfBirth)
+				WHERE id = $1
+				RETURNING ` + profileColumns
+
+	profile := &Profile{}
+	err := profile.scanRow(ps.PostgresConnection.QueryRowEx(ctx, query, nil, id, update.Name, update.Gender, update.Bio, lat, long, update.Interests, update.Visibility, update.Locale, update.DateOfBirth))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrProfileNotFound
+		}
+
+		slog.Error("Error updating profile", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Updating profile complete")
+	return profile, nil
+}
+
+// CheckCredentials checks the email and password, returning the id of the profile and whether it also needs a second
+// factor before a session can be started. A password stored in plaintext is replaced with its hash once it has been checked.
+func (ps *PostgresStore) CheckCredentials(ctx context.Context, email string, password string) (int32, bool, error) {
+	slog.Info("Checking credentials")
 
 	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
 	defer cancel()
 
-	query := `SELECT id, age, name, gender, email, password, lat, long FROM profiles WHERE email = $1 AND password = $2`
-	row := ps.PostgresConnection.QueryRowEx(ctx, query, nil, email, password)
+	query := `SELECT ` + profileColumns + ` FROM profiles WHERE email = $1`
+	row := ps.PostgresConnection.QueryRowEx(ctx, query, nil, email)
 
 	profile := &Profile{}
 	err := profile.scanRow(row)
 	if err != nil {
 		slog.Error("Error logging in", "error", err)
 		if err == pgx.ErrNoRows {
-			return "", ErrLoginFailed
+			bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+			return 0, false, ErrLoginFailed
 		}
 
-		return "", ErrDatabaseError
+		return 0, false, ErrDatabaseError
+	}
+
+	matches, legacy := checkPassword(profile.Password, password)
+	if !matches {
+		slog.Info("Incorrect password", "user", profile.Id)
+		return 0, false, ErrLoginFailed
+	}
+
+	if legacy {
+		hash, err := hashPassword(password)
+		if err != nil {
+			return 0, false, err
+		}
+
+		if _, err := ps.PostgresConnection.ExecEx(ctx, `UPDATE profiles SET password = $2 WHERE id = $1`, nil, profile.Id, hash); err != nil {
+			slog.Error("Error upgrading password hash", "user", profile.Id, "error", err)
+			return 0, false, ErrDatabaseError
+		}
+	}
+
+	var twoFactor bool
+	query = `SELECT EXISTS (SELECT 1 FROM two_factor WHERE userId = $1 AND confirmedAt IS NOT NULL)`
+	if err := ps.PostgresConnection.QueryRowEx(ctx, query, nil, profile.Id).Scan(&twoFactor); err != nil {
+		slog.Error("Error checking two factor authentication", "user", profile.Id, "error", err)
+		return 0, false, ErrDatabaseError
+	}
+
+	return profile.Id, twoFactor, nil
+}
+
+// StartSession starts a new session for a profile whose credentials have been checked, replacing any existing one.
+// Starting a session cancels a scheduled deletion.
+func (ps *PostgresStore) StartSession(ctx context.Context, userId int32) (*Session, error) {
+	slog.Info("Starting session", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	if _, err := ps.PostgresConnection.ExecEx(ctx, `UPDATE profiles SET deletionScheduledFor = NULL WHERE id = $1 AND deletionScheduledFor IS NOT NULL`, nil, userId); err != nil {
+		slog.Error("Error cancelling deletion", "error", err)
+		return nil, ErrDatabaseError
 	}
 
 	sessionToken := uuid.New().String()
 
-	query = `INSERT INTO sessions (token, userId) VALUES ($1, $2)
+	query := `INSERT INTO sessions (token, userId) VALUES ($1, $2)
 	ON CONFLICT (userId) DO UPDATE SET token = $1, expiresAt = DEFAULT`
 
-	_, err = ps.PostgresConnection.ExecEx(ctx, query, nil, sessionToken, profile.Id)
+	_, err := ps.PostgresConnection.ExecEx(ctx, query, nil, sessionToken, userId)
 	if err != nil {
 		slog.Error("Error creating session", "error", err)
-		return "", ErrDatabaseError
+		return nil, ErrDatabaseError
 	}
 
-	return sessionToken, nil
+	return &Session{Token: sessionToken, UserId: userId}, nil
 }
 
-func (ps *PostgresStore) GetSession(ctx context.Context, token string) (int32, error) {
+func (ps *PostgresStore) GetSession(ctx context.Context, token string) (*Session, error) {
 	slog.Info("Getting session")
 
 	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
 	defer cancel()
 
-	query := `SELECT token, userId, expiresAt FROM sessions WHERE token = $1 AND expiresAt > now()`
+	query := `SELECT s.token, s.userId, s.expiresAt, p.role, (p.emailVerifiedAt IS NOT NULL OR p.phone IS NOT NULL), p.bannedAt IS NOT NULL, COALESCE(p.suspendedUntil > now(), false)
+				FROM sessions s JOIN profiles p ON p.id = s.userId
+				WHERE s.token = $1 AND s.expiresAt > now()`
 
 	row := ps.PostgresConnection.QueryRowEx(ctx, query, nil, token)
 	session := &Session{}
-	err := session.scanRow(row)
+	var banned, suspended bool
+	err := row.Scan(&session.Token, &session.UserId, &session.Timestamp, &session.Role, &session.Verified, &banned, &suspended)
 	slog.Info("Time", "stamp", session.Timestamp)
 	if err != nil {
 		slog.Error("Error finding session", "error", err)
-		return 0, ErrNoValidSession
+		return nil, ErrNoValidSession
+	}
+
+	if banned {
+		slog.Info("Session belongs to banned profile", "user", session.UserId)
+		return nil, ErrAccountBanned
+	}
+
+	if suspended {
+		slog.Info("Session belongs to suspended profile", "user", session.UserId)
+		return nil, ErrAccountSuspended
 	}
 
 	slog.Info("Getting session complete")
-	return session.UserId, nil
+	return session, nil
 }
 
 func getTimeoutDuration() time.Duration {
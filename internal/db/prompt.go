@@ -0,0 +1,131 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Prompt is a question from the fixed catalogue users can answer on their profile.
+type Prompt struct {
+	Id       string `json:"id"`
+	Question string `json:"question"`
+}
+
+// Prompts is the catalogue of prompts. Ids are stored against answers, so must never be changed or reused.
+var Prompts = []Prompt{
+	{Id: "perfect-weekend", Question: "My perfect weekend"},
+	{Id: "green-flags", Question: "Green flags I look for"},
+	{Id: "two-truths-one-lie", Question: "Two truths and a lie"},
+	{Id: "unpopular-opinion", Question: "My most unpopular opinion"},
+	{Id: "never-shut-up-about", Question: "I won't shut up about"},
+	{Id: "simple-pleasures", Question: "My simple pleasures"},
+	{Id: "goal-this-year", Question: "This year, I really want to"},
+	{Id: "dating-me-is-like", Question: "Dating me is like"},
+}
+
+// FindPrompt returns the prompt in the catalogue with the given id.
+func FindPrompt(id string) (Prompt, bool) {
+	for _, prompt := range Prompts {
+		if prompt.Id == id {
+			return prompt, true
+		}
+	}
+
+	return Prompt{}, false
+}
+
+// PromptAnswer describes a user's answer to a prompt.
+type PromptAnswer struct {
+	PromptId string `json:"prompt"`
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// PromptStore describes the data access required to keep users' prompt answers
+type PromptStore interface {
+	GetPromptAnswers(context.Context, int32) ([]PromptAnswer, error)
+	SetPromptAnswers(context.Context, int32, []PromptAnswer) ([]PromptAnswer, error)
+}
+
+// withQuestions fills in the question for each answer from the catalogue.
+func withQuestions(answers []PromptAnswer) []PromptAnswer {
+	for i := range answers {
+		prompt, _ := FindPrompt(answers[i].PromptId)
+		answers[i].Question = prompt.Question
+	}
+
+	return answers
+}
+
+// GetPromptAnswers returns the user's prompt answers in display order.
+func (ps *PostgresStore) GetPromptAnswers(ctx context.Context, userId int32) ([]PromptAnswer, error) {
+	slog.Info("Getting prompt answers", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT promptId, answer FROM profile_prompts WHERE userId = $1 ORDER BY position`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, userId)
+	if err != nil {
+		slog.Error("Error retrieving prompt answers", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	answers := []PromptAnswer{}
+	for rows.Next() {
+		answer := PromptAnswer{}
+		if err := rows.Scan(&answer.PromptId, &answer.Answer); err != nil {
+			slog.Error("Error scanning rows", "method", "GetPromptAnswers", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		answers = append(answers, answer)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "GetPromptAnswers", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting prompt answers complete", "len", len(answers))
+	return withQuestions(answers), nil
+}
+
+// SetPromptAnswers replaces all of the user's prompt answers, keeping the order given.
+func (ps *PostgresStore) SetPromptAnswers(ctx context.Context, userId int32, answers []PromptAnswer) ([]PromptAnswer, error) {
+	slog.Info("Setting prompt answers", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	if _, err := tx.ExecEx(ctx, `DELETE FROM profile_prompts WHERE userId = $1`, nil, userId); err != nil {
+		slog.Error("Error clearing prompt answers", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	query := `INSERT INTO profile_prompts (userId, promptId, answer, position) VALUES ($1, $2, $3, $4)`
+	for position, answer := range answers {
+		if _, err := tx.ExecEx(ctx, query, nil, userId, answer.PromptId, answer.Answer, position+1); err != nil {
+			slog.Error("Error saving prompt answer", "error", err)
+			return nil, ErrDatabaseError
+		}
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing prompt answers", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Setting prompt answers complete")
+	return withQuestions(answers), nil
+}
@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// RateLimitStore describes the data access required to rate limit requests across every replica
+type RateLimitStore interface {
+	ConsumeRateLimit(context.Context, string, int, time.Duration) (bool, time.Duration, error)
+	PruneRateLimits(context.Context) (int64, error)
+}
+
+// ConsumeRateLimit counts a request against the key's fixed window, starting a new window once the last has ended.
+// It reports whether the request is within the limit, and if not, how long until the window ends.
+func (ps *PostgresStore) ConsumeRateLimit(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `INSERT INTO rate_limit_buckets (key, count, windowStart, windowEnd)
+				VALUES ($1, 1, now(), now() + $2 * interval '1 second')
+				ON CONFLICT (key) DO UPDATE SET
+					count = CASE WHEN rate_limit_buckets.windowEnd <= now() THEN 1 ELSE rate_limit_buckets.count + 1 END,
+					windowStart = CASE WHEN rate_limit_buckets.windowEnd <= now() THEN now() ELSE rate_limit_buckets.windowStart END,
+					windowEnd = CASE WHEN rate_limit_buckets.windowEnd <= now() THEN EXCLUDED.windowEnd ELSE rate_limit_buckets.windowEnd END
+				RETURNING count, EXTRACT(EPOCH FROM windowEnd - now())::float8`
+
+	var count int
+	var remaining float64
+	if err := ps.PostgresConnection.QueryRowEx(ctx, query, nil, key, window.Seconds()).Scan(&count, &remaining); err != nil {
+		slog.Error("Error consuming rate limit", "key", key, "error", err)
+		return false, 0, ErrDatabaseError
+	}
+
+	if count > limit {
+		slog.Info("Rate limit exceeded", "key", key, "count", count, "limit", limit)
+		return false, time.Duration(remaining * float64(time.Second)), nil
+	}
+
+	return true, 0, nil
+}
+
+// PruneRateLimits removes buckets whose window has ended, returning how many were removed.
+func (ps *PostgresStore) PruneRateLimits(ctx context.Context) (int64, error) {
+	slog.Info("Pruning rate limits")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM rate_limit_buckets WHERE windowEnd <= now()`, nil)
+	if err != nil {
+		slog.Error("Error pruning rate limits", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	slog.Info("Pruning rate limits complete", "removed", tag.RowsAffected())
+	return tag.RowsAffected(), nil
+}
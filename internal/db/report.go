@@ -0,0 +1,275 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/jackc/pgx"
+)
+
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusActioned  = "actioned"
+	ReportStatusDismissed = "dismissed"
+
+	ReportActionWarn    = "warn"
+	ReportActionSuspend = "suspend"
+	ReportActionBan     = "ban"
+)
+
+// Report describes a report raised against a profile, as stored in the db.
+type Report struct {
+	Id             int        `json:"id"`
+	ReporterId     *int32     `json:"reporterId,omitempty"`
+	ReportedId     int32      `json:"reportedId"`
+	Reason         string     `json:"reason"`
+	Details        string     `json:"details"`
+	Status         string     `json:"status"`
+	ClaimedBy      *int32     `json:"claimedBy,omitempty"`
+	ClaimedAt      *time.Time `json:"claimedAt,omitempty"`
+	Action         *string    `json:"action,omitempty"`
+	SuspendedUntil *time.Time `json:"suspendedUntil,omitempty"`
+	ResolvedBy     *int32     `json:"resolvedBy,omitempty"`
+	ResolvedAt     *time.Time `json:"resolvedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+// Resolution describes the outcome a moderator has chosen for a report.
+// Action and SuspendFor are only used when Status is ReportStatusActioned.
+type Resolution struct {
+	Status     string
+	Action     string
+	SuspendFor time.Duration
+}
+
+const reportColumns = `id, reporterId, reportedId, reason, details, status, claimedBy, claimedAt,
+	action, suspendedUntil, resolvedBy, resolvedAt, createdAt`
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (rp *Report) scanRow(r rowScanner) error {
+	return r.Scan(
+		&rp.Id,
+		&rp.ReporterId,
+		&rp.ReportedId,
+		&rp.Reason,
+		&rp.Details,
+		&rp.Status,
+		&rp.ClaimedBy,
+		&rp.ClaimedAt,
+		&rp.Action,
+		&rp.SuspendedUntil,
+		&rp.ResolvedBy,
+		&rp.ResolvedAt,
+		&rp.CreatedAt,
+	)
+}
+
+// ReportStore describes the data access required to report profiles and work the moderation queue
+type ReportStore interface {
+	CreateReport(context.Context, int32, int32, string, string) (*Report, error)
+	GetReports(context.Context, string) ([]*Report, error)
+	ClaimReport(context.Context, int, int32) (*Report, error)
+	ResolveReport(context.Context, int, int32, Resolution) (*Report, error)
+}
+
+func (ps *PostgresStore) CreateReport(ctx context.Context, reporterId int32, reportedId int32, reason string, details string) (*Report, error) {
+	slog.Info("Creating report", "reporter", reporterId, "reported", reportedId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	if reporterId == reportedId {
+		return nil, ErrReportInvalid
+	}
+
+	query := `INSERT INTO reports (reporterId, reportedId, reason, details)
+				SELECT $1, id, $3, $4 FROM profiles WHERE id = $2
+				RETURNING ` + reportColumns
+
+	row := ps.PostgresConnection.QueryRowEx(ctx, query, nil, reporterId, reportedId, reason, details)
+	report := &Report{}
+	err := report.scanRow(row)
+	if err != nil {
+		slog.Error("Error creating report", "error", err)
+		if err == pgx.ErrNoRows {
+			return nil, ErrReportInvalid
+		}
+
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Creating report complete", "report", report.Id)
+	return report, nil
+}
+
+// GetReports returns the reports with the given status, oldest first. An empty status returns every report.
+func (ps *PostgresStore) GetReports(ctx context.Context, status string) ([]*Report, error) {
+	slog.Info("Getting reports", "status", status)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `SELECT ` + reportColumns + ` FROM reports
+				WHERE ($1 = '' OR status = $1)
+				ORDER BY createdAt`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, status)
+	if err != nil {
+		slog.Error("Error retrieving reports", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	reports := []*Report{}
+	for rows.Next() {
+		report := &Report{}
+		if err := report.scanRow(rows); err != nil {
+			slog.Error("Error scanning rows", "method", "GetReports", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		reports = append(reports, report)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "GetReports", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting reports complete", "len", len(reports))
+	return reports, nil
+}
+
+// ClaimReport assigns an open report to a moderator. Claiming a report already claimed by the same moderator is allowed.
+func (ps *PostgresStore) ClaimReport(ctx context.Context, reportId int, moderatorId int32) (*Report, error) {
+	slog.Info("Claiming report", "report", reportId, "moderator", moderatorId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE reports SET claimedBy = $2, claimedAt = now()
+				WHERE id = $1 AND status = 'open' AND (claimedBy IS NULL OR claimedBy = $2)
+				RETURNING ` + reportColumns
+
+	row := ps.PostgresConnection.QueryRowEx(ctx, query, nil, reportId, moderatorId)
+	report := &Report{}
+	err := report.scanRow(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ps.reportMissingOrUnclaimable(ctx, reportId)
+		}
+
+		slog.Error("Error claiming report", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Claiming report complete", "report", reportId)
+	return report, nil
+}
+
+// ResolveReport closes a report claimed by the moderator and applies any action to the reported profile.
+// Suspending or banning a profile also ends its session.
+func (ps *PostgresStore) ResolveReport(ctx context.Context, reportId int, moderatorId int32, resolution Resolution) (*Report, error) {
+	slog.Info("Resolving report", "report", reportId, "moderator", moderatorId, "status", resolution.Status, "action", resolution.Action)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var action *string
+	var suspendedUntil *time.Time
+	if resolution.Status == ReportStatusActioned {
+		action = &resolution.Action
+		if resolution.Action == ReportActionSuspend {
+			until := time.Now().UTC().Add(resolution.SuspendFor)
+			suspendedUntil = &until
+		}
+	}
+
+	query := `UPDATE reports SET status = $3, action = $4, suspendedUntil = $5, resolvedBy = $2, resolvedAt = now()
+				WHERE id = $1 AND status = 'open' AND claimedBy = $2
+				RETURNING ` + reportColumns
+
+	row := tx.QueryRowEx(ctx, query, nil, reportId, moderatorId, resolution.Status, action, suspendedUntil)
+	report := &Report{}
+	err = report.scanRow(row)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ps.reportMissingOrUnclaimable(ctx, reportId)
+		}
+
+		slog.Error("Error resolving report", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	if action != nil {
+		if err := applyModerationAction(ctx, tx, report.ReportedId, *action, suspendedUntil); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing report resolution", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Resolving report complete", "report", reportId)
+	return report, nil
+}
+
+func applyModerationAction(ctx context.Context, tx *pgx.Tx, userId int32, action string, suspendedUntil *time.Time) error {
+	var query string
+	args := []interface{}{userId}
+
+	switch action {
+	case ReportActionWarn:
+		query = `UPDATE profiles SET warnings = warnings + 1 WHERE id = $1`
+	case ReportActionSuspend:
+		query = `UPDATE profiles SET suspendedUntil = GREATEST(suspendedUntil, $2) WHERE id = $1`
+		args = append(args, *suspendedUntil)
+	case ReportActionBan:
+		query = `UPDATE profiles SET bannedAt = COALESCE(bannedAt, now()) WHERE id = $1`
+	}
+
+	if _, err := tx.ExecEx(ctx, query, nil, args...); err != nil {
+		slog.Error("Error applying moderation action", "user", userId, "action", action, "error", err)
+		return ErrDatabaseError
+	}
+
+	if action == ReportActionWarn {
+		return nil
+	}
+
+	if _, err := tx.ExecEx(ctx, `DELETE FROM sessions WHERE userId = $1`, nil, userId); err != nil {
+		slog.Error("Error ending session for moderated profile", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+func (ps *PostgresStore) reportMissingOrUnclaimable(ctx context.Context, reportId int) error {
+	var exists bool
+	err := ps.PostgresConnection.QueryRowEx(ctx, `SELECT EXISTS (SELECT 1 FROM reports WHERE id = $1)`, nil, reportId).Scan(&exists)
+	if err != nil {
+		slog.Error("Error checking report", "report", reportId, "error", err)
+		return ErrDatabaseError
+	}
+
+	if !exists {
+		return ErrReportNotFound
+	}
+
+	return ErrReportNotClaimable
+}
@@ -33,6 +33,338 @@ const schema = `
 		user1Id INTEGER NOT NULL REFERENCES profiles (id),
 		user2Id INTEGER NOT NULL REFERENCES profiles (id),
 		matchedAt timestamp not null default current_timestamp
+	);
+
+	ALTER TABLE profiles ADD COLUMN IF NOT EXISTS warnings INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE profiles ADD COLUMN IF NOT EXISTS suspendedUntil timestamp;
+	ALTER TABLE profiles ADD COLUMN IF NOT EXISTS bannedAt timestamp;
+
+	CREATE TABLE IF NOT EXISTS reports (
+		id INTEGER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+		reporterId INTEGER NOT NULL REFERENCES profiles (id),
+		reportedId INTEGER NOT NULL REFERENCES profiles (id),
+		reason TEXT NOT NULL,
+		details TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'open',
+		claimedBy INTEGER REFERENCES profiles (id),
+		claimedAt timestamp,
+		action TEXT,
+		suspendedUntil timestamp,
+		resolvedBy INTEGER REFERENCES profiles (id),
+		resolvedAt timestamp,
+		createdAt timestamp not null default current_timestamp
+	);
+
+	CREATE INDEX IF NOT EXISTS reports_status_idx ON reports (status, createdAt);
+
+	ALTER TABLE profiles ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
+	ALTER TABLE profiles ADD COLUMN IF NOT EXISTS bio TEXT NOT NULL DEFAULT '';
+
+	CREATE TABLE IF NOT EXISTS audit_log (
+		id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+		actorId INTEGER,
+		targetId INTEGER,
+		action TEXT NOT NULL,
+		details jsonb NOT NULL DEFAULT '{}',
+		createdAt timestamp not null default current_timestamp
+	);
+
+	ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+	ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS userAgent TEXT NOT NULL DEFAULT '';
+	CREATE INDEX IF NOT EXISTS audit_log_createdAt_idx ON audit_log (createdAt);
+	CREATE INDEX IF NOT EXISTS audit_log_actorId_idx ON audit_log (actorId);
+	CREATE INDEX IF NOT EXISTS audit_log_targetId_idx ON audit_log (targetId);
+
+	-- the audit log is append only, entries may only be removed by the retention policy. The only exception is
+	-- erasing an account, which scrubs the deleted user's personal data from their entries.
+	CREATE OR REPLACE FUNCTION audit_log_prevent_update() RETURNS trigger AS $$
+	BEGIN
+		IF current_setting('muzz.erasing_profile', true) = 'on' THEN
+			RETURN NEW;
+		END IF;
+		RAISE EXCEPTION 'audit_log is append only';
+	END;
+	$$ LANGUAGE plpgsql;
+
+	DROP TRIGGER IF EXISTS audit_log_append_only ON audit_log;
+	CREATE TRIGGER audit_log_append_only BEFORE UPDATE ON audit_log
+		FOR EACH ROW EXECUTE PROCEDURE audit_log_prevent_update();
+
+	CREATE TABLE IF NOT EXISTS preferences (
+		userId INTEGER NOT NULL PRIMARY KEY REFERENCES profiles (id),
+		minAge INTEGER,
+		maxAge INTEGER,
+		genders TEXT[],
+		maxDistanceKm INTEGER,
+		ageDealbreaker BOOLEAN NOT NULL DEFAULT false,
+		genderDealbreaker BOOLEAN NOT NULL DEFAULT false,
+		distanceDealbreaker BOOLEAN NOT NULL DEFAULT false,
+		updatedAt timestamp not null default current_timestamp
+	);
+
+	CREATE TABLE IF NOT EXISTS photos (
+		id INTEGER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+		userId INTEGER NOT NULL REFERENCES profiles (id),
+		blobPrefix TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		isPrimary BOOLEAN NOT NULL DEFAULT false,
+		createdAt timestamp not null default current_timestamp
+	);
+
+	CREATE INDEX IF NOT EXISTS photos_userId_idx ON photos (userId, position);
+	CREATE UNIQUE INDEX IF NOT EXISTS photos_primary_idx ON photos (userId) WHERE isPrimary;
+
+	-- each byte of the perceptual hash is indexed so that similar hashes can be found, see phashBandsSQL
+	ALTER TABLE photos ADD COLUMN IF NOT EXISTS phash BIGINT;
+	CREATE INDEX IF NOT EXISTS photos_phash_band0_idx ON photos (((phash >> 0) & 255));
+	CREATE INDEX IF NOT EXISTS photos_phash_band1_idx ON photos (((phash >> 8) & 255));
+	CREATE INDEX IF NOT EXISTS photos_phash_band2_idx ON photos (((phash >> 16) & 255));
+	CREATE INDEX IF NOT EXISTS photos_phash_band3_idx ON photos (((phash >> 24) & 255));
+	CREATE INDEX IF NOT EXISTS photos_phash_band4_idx ON photos (((phash >> 32) & 255));
+	CREATE INDEX IF NOT EXISTS photos_phash_band5_idx ON photos (((phash >> 40) & 255));
+	CREATE INDEX IF NOT EXISTS photos_phash_band6_idx ON photos (((phash >> 48) & 255));
+	CREATE INDEX IF NOT EXISTS photos_phash_band7_idx ON photos (((phash >> 56) & 255));
+
+	CREATE TABLE IF NOT EXISTS photo_matches (
+		id INTEGER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+		photoId INTEGER NOT NULL REFERENCES photos (id) ON DELETE CASCADE,
+		matchedPhotoId INTEGER NOT NULL REFERENCES photos (id) ON DELETE CASCADE,
+		distance INTEGER NOT NULL,
+		status TEXT NOT NULL DEFAULT 'open',
+		resolvedBy INTEGER REFERENCES profiles (id),
+		resolvedAt timestamp,
+		createdAt timestamp not null default current_timestamp
+	);
+
+	CREATE INDEX IF NOT EXISTS photo_matches_status_idx ON photo_matches (status, createdAt);
+
+	ALTER TABLE profiles ADD COLUMN IF NOT EXISTS interests TEXT[] NOT NULL DEFAULT '{}';
+	CREATE INDEX IF NOT EXISTS profiles_interests_idx ON profiles USING GIN (interests);
+
+	CREATE TABLE IF NOT EXISTS profile_prompts (
+		userId INTEGER NOT NULL REFERENCES profiles (id),
+		promptId TEXT NOT NULL,
+		answer TEXT NOT NULL,
+		position INTEGER NOT NULL,
+		PRIMARY KEY (userId, promptId)
+	);
+
+	ALTER TABLE profiles ADD COLUMN IF NOT EXISTS visibility TEXT NOT NULL DEFAULT 'visible';
+
+	ALTER TABLE profiles ADD COLUMN IF NOT EXISTS deletionScheduledFor timestamp;
+	CREATE INDEX IF NOT EXISTS profiles_deletionScheduledFor_idx ON profiles (deletionScheduledFor) WHERE deletionScheduledFor IS NOT NULL;
+
+	CREATE TABLE IF NOT EXISTS data_exports (
+		id TEXT NOT NULL PRIMARY KEY,
+		userId INTEGER NOT NULL REFERENCES profiles (id),
+		status TEXT NOT NULL DEFAULT 'pending',
+		blobKey TEXT,
+		createdAt timestamp not null default current_timestamp,
+		completedAt timestamp
+	);
+
+	CREATE INDEX IF NOT EXISTS data_exports_userId_idx ON data_exports (userId);
+	-- when the export was last started, so exports whose build was cut off can be picked up again
+	ALTER TABLE data_exports ADD COLUMN IF NOT EXISTS attemptedAt timestamp NOT NULL DEFAULT current_timestamp;
+
+	CREATE TABLE IF NOT EXISTS jobs (
+		name TEXT NOT NULL PRIMARY KEY,
+		nextRunAt timestamp NOT NULL,
+		lastRunAt timestamp,
+		lastStatus TEXT,
+		lastError TEXT,
+		durationMs BIGINT,
+		runCount BIGINT NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS sessions_expiresAt_idx ON sessions (expiresAt);
+
+	CREATE TABLE IF NOT EXISTS outbox (
+		id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+		type TEXT NOT NULL,
+		aggregateType TEXT NOT NULL,
+		aggregateId TEXT NOT NULL,
+		payload jsonb NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		lastError TEXT,
+		nextAttemptAt timestamp not null default current_timestamp,
+		publishedAt timestamp,
+		createdAt timestamp not null default current_timestamp
+	);
+
+	CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (aggregateType, aggregateId, id) WHERE publishedAt IS NULL;
+	CREATE INDEX IF NOT EXISTS outbox_publishedAt_idx ON outbox (publishedAt);
+
+	CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+		id INTEGER GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+		url TEXT NOT NULL,
+		eventTypes TEXT[] NOT NULL DEFAULT '{}',
+		secret TEXT NOT NULL,
+		createdBy INTEGER REFERENCES profiles (id),
+		createdAt timestamp not null default current_timestamp
+	);
+
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+		subscriptionId INTEGER NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+		eventId BIGINT NOT NULL,
+		eventType TEXT NOT NULL,
+		body jsonb NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		lastStatusCode INTEGER,
+		lastError TEXT,
+		nextAttemptAt timestamp not null default current_timestamp,
+		deliveredAt timestamp,
+		createdAt timestamp not null default current_timestamp,
+		UNIQUE (subscriptionId, eventId)
+	);
+
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_pending_idx ON webhook_deliveries (nextAttemptAt) WHERE status = 'pending';
+
+	CREATE TABLE IF NOT EXISTS devices (
+		token TEXT PRIMARY KEY,
+		userId INTEGER NOT NULL REFERENCES profiles (id),
+		platform TEXT NOT NULL,
+		createdAt timestamp not null default current_timestamp
+	);
+
+	CREATE INDEX IF NOT EXISTS devices_userId_idx ON devices (userId);
+
+	CREATE TABLE IF NOT EXISTS notification_preferences (
+		userId INTEGER PRIMARY KEY REFERENCES profiles (id),
+		matches BOOLEAN NOT NULL DEFAULT true,
+		likes BOOLEAN NOT NULL DEFAULT true,
+		quietStart INTEGER,
+		quietEnd INTEGER,
+		timezone TEXT NOT NULL DEFAULT 'UTC',
+		updatedAt timestamp not null default current_timestamp
+	);
+
+	CREATE TABLE IF NOT EXISTS notifications (
+		id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+		userId INTEGER NOT NULL REFERENCES profiles (id),
+		kind TEXT NOT NULL,
+		sourceIds INTEGER[] NOT NULL DEFAULT '{}',
+		sendAfter timestamp not null default current_timestamp,
+		outcome TEXT,
+		sentAt timestamp,
+		createdAt timestamp not null default current_timestamp
+	);
+
+	CREATE INDEX IF NOT EXISTS notifications_pending_idx ON notifications (sendAfter) WHERE sentAt IS NULL;
+	CREATE UNIQUE INDEX IF NOT EXISTS notifications_likes_digest_idx ON notifications (userId) WHERE kind = 'likes' AND sentAt IS NULL;
+
+	ALTER TABLE profiles ADD COLUMN IF NOT EXISTS locale TEXT NOT NULL DEFAULT 'en';
+
+	CREATE TABLE IF NOT EXISTS mail_outbox (
+		id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+		recipient TEXT NOT NULL,
+		template TEXT NOT NULL,
+		locale TEXT NOT NULL,
+		data jsonb NOT NULL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		attempts INTEGER NOT NULL DEFAULT 0,
+		lastError TEXT,
+		nextAttemptAt timestamp not null default current_timestamp,
+		sentAt timestamp,
+		createdAt timestamp not null default current_timestamp
+	);
+
+	CREATE INDEX IF NOT EXISTS mail_outbox_pending_idx ON mail_outbox (nextAttemptAt) WHERE status = 'pending';
+
+	CREATE TABLE IF NOT EXISTS password_resets (
+		tokenHash TEXT PRIMARY KEY,
+		userId INTEGER NOT NULL REFERENCES profiles (id),
+		expiresAt timestamp not null,
+		createdAt timestamp not null default current_timestamp
+	);
+
+	CREATE INDEX IF NOT EXISTS password_resets_userId_idx ON password_resets (userId);
+
+	-- profiles which existed before email verification are treated as verified, so the column is only filled in
+	-- when it is first added and new profiles start unverified
+	ALTER TABLE profiles ADD COLUMN IF NOT EXISTS emailVerifiedAt timestamp DEFAULT current_timestamp;
+	ALTER TABLE profiles ALTER COLUMN emailVerifiedAt DROP DEFAULT;
+
+	CREATE TABLE IF NOT EXISTS two_factor (
+		userId INTEGER PRIMARY KEY REFERENCES profiles (id),
+		secret TEXT NOT NULL,
+		-- the last time step a code was accepted for, so each code can only be used once
+		lastUsedStep BIGINT NOT NULL DEFAULT 0,
+		confirmedAt timestamp,
+		createdAt timestamp not null default current_timestamp
+	);
+
+	CREATE TABLE IF NOT EXISTS recovery_codes (
+		userId INTEGER NOT NULL REFERENCES profiles (id),
+		codeHash TEXT NOT NULL,
+		usedAt timestamp,
+		PRIMARY KEY (userId, codeHash)
+	);
+
+	CREATE TABLE IF NOT EXISTS login_challenges (
+		tokenHash TEXT PRIMARY KEY,
+		userId INTEGER NOT NULL REFERENCES profiles (id),
+		attempts INTEGER NOT NULL DEFAULT 0,
+		expiresAt timestamp not null,
+		createdAt timestamp not null default current_timestamp
+	);
+
+	CREATE INDEX IF NOT EXISTS login_challenges_userId_idx ON login_challenges (userId);
+
+	CREATE TABLE IF NOT EXISTS federated_identities (
+		provider TEXT NOT NULL,
+		subject TEXT NOT NULL,
+		userId INTEGER NOT NULL REFERENCES profiles (id),
+		email TEXT NOT NULL DEFAULT '',
+		createdAt timestamp not null default current_timestamp,
+		PRIMARY KEY (provider, subject),
+		UNIQUE (userId, provider)
+	);
+
+	CREATE TABLE IF NOT EXISTS oauth_states (
+		stateHash TEXT PRIMARY KEY,
+		provider TEXT NOT NULL,
+		nonce TEXT NOT NULL,
+		verifier TEXT NOT NULL,
+		userId INTEGER REFERENCES profiles (id),
+		expiresAt timestamp not null,
+		createdAt timestamp not null default current_timestamp
+	);
+
+	-- profiles can sign up with a phone number instead of an email
+	ALTER TABLE profiles ALTER COLUMN email DROP NOT NULL;
+	ALTER TABLE profiles ADD COLUMN IF NOT EXISTS phone TEXT;
+	CREATE UNIQUE INDEX IF NOT EXISTS profiles_phone_idx ON profiles (phone) WHERE phone IS NOT NULL;
+
+	CREATE TABLE IF NOT EXISTS phone_codes (
+		phone TEXT PRIMARY KEY,
+		codeHash TEXT NOT NULL,
+		attempts INTEGER NOT NULL DEFAULT 0,
+		expiresAt timestamp not null,
+		createdAt timestamp not null default current_timestamp
+	);
+
+	CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+		key TEXT PRIMARY KEY,
+		count INTEGER NOT NULL,
+		windowStart timestamp not null,
+		windowEnd timestamp not null
+	);
+
+	CREATE INDEX IF NOT EXISTS rate_limit_buckets_windowEnd_idx ON rate_limit_buckets (windowEnd);
+
+	-- age is derived from the date of birth. The age column is only kept for the 0001_date_of_birth migration.
+	ALTER TABLE profiles ADD COLUMN IF NOT EXISTS dateOfBirth date;
+	ALTER TABLE profiles ALTER COLUMN age DROP NOT NULL;
+
+	-- reports raised by the service itself, rather than a user, have no reporter
+	ALTER TABLE reports ALTER COLUMN reporterId DROP NOT NULL;
+
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		name TEXT PRIMARY KEY,
+		appliedAt timestamp not null default current_timestamp
 	);`
 
 // applySchema applies the schema to a connection and seeds the database
@@ -48,6 +380,10 @@ func applySchema(postgresConnection *pgx.ConnPool) error {
 		return err
 	}
 
+	if err := applyMigrations(tx); err != nil {
+		return err
+	}
+
 	isLocal := os.Getenv("isLocal") == "true"
 	if isLocal {
 		seedDatabase(tx)
@@ -63,4 +399,5 @@ func seedDatabase(tx *pgx.Tx) {
 	tx.Exec(seed2)
 	tx.Exec(seed3)
 	tx.Exec(seed4)
+	tx.Exec(seedAdmin)
 }
@@ -2,10 +2,22 @@ package db
 
 import (
 	"context"
+	"log/slog"
+	"net"
+	"time"
 
 	"github.com/jackc/pgx"
 )
 
+// dialTimeout bounds connecting to Postgres, so a health check against an unreachable server fails instead of hanging
+const dialTimeout = 5 * time.Second
+
+// HealthStore describes the data access required to report whether the database is ready to serve requests
+type HealthStore interface {
+	TestConnection(context.Context) error
+	PendingMigrations(context.Context) ([]string, error)
+}
+
 // Store provides access to the database.
 type PostgresStore struct {
 	PostgresConnection *pgx.ConnPool
@@ -19,17 +31,30 @@ func NewPostgresStore(connStr string) (*PostgresStore, error) {
 		return nil, err
 	}
 
-	applySchema(conn)
+	// the schema is only applied here, so a service started without it could never become ready. Failing instead
+	// stops it from starting at all.
+	if err := applySchema(conn); err != nil {
+		slog.Error("Error applying schema", "error", err)
+		conn.Close()
+		return nil, err
+	}
 
 	store.PostgresConnection = conn
 	return store, nil
 }
 
+// Close closes every connection in the pool, waiting for connections in use to be released.
+func (s *PostgresStore) Close() {
+	s.PostgresConnection.Close()
+}
+
 // TestConnection tests that the Store can properly connect to the Postgres Server.
 func (s *PostgresStore) TestConnection(ctx context.Context) error {
-	// _, err := s.PostgresConnection.ExecEx(ctx, "SELECT 1", nil)
-	// return err
-	return nil
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	_, err := s.PostgresConnection.ExecEx(ctx, "SELECT 1", nil)
+	return err
 }
 
 func setupConnectionPool(connStr string) (*pgx.ConnPool, error) {
@@ -39,9 +64,15 @@ func setupConnectionPool(connStr string) (*pgx.ConnPool, error) {
 		return nil, err
 	}
 
-	conn, _ := pgx.NewConnPool(pgx.ConnPoolConfig{
+	config.Dial = (&net.Dialer{Timeout: dialTimeout, KeepAlive: 5 * time.Minute}).Dial
+
+	conn, err := pgx.NewConnPool(pgx.ConnPoolConfig{
 		ConnConfig: config,
 	})
 
+	if err != nil {
+		return nil, err
+	}
+
 	return conn, nil
 }
@@ -5,6 +5,7 @@ import (
 	"log/slog"
 	"slices"
 
+	"github.com/chammond14/muzz/internal/events"
 	"github.com/jackc/pgx"
 )
 
@@ -12,6 +13,9 @@ type SwipeDetails struct {
 	Id          int32
 	SwipedOn    []int32
 	SwipedYesBy []int32
+	Visibility  string
+	// Hidden is set when the profile is banned, suspended or deleted, so it is never shown in discover
+	Hidden bool
 }
 
 func scanSwipeRows(r *pgx.Rows) (SwipeDetails, error) {
@@ -20,6 +24,8 @@ func scanSwipeRows(r *pgx.Rows) (SwipeDetails, error) {
 		&profile.Id,
 		&profile.SwipedOn,
 		&profile.SwipedYesBy,
+		&profile.Visibility,
+		&profile.Hidden,
 	)
 
 	return *profile, err
@@ -39,6 +45,15 @@ func (ps *PostgresStore) Swipe(ctx context.Context, userId int32, swipedUserId i
 	swiperProfile := profiles[slices.IndexFunc(profiles, func(p *SwipeDetails) bool { return p.Id == userId })]
 	swipedProfile := profiles[slices.IndexFunc(profiles, func(p *SwipeDetails) bool { return p.Id == swipedUserId })]
 
+	if swiperProfile.Visibility == VisibilityPaused {
+		return false, 0, ErrProfilePaused
+	}
+
+	// a profile discover wouldn't show is not found, as it is for GET /profiles/{id}
+	if !canSwipeOn(swiperProfile, swipedProfile) {
+		return false, 0, ErrProfileNotFound
+	}
+
 	swiperProfile.SwipedOn = append(swiperProfile.SwipedOn, swipedUserId)
 
 	isMatch := liked && slices.Contains(swiperProfile.SwipedYesBy, swipedUserId)
@@ -74,7 +89,16 @@ func (ps *PostgresStore) Swipe(ctx context.Context, userId int32, swipedUserId i
 			return false, 0, ErrDatabaseError
 		}
 
-		tx.CommitEx(ctx)
+		matchCreated := events.MatchCreated{MatchId: match.Id, User1Id: userId, User2Id: swipedUserId}
+		err = insertEvent(ctx, tx, events.TypeMatchCreated, events.AggregatePair, events.PairId(userId, swipedUserId), matchCreated)
+		if err != nil {
+			return false, 0, err
+		}
+
+		if err := tx.CommitEx(ctx); err != nil {
+			slog.Error("Error committing match", "error", err)
+			return false, 0, ErrDatabaseError
+		}
 		slog.Info("Swiping profile complete")
 		return true, match.Id, nil
 	} else if liked {
@@ -90,7 +114,16 @@ func (ps *PostgresStore) Swipe(ctx context.Context, userId int32, swipedUserId i
 		tx.ExecEx(ctx, updateSwipedQuery, nil, swipedProfile.SwipedYesBy, swipedUserId)
 		slog.Info("Updating swiped profile complete")
 
-		tx.CommitEx(ctx)
+		swipeLiked := events.SwipeLiked{SwiperId: userId, SwipedId: swipedUserId}
+		err = insertEvent(ctx, tx, events.TypeSwipeLiked, events.AggregatePair, events.PairId(userId, swipedUserId), swipeLiked)
+		if err != nil {
+			return false, 0, err
+		}
+
+		if err := tx.CommitEx(ctx); err != nil {
+			slog.Error("Error committing like", "error", err)
+			return false, 0, ErrDatabaseError
+		}
 		slog.Info("Swiping profile complete")
 		return false, 0, nil
 	}
@@ -101,10 +134,28 @@ func (ps *PostgresStore) Swipe(ctx context.Context, userId int32, swipedUserId i
 	return false, 0, nil
 }
 
+// canSwipeOn reports whether the swiped profile could have been shown to the swiper in discover.
+func canSwipeOn(swiper *SwipeDetails, swiped *SwipeDetails) bool {
+	if swiped.Hidden {
+		return false
+	}
+
+	switch swiped.Visibility {
+	case VisibilityPaused:
+		return false
+	case VisibilityIncognito:
+		return slices.Contains(swiper.SwipedYesBy, swiped.Id)
+	default:
+		return true
+	}
+}
+
 func (ps *PostgresStore) getSwipeProfiles(ctx context.Context, userId1 int32, userId2 int32) ([]*SwipeDetails, error) {
 	slog.Info("Getting profiles for swipe", "swiper", userId1, "swiped user", userId2)
 
-	usersQuery := `SELECT id, swipedOn, swipedYesBy FROM profiles WHERE id in ($1, $2)`
+	usersQuery := `SELECT id, swipedOn, swipedYesBy, visibility,
+					bannedAt IS NOT NULL OR deletionScheduledFor IS NOT NULL OR COALESCE(suspendedUntil > now(), false)
+					FROM profiles WHERE id in ($1, $2)`
 	rows, err := ps.PostgresConnection.QueryEx(ctx, usersQuery, nil, userId1, userId2)
 	if err != nil {
 		slog.Error("Error retrieving swiped profiles", "error", err)
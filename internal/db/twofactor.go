@@ -0,0 +1,316 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/chammond14/muzz/internal/totp"
+	"github.com/jackc/pgx"
+)
+
+// maxChallengeAttempts is how many codes can be tried against a login challenge before it is discarded
+const maxChallengeAttempts = 5
+
+// TwoFactorStore describes the data access required for TOTP two factor authentication
+type TwoFactorStore interface {
+	SetupTwoFactor(context.Context, int32, string) error
+	ConfirmTwoFactor(context.Context, int32, string, []string) error
+	DisableTwoFactor(context.Context, int32, string) error
+	CreateLoginChallenge(context.Context, int32, string, time.Duration) error
+	LoginChallengeUser(context.Context, string) (int32, error)
+	CompleteLoginChallenge(context.Context, string, string, string) (int32, error)
+	PurgeExpiredLoginChallenges(context.Context) (int64, error)
+}
+
+// SetupTwoFactor stores a new secret for the profile, replacing any secret which has not been confirmed yet.
+// Two factor authentication is not used for logging in until the secret is confirmed with ConfirmTwoFactor.
+func (ps *PostgresStore) SetupTwoFactor(ctx context.Context, userId int32, secret string) error {
+	slog.Info("Setting up two factor authentication", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `INSERT INTO two_factor (userId, secret) VALUES ($1, $2)
+				ON CONFLICT (userId) DO UPDATE SET secret = $2, lastUsedStep = 0, createdAt = now()
+				WHERE two_factor.confirmedAt IS NULL`
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, query, nil, userId, secret)
+	if err != nil {
+		slog.Error("Error setting up two factor authentication", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	slog.Info("Setting up two factor authentication complete", "user", userId)
+	return nil
+}
+
+// ConfirmTwoFactor enables two factor authentication once the profile proves it can generate codes for the secret,
+// storing the hashes of its recovery codes. Any previous recovery codes are replaced.
+func (ps *PostgresStore) ConfirmTwoFactor(ctx context.Context, userId int32, code string, recoveryCodeHashes []string) error {
+	slog.Info("Confirming two factor authentication", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var confirmed bool
+	err = tx.QueryRowEx(ctx, `SELECT confirmedAt IS NOT NULL FROM two_factor WHERE userId = $1`, nil, userId).Scan(&confirmed)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrTwoFactorNotSetUp
+		}
+
+		slog.Error("Error finding two factor authentication", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	if confirmed {
+		return ErrTwoFactorEnabled
+	}
+
+	if err := checkTOTP(ctx, tx, userId, code); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecEx(ctx, `UPDATE two_factor SET confirmedAt = now() WHERE userId = $1`, nil, userId); err != nil {
+		slog.Error("Error confirming two factor authentication", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	if _, err := tx.ExecEx(ctx, `DELETE FROM recovery_codes WHERE userId = $1`, nil, userId); err != nil {
+		slog.Error("Error replacing recovery codes", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	query := `INSERT INTO recovery_codes (userId, codeHash) SELECT $1, unnest($2::text[])`
+	if _, err := tx.ExecEx(ctx, query, nil, userId, recoveryCodeHashes); err != nil {
+		slog.Error("Error storing recovery codes", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing two factor authentication", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	slog.Info("Confirming two factor authentication complete", "user", userId)
+	return nil
+}
+
+// DisableTwoFactor turns two factor authentication off with a current code, removing the secret and recovery codes.
+func (ps *PostgresStore) DisableTwoFactor(ctx context.Context, userId int32, code string) error {
+	slog.Info("Disabling two factor authentication", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var confirmed bool
+	err = tx.QueryRowEx(ctx, `SELECT confirmedAt IS NOT NULL FROM two_factor WHERE userId = $1`, nil, userId).Scan(&confirmed)
+	if err != nil && err != pgx.ErrNoRows {
+		slog.Error("Error finding two factor authentication", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	if !confirmed {
+		return ErrTwoFactorNotSetUp
+	}
+
+	if err := checkTOTP(ctx, tx, userId, code); err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM recovery_codes WHERE userId = $1`,
+		`DELETE FROM login_challenges WHERE userId = $1`,
+		`DELETE FROM two_factor WHERE userId = $1`,
+	} {
+		if _, err := tx.ExecEx(ctx, query, nil, userId); err != nil {
+			slog.Error("Error disabling two factor authentication", "user", userId, "error", err)
+			return ErrDatabaseError
+		}
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing two factor authentication", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	slog.Info("Disabling two factor authentication complete", "user", userId)
+	return nil
+}
+
+// CreateLoginChallenge stores a challenge for a profile which has passed the first factor, to be completed with a code.
+func (ps *PostgresStore) CreateLoginChallenge(ctx context.Context, userId int32, tokenHash string, ttl time.Duration) error {
+	slog.Info("Creating login challenge", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `INSERT INTO login_challenges (tokenHash, userId, expiresAt) VALUES ($1, $2, $3)`
+	if _, err := ps.PostgresConnection.ExecEx(ctx, query, nil, tokenHash, userId, time.Now().UTC().Add(ttl)); err != nil {
+		slog.Error("Error creating login challenge", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
+
+// LoginChallengeUser returns the user an unexpired login challenge with attempts left belongs to.
+func (ps *PostgresStore) LoginChallengeUser(ctx context.Context, tokenHash string) (int32, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	var userId int32
+	query := `SELECT userId FROM login_challenges WHERE tokenHash = $1 AND expiresAt > now() AND attempts < $2`
+	if err := ps.PostgresConnection.QueryRowEx(ctx, query, nil, tokenHash, maxChallengeAttempts).Scan(&userId); err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, ErrLoginChallengeInvalid
+		}
+
+		slog.Error("Error finding login challenge", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	return userId, nil
+}
+
+// CompleteLoginChallenge checks a TOTP code, or the hash of an unused recovery code when recoveryCodeHash is set,
+// against an unexpired challenge and returns the id of the profile. The challenge is consumed on success, and
+// discarded after maxChallengeAttempts incorrect codes.
+func (ps *PostgresStore) CompleteLoginChallenge(ctx context.Context, tokenHash string, code string, recoveryCodeHash string) (int32, error) {
+	slog.Info("Completing login challenge")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var userId int32
+	query := `SELECT userId FROM login_challenges WHERE tokenHash = $1 AND expiresAt > now() AND attempts < $2 FOR UPDATE`
+	if err := tx.QueryRowEx(ctx, query, nil, tokenHash, maxChallengeAttempts).Scan(&userId); err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, ErrLoginChallengeInvalid
+		}
+
+		slog.Error("Error finding login challenge", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	if recoveryCodeHash != "" {
+		query = `UPDATE recovery_codes SET usedAt = now() WHERE userId = $1 AND codeHash = $2 AND usedAt IS NULL`
+		tag, err := tx.ExecEx(ctx, query, nil, userId, recoveryCodeHash)
+		if err != nil {
+			slog.Error("Error using recovery code", "user", userId, "error", err)
+			return 0, ErrDatabaseError
+		}
+
+		if tag.RowsAffected() == 0 {
+			err = ErrTwoFactorCodeInvalid
+		}
+	} else {
+		err = checkTOTP(ctx, tx, userId, code)
+	}
+
+	if err == ErrTwoFactorCodeInvalid {
+		// the failed attempt is committed so guesses are counted
+		if _, err := tx.ExecEx(ctx, `UPDATE login_challenges SET attempts = attempts + 1 WHERE tokenHash = $1`, nil, tokenHash); err != nil {
+			slog.Error("Error counting login challenge attempt", "user", userId, "error", err)
+			return 0, ErrDatabaseError
+		}
+
+		if err := tx.CommitEx(ctx); err != nil {
+			slog.Error("Error committing login challenge attempt", "user", userId, "error", err)
+			return 0, ErrDatabaseError
+		}
+
+		return userId, ErrTwoFactorCodeInvalid
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := tx.ExecEx(ctx, `DELETE FROM login_challenges WHERE tokenHash = $1`, nil, tokenHash); err != nil {
+		slog.Error("Error consuming login challenge", "user", userId, "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing login challenge", "user", userId, "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	slog.Info("Completing login challenge complete", "user", userId)
+	return userId, nil
+}
+
+// PurgeExpiredLoginChallenges removes login challenges which can no longer be completed, returning how many were removed.
+func (ps *PostgresStore) PurgeExpiredLoginChallenges(ctx context.Context) (int64, error) {
+	slog.Info("Purging expired login challenges")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM login_challenges WHERE expiresAt <= now() OR attempts >= $1`, nil, maxChallengeAttempts)
+	if err != nil {
+		slog.Error("Error purging expired login challenges", "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	slog.Info("Purging expired login challenges complete", "removed", tag.RowsAffected())
+	return tag.RowsAffected(), nil
+}
+
+// checkTOTP checks a code against the profile's secret, recording the step it matched so it cannot be used again.
+func checkTOTP(ctx context.Context, tx *pgx.Tx, userId int32, code string) error {
+	var secret string
+	var lastUsedStep int64
+	query := `SELECT secret, lastUsedStep FROM two_factor WHERE userId = $1 FOR UPDATE`
+	if err := tx.QueryRowEx(ctx, query, nil, userId).Scan(&secret, &lastUsedStep); err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrTwoFactorNotSetUp
+		}
+
+		slog.Error("Error finding two factor secret", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok || step <= lastUsedStep {
+		slog.Info("Incorrect two factor code", "user", userId)
+		return ErrTwoFactorCodeInvalid
+	}
+
+	if _, err := tx.ExecEx(ctx, `UPDATE two_factor SET lastUsedStep = $2 WHERE userId = $1`, nil, userId, step); err != nil {
+		slog.Error("Error recording two factor code", "user", userId, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
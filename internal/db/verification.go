@@ -0,0 +1,83 @@
+package db
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/chammond14/muzz/internal/mail"
+	"github.com/jackc/pgx"
+)
+
+// VerificationStore describes the data access required to verify email addresses
+type VerificationStore interface {
+	SendEmailVerification(context.Context, int32, string) error
+	VerifyEmail(context.Context, int32, string) error
+}
+
+// SendEmailVerification emails the profile a link to verify its address.
+func (ps *PostgresStore) SendEmailVerification(ctx context.Context, userId int32, verifyURL string) error {
+	slog.Info("Sending email verification", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tx, err := ps.PostgresConnection.BeginEx(ctx, nil)
+	if err != nil {
+		slog.Error("Error beginning transaction", "error", err)
+		return ErrDatabaseError
+	}
+
+	defer tx.RollbackEx(ctx)
+
+	var name, email, locale string
+	var verified bool
+	err = tx.QueryRowEx(ctx, `SELECT name, COALESCE(email, ''), locale, emailVerifiedAt IS NOT NULL FROM profiles WHERE id = $1`, nil, userId).
+		Scan(&name, &email, &locale, &verified)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return ErrProfileNotFound
+		}
+
+		slog.Error("Error loading profile for email verification", "error", err)
+		return ErrDatabaseError
+	}
+
+	if verified {
+		return ErrEmailAlreadyVerified
+	}
+
+	if err := insertMail(ctx, tx, email, mail.TemplateVerifyEmail, locale, map[string]string{"name": name, "verifyUrl": verifyURL}); err != nil {
+		return err
+	}
+
+	if err := tx.CommitEx(ctx); err != nil {
+		slog.Error("Error committing email verification", "error", err)
+		return ErrDatabaseError
+	}
+
+	slog.Info("Sending email verification complete", "user", userId)
+	return nil
+}
+
+// VerifyEmail marks the profile's email as verified, provided it is still the address the link was sent to.
+// Verifying an address twice is allowed.
+func (ps *PostgresStore) VerifyEmail(ctx context.Context, userId int32, email string) error {
+	slog.Info("Verifying email", "user", userId)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE profiles SET emailVerifiedAt = COALESCE(emailVerifiedAt, now()) WHERE id = $1 AND email = $2`
+	tag, err := ps.PostgresConnection.ExecEx(ctx, query, nil, userId, email)
+	if err != nil {
+		slog.Error("Error verifying email", "error", err)
+		return ErrDatabaseError
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrProfileNotFound
+	}
+
+	slog.Info("Verifying email complete", "user", userId)
+	return nil
+}
@@ -0,0 +1,334 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/chammond14/muzz/internal/events"
+	"github.com/chammond14/muzz/internal/webhooks"
+	"github.com/jackc/pgx"
+)
+
+// WebhookSubscription describes a partner endpoint which receives events. An empty EventTypes receives every event.
+// The secret is only returned when the subscription is created.
+type WebhookSubscription struct {
+	Id         int       `json:"id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"eventTypes"`
+	Secret     string    `json:"secret,omitempty"`
+	CreatedBy  *int32    `json:"createdBy,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// WebhookDelivery describes an event sent, or waiting to be sent, to a subscription.
+type WebhookDelivery struct {
+	Id             int64      `json:"id"`
+	SubscriptionId int        `json:"subscriptionId"`
+	EventId        int64      `json:"eventId"`
+	EventType      string     `json:"eventType"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	LastStatusCode *int       `json:"lastStatusCode,omitempty"`
+	LastError      *string    `json:"lastError,omitempty"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+const webhookColumns = `id, url, eventTypes, createdBy, createdAt`
+
+func (w *WebhookSubscription) scanRow(r rowScanner) error {
+	return r.Scan(
+		&w.Id,
+		&w.URL,
+		&w.EventTypes,
+		&w.CreatedBy,
+		&w.CreatedAt,
+	)
+}
+
+const webhookDeliveryColumns = `id, subscriptionId, eventId, eventType, status, attempts, lastStatusCode, lastError,
+	CASE WHEN status = 'pending' THEN nextAttemptAt END, deliveredAt, createdAt`
+
+func (d *WebhookDelivery) scanRow(r rowScanner) error {
+	return r.Scan(
+		&d.Id,
+		&d.SubscriptionId,
+		&d.EventId,
+		&d.EventType,
+		&d.Status,
+		&d.Attempts,
+		&d.LastStatusCode,
+		&d.LastError,
+		&d.NextAttemptAt,
+		&d.DeliveredAt,
+		&d.CreatedAt,
+	)
+}
+
+// WebhookStore describes the data access required to manage webhook subscriptions and send their deliveries
+type WebhookStore interface {
+	CreateWebhook(context.Context, string, []string, string, int32) (*WebhookSubscription, error)
+	GetWebhooks(context.Context) ([]*WebhookSubscription, error)
+	DeleteWebhook(context.Context, int) error
+	GetWebhookDeliveries(context.Context, int, string, int) ([]*WebhookDelivery, error)
+	RetryWebhookDelivery(context.Context, int64) (*WebhookDelivery, error)
+	EnqueueWebhookDeliveries(context.Context, events.Event) (int64, error)
+	ClaimWebhookDeliveries(context.Context, int, time.Duration) ([]webhooks.Delivery, error)
+	RecordWebhookAttempt(context.Context, int64, webhooks.Result) error
+}
+
+func (ps *PostgresStore) CreateWebhook(ctx context.Context, url string, eventTypes []string, secret string, createdBy int32) (*WebhookSubscription, error) {
+	slog.Info("Creating webhook", "url", url)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `INSERT INTO webhook_subscriptions (url, eventTypes, secret, createdBy) VALUES ($1, $2, $3, $4)
+				RETURNING ` + webhookColumns
+
+	webhook := &WebhookSubscription{}
+	err := webhook.scanRow(ps.PostgresConnection.QueryRowEx(ctx, query, nil, url, eventTypes, secret, createdBy))
+	if err != nil {
+		slog.Error("Error creating webhook", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	webhook.Secret = secret
+
+	slog.Info("Creating webhook complete", "webhook", webhook.Id)
+	return webhook, nil
+}
+
+func (ps *PostgresStore) GetWebhooks(ctx context.Context) ([]*WebhookSubscription, error) {
+	slog.Info("Getting webhooks")
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, `SELECT `+webhookColumns+` FROM webhook_subscriptions ORDER BY id`, nil)
+	if err != nil {
+		slog.Error("Error retrieving webhooks", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	webhookList := []*WebhookSubscription{}
+	for rows.Next() {
+		webhook := &WebhookSubscription{}
+		if err := webhook.scanRow(rows); err != nil {
+			slog.Error("Error scanning rows", "method", "GetWebhooks", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		webhookList = append(webhookList, webhook)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "GetWebhooks", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting webhooks complete", "len", len(webhookList))
+	return webhookList, nil
+}
+
+// DeleteWebhook removes a subscription along with its delivery log.
+func (ps *PostgresStore) DeleteWebhook(ctx context.Context, id int) error {
+	slog.Info("Deleting webhook", "webhook", id)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, nil, id)
+	if err != nil {
+		slog.Error("Error deleting webhook", "error", err)
+		return ErrDatabaseError
+	}
+
+	if tag.RowsAffected() == 0 {
+		return ErrWebhookNotFound
+	}
+
+	slog.Info("Deleting webhook complete")
+	return nil
+}
+
+// GetWebhookDeliveries returns the subscription's deliveries, newest first. An empty status returns every delivery.
+func (ps *PostgresStore) GetWebhookDeliveries(ctx context.Context, subscriptionId int, status string, limit int) ([]*WebhookDelivery, error) {
+	slog.Info("Getting webhook deliveries", "webhook", subscriptionId, "status", status)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	var exists bool
+	err := ps.PostgresConnection.QueryRowEx(ctx, `SELECT EXISTS (SELECT 1 FROM webhook_subscriptions WHERE id = $1)`, nil, subscriptionId).Scan(&exists)
+	if err != nil {
+		slog.Error("Error checking webhook", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries
+				WHERE subscriptionId = $1 AND ($2 = '' OR status = $2)
+				ORDER BY id DESC
+				LIMIT $3`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, subscriptionId, status, limit)
+	if err != nil {
+		slog.Error("Error retrieving webhook deliveries", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+	for rows.Next() {
+		delivery := &WebhookDelivery{}
+		if err := delivery.scanRow(rows); err != nil {
+			slog.Error("Error scanning rows", "method", "GetWebhookDeliveries", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		deliveries = append(deliveries, delivery)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "GetWebhookDeliveries", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Getting webhook deliveries complete", "len", len(deliveries))
+	return deliveries, nil
+}
+
+// RetryWebhookDelivery sends a dead delivery again, with a fresh set of attempts.
+func (ps *PostgresStore) RetryWebhookDelivery(ctx context.Context, id int64) (*WebhookDelivery, error) {
+	slog.Info("Retrying webhook delivery", "delivery", id)
+
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE webhook_deliveries SET status = 'pending', attempts = 0, nextAttemptAt = now()
+				WHERE id = $1 AND status = 'dead'
+				RETURNING ` + webhookDeliveryColumns
+
+	delivery := &WebhookDelivery{}
+	err := delivery.scanRow(ps.PostgresConnection.QueryRowEx(ctx, query, nil, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrWebhookDeliveryNotFound
+		}
+
+		slog.Error("Error retrying webhook delivery", "error", err)
+		return nil, ErrDatabaseError
+	}
+
+	slog.Info("Retrying webhook delivery complete")
+	return delivery, nil
+}
+
+// EnqueueWebhookDeliveries creates a delivery of the event for each subscription to its type. Enqueueing the same
+// event twice is ignored, as the outbox may publish an event more than once.
+func (ps *PostgresStore) EnqueueWebhookDeliveries(ctx context.Context, event events.Event) (int64, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Error encoding event", "event", event.Id, "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	query := `INSERT INTO webhook_deliveries (subscriptionId, eventId, eventType, body)
+				SELECT id, $1, $2, $3::jsonb FROM webhook_subscriptions
+				WHERE cardinality(eventTypes) = 0 OR $2 = ANY (eventTypes)
+				ON CONFLICT (subscriptionId, eventId) DO NOTHING`
+
+	tag, err := ps.PostgresConnection.ExecEx(ctx, query, nil, event.Id, event.Type, string(body))
+	if err != nil {
+		slog.Error("Error enqueueing webhook deliveries", "event", event.Id, "error", err)
+		return 0, ErrDatabaseError
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// ClaimWebhookDeliveries returns due deliveries, pushing their next attempt back by the lease so that other
+// replicas skip them while they are sent. A delivery whose outcome is never recorded is sent again after the lease.
+func (ps *PostgresStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]webhooks.Delivery, error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE webhook_deliveries d SET nextAttemptAt = $2
+				FROM webhook_subscriptions s
+				WHERE s.id = d.subscriptionId
+				AND d.id IN (
+					SELECT id FROM webhook_deliveries
+					WHERE status = 'pending' AND nextAttemptAt <= now()
+					ORDER BY id
+					LIMIT $1
+					FOR UPDATE SKIP LOCKED
+				)
+				RETURNING d.id, s.url, s.secret, d.eventId, d.eventType, d.body::text, d.attempts`
+
+	rows, err := ps.PostgresConnection.QueryEx(ctx, query, nil, limit, time.Now().UTC().Add(lease))
+	if err != nil {
+		slog.Error("Error claiming webhook deliveries", "error", err)
+		return nil, ErrDatabaseError
+	}
+	defer rows.Close()
+
+	deliveries := []webhooks.Delivery{}
+	for rows.Next() {
+		delivery := webhooks.Delivery{}
+		var body string
+		err := rows.Scan(&delivery.Id, &delivery.URL, &delivery.Secret, &delivery.EventId, &delivery.EventType, &body, &delivery.Attempts)
+		if err != nil {
+			slog.Error("Error scanning rows", "method", "ClaimWebhookDeliveries", "error", err)
+			return nil, ErrDatabaseError
+		}
+
+		delivery.Body = json.RawMessage(body)
+		deliveries = append(deliveries, delivery)
+	}
+
+	if rows.Err() != nil {
+		slog.Error("Error reading rows", "method", "ClaimWebhookDeliveries", "error", rows.Err())
+		return nil, ErrDatabaseError
+	}
+
+	return deliveries, nil
+}
+
+func (ps *PostgresStore) RecordWebhookAttempt(ctx context.Context, id int64, result webhooks.Result) error {
+	ctx, cancel := context.WithTimeoutCause(ctx, getTimeoutDuration(), ErrQueryTimedOut)
+	defer cancel()
+
+	query := `UPDATE webhook_deliveries SET
+				status = $2,
+				attempts = attempts + CASE WHEN $2 = 'delivered' THEN 0 ELSE 1 END,
+				lastStatusCode = $3,
+				lastError = $4,
+				nextAttemptAt = COALESCE($5, nextAttemptAt),
+				deliveredAt = CASE WHEN $2 = 'delivered' THEN now() END
+				WHERE id = $1`
+
+	var nextAttemptAt *time.Time
+	if result.Status == webhooks.StatusPending {
+		next := result.NextAttemptAt.UTC()
+		nextAttemptAt = &next
+	}
+
+	_, err := ps.PostgresConnection.ExecEx(ctx, query, nil, id, result.Status, result.StatusCode, result.Error, nextAttemptAt)
+	if err != nil {
+		slog.Error("Error recording webhook attempt", "delivery", id, "error", err)
+		return ErrDatabaseError
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Event types written to the outbox.
+const (
+	TypeProfileCreated = "profile.created"
+	TypeSwipeLiked     = "swipe.liked"
+	TypeMatchCreated   = "match.created"
+)
+
+// Aggregate types. Events for the same aggregate are delivered in the order they were written.
+const (
+	AggregateProfile = "profile"
+	// AggregatePair is a pair of profiles, so a like and the match it leads to arrive in order
+	AggregatePair = "pair"
+)
+
+// Event is a domain event recorded in the outbox alongside the change it describes.
+type Event struct {
+	Id            int64           `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregateType"`
+	AggregateId   string          `json:"aggregateId"`
+	Payload       json.RawMessage `json:"payload"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	// Attempts counts previous failed deliveries
+	Attempts int `json:"-"`
+}
+
+// ProfileCreated is the payload of a profile.created event.
+type ProfileCreated struct {
+	ProfileId int32 `json:"profileId"`
+}
+
+// SwipeLiked is the payload of a swipe.liked event, for a like which did not make a match.
+type SwipeLiked struct {
+	SwiperId int32 `json:"swiperId"`
+	SwipedId int32 `json:"swipedId"`
+}
+
+// MatchCreated is the payload of a match.created event.
+type MatchCreated struct {
+	MatchId int   `json:"matchId"`
+	User1Id int32 `json:"user1Id"`
+	User2Id int32 `json:"user2Id"`
+}
+
+// PairId returns the aggregate id of a pair of profiles, which is the same whichever way round they are given.
+func PairId(userId1 int32, userId2 int32) string {
+	if userId1 > userId2 {
+		userId1, userId2 = userId2, userId1
+	}
+
+	return fmt.Sprintf("%d:%d", userId1, userId2)
+}
+
+// Sink is somewhere events are delivered to by the relay. Publish may be called more than once for the same
+// event, so sinks and their consumers should use the event id to ignore duplicates.
+type Sink interface {
+	Name() string
+	Publish(context.Context, Event) error
+}
@@ -0,0 +1,172 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeStore keeps events in memory, following the ordering rules of the outbox.
+type fakeStore struct {
+	events    []Event
+	published map[int64]bool
+	retryAt   map[int64]time.Time
+}
+
+func (f *fakeStore) ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]Event, error) {
+	claimed := []Event{}
+	blocked := map[string]bool{}
+	for _, event := range f.events {
+		aggregate := event.AggregateType + "/" + event.AggregateId
+		if f.published[event.Id] || blocked[aggregate] {
+			continue
+		}
+
+		blocked[aggregate] = true
+		claimed = append(claimed, event)
+	}
+
+	return claimed, nil
+}
+
+func (f *fakeStore) RecordEventAttempt(ctx context.Context, id int64, attempt Attempt) error {
+	for i := range f.events {
+		if f.events[i].Id != id {
+			continue
+		}
+
+		if attempt.Error == nil {
+			f.published[id] = true
+			return nil
+		}
+
+		f.events[i].Attempts++
+		f.retryAt[id] = attempt.NextAttemptAt
+	}
+
+	return nil
+}
+
+type recordingSink struct {
+	received []int64
+	fail     map[int64]int
+}
+
+func (r *recordingSink) Name() string {
+	return "recording"
+}
+
+func (r *recordingSink) Publish(ctx context.Context, event Event) error {
+	if r.fail[event.Id] > 0 {
+		r.fail[event.Id]--
+		return errors.New("unavailable")
+	}
+
+	r.received = append(r.received, event.Id)
+	return nil
+}
+
+func Test_RelayDeliversInOrderPerAggregateAndBacksOff(t *testing.T) {
+	store := &fakeStore{
+		events: []Event{
+			{Id: 1, AggregateType: AggregatePair, AggregateId: "1:2"},
+			{Id: 2, AggregateType: AggregatePair, AggregateId: "3:4"},
+			{Id: 3, AggregateType: AggregatePair, AggregateId: "1:2"},
+		},
+		published: map[int64]bool{},
+		retryAt:   map[int64]time.Time{},
+	}
+
+	sink := &recordingSink{fail: map[int64]int{1: 2}}
+	relay := NewRelay(store, sink)
+	now := time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC)
+	relay.now = func() time.Time { return now }
+
+	for i := 0; i < 4; i++ {
+		relay.RelayOnce(context.Background())
+	}
+
+	expected := []int64{2, 1, 3}
+	if len(sink.received) != len(expected) {
+		t.Fatalf("Expected events %v but got %v", expected, sink.received)
+	}
+
+	for i := range expected {
+		if sink.received[i] != expected[i] {
+			t.Fatalf("Expected events %v but got %v", expected, sink.received)
+		}
+	}
+
+	if wait := store.retryAt[1].Sub(now); wait != 2*time.Second {
+		t.Errorf("Expected second retry to back off for 2s but got %v", wait)
+	}
+}
+
+func Test_RelayBackoffIsCapped(t *testing.T) {
+	relay := NewRelay(nil)
+
+	if wait := relay.backoff(1); wait != time.Second {
+		t.Errorf("Expected first backoff of 1s but got %v", wait)
+	}
+
+	if wait := relay.backoff(50); wait != relay.MaxBackoff {
+		t.Errorf("Expected backoff to be capped at %v but got %v", relay.MaxBackoff, wait)
+	}
+}
+
+func Test_BusDeliversToSubscribers(t *testing.T) {
+	bus := NewBus()
+
+	var matches, all int
+	bus.Subscribe(TypeMatchCreated, func(ctx context.Context, event Event) error {
+		matches++
+		return nil
+	})
+	bus.Subscribe(AllTypes, func(ctx context.Context, event Event) error {
+		all++
+		return nil
+	})
+
+	bus.Publish(context.Background(), Event{Type: TypeMatchCreated})
+	bus.Publish(context.Background(), Event{Type: TypeSwipeLiked})
+
+	if matches != 1 || all != 2 {
+		t.Errorf("Expected 1 match and 2 events to be delivered but got %d and %d", matches, all)
+	}
+}
+
+func Test_WebhookSinkPostsEvent(t *testing.T) {
+	var received Event
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Event-Type") != TypeMatchCreated {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer receiver.Close()
+
+	sink := NewWebhookSink(receiver.URL)
+	event := Event{Id: 9, Type: TypeMatchCreated, Payload: json.RawMessage(`{"matchId":1}`)}
+	if err := sink.Publish(context.Background(), event); err != nil {
+		t.Fatal("Unexpected error publishing event", err)
+	}
+
+	if received.Id != 9 || string(received.Payload) != `{"matchId":1}` {
+		t.Errorf("Expected receiver to get the event but got %+v", received)
+	}
+
+	receiver.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	if err := sink.Publish(context.Background(), event); err == nil {
+		t.Error("Expected an error response to fail the delivery")
+	}
+}
@@ -0,0 +1,125 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultBatchSize    = 100
+	defaultPollInterval = time.Second
+	defaultBaseBackoff  = time.Second
+	defaultMaxBackoff   = 10 * time.Minute
+	// defaultLease is how long claimed events are hidden from other relays while they are delivered
+	defaultLease = 5 * time.Minute
+)
+
+// Attempt describes the outcome of delivering an event to every sink. Error is nil once the event is published.
+type Attempt struct {
+	Error         *string
+	NextAttemptAt time.Time
+}
+
+// Store reads pending events from the outbox.
+type Store interface {
+	// ClaimEvents returns the oldest pending event of up to limit aggregates, hiding them from other claims for the
+	// lease. Later events of an aggregate wait until the earlier ones are published, keeping each aggregate in order.
+	ClaimEvents(ctx context.Context, limit int, lease time.Duration) ([]Event, error)
+	RecordEventAttempt(ctx context.Context, id int64, attempt Attempt) error
+}
+
+// Relay publishes events from the outbox to every sink. Delivery is at least once, since a failure at any sink
+// retries the event at every sink.
+type Relay struct {
+	Store        Store
+	Sinks        []Sink
+	BatchSize    int
+	PollInterval time.Duration
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	Lease        time.Duration
+	now          func() time.Time
+}
+
+func NewRelay(store Store, sinks ...Sink) *Relay {
+	return &Relay{
+		Store:        store,
+		Sinks:        sinks,
+		BatchSize:    defaultBatchSize,
+		PollInterval: defaultPollInterval,
+		BaseBackoff:  defaultBaseBackoff,
+		MaxBackoff:   defaultMaxBackoff,
+		Lease:        defaultLease,
+		now:          time.Now,
+	}
+}
+
+// Run relays events until the context is cancelled. A full batch is followed straight away by the next one.
+func (r *Relay) Run(ctx context.Context) {
+	for {
+		published, err := r.RelayOnce(ctx)
+		if err != nil {
+			slog.Error("Could not relay events", "error", err)
+		}
+
+		if published == r.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(r.PollInterval):
+		}
+	}
+}
+
+// RelayOnce relays a single batch of events, returning how many were published. Events are claimed and their
+// outcomes recorded in short transactions of their own, so a slow sink never holds a database connection.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	claimed, err := r.Store.ClaimEvents(ctx, r.BatchSize, r.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, event := range claimed {
+		attempt := Attempt{}
+		if err := r.deliver(ctx, event); err != nil {
+			message := err.Error()
+			attempt.Error = &message
+			attempt.NextAttemptAt = r.now().Add(r.backoff(event.Attempts + 1))
+		} else {
+			published++
+		}
+
+		if err := r.Store.RecordEventAttempt(ctx, event.Id, attempt); err != nil {
+			return published, err
+		}
+	}
+
+	return published, nil
+}
+
+func (r *Relay) deliver(ctx context.Context, event Event) error {
+	for _, sink := range r.Sinks {
+		if err := sink.Publish(ctx, event); err != nil {
+			slog.Info("Could not deliver event", "id", event.Id, "type", event.Type, "sink", sink.Name(), "attempts", event.Attempts+1, "error", err)
+			return fmt.Errorf("%s: %w", sink.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// backoff doubles the wait after each failed attempt, up to MaxBackoff.
+func (r *Relay) backoff(attempts int) time.Duration {
+	wait := r.BaseBackoff
+	for i := 1; i < attempts && wait < r.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	return min(wait, r.MaxBackoff)
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LogSink writes each event to the log, which is useful during development.
+type LogSink struct{}
+
+func (LogSink) Name() string {
+	return "log"
+}
+
+func (LogSink) Publish(ctx context.Context, event Event) error {
+	slog.Info("Event published", "id", event.Id, "type", event.Type, "aggregate", event.AggregateType+"/"+event.AggregateId, "payload", string(event.Payload))
+	return nil
+}
+
+// WebhookSink POSTs each event as JSON to a URL. Any response other than 2xx is a failed delivery.
+type WebhookSink struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (w *WebhookSink) Name() string {
+	return "webhook"
+}
+
+func (w *WebhookSink) Publish(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", strconv.FormatInt(event.Id, 10))
+	req.Header.Set("X-Event-Type", event.Type)
+
+	res, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// Handler receives events from the bus.
+type Handler func(context.Context, Event) error
+
+// Bus delivers events to handlers subscribed in process.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+// AllTypes subscribes a handler to every event type.
+const AllTypes = "*"
+
+func NewBus() *Bus {
+	return &Bus{handlers: map[string][]Handler{}}
+}
+
+func (b *Bus) Name() string {
+	return "bus"
+}
+
+// Subscribe registers a handler for an event type, or AllTypes.
+func (b *Bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish calls every handler subscribed to the event's type in turn. A handler failing fails the delivery, so
+// the event is published again later and handlers which succeeded will see it twice.
+func (b *Bus) Publish(ctx context.Context, event Event) error {
+	b.mu.RLock()
+	handlers := append(append([]Handler{}, b.handlers[event.Type]...), b.handlers[AllTypes]...)
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, handler := range handlers {
+		if err := handler(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,32 @@
+package imaging
+
+import (
+	"image"
+	"math/bits"
+
+	"golang.org/x/image/draw"
+)
+
+// DHash computes the 64 bit difference hash of an image. Visually similar images, including resized or
+// re-encoded copies, have hashes a small Hamming distance apart.
+func DHash(img image.Image) uint64 {
+	// one more column than bits per row, as each bit compares a pixel with its right hand neighbour
+	gray := image.NewGray(image.Rect(0, 0, 9, 8))
+	draw.BiLinear.Scale(gray, gray.Bounds(), img, img.Bounds(), draw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray.GrayAt(x, y).Y < gray.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+func HammingDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,119 @@
+// Package imaging validates uploaded images and produces the resized, metadata free copies that are stored.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+
+	"github.com/gabriel-vasile/mimetype"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedType = errors.New("unsupported image type")
+	ErrTooLarge        = errors.New("image dimensions too large")
+	ErrInvalidImage    = errors.New("could not decode image")
+)
+
+// MaxPixels bounds the decoded size of an image so a small upload cannot exhaust memory.
+const MaxPixels = 50_000_000
+
+// Size describes a standard size images are resized to. Images are scaled so their longest edge is at
+// most MaxEdge pixels, and are never scaled up.
+type Size struct {
+	Name    string
+	MaxEdge int
+}
+
+var Sizes = []Size{
+	{Name: "small", MaxEdge: 160},
+	{Name: "medium", MaxEdge: 480},
+	{Name: "large", MaxEdge: 1080},
+}
+
+var supportedTypes = []string{"image/jpeg", "image/png", "image/webp"}
+
+// Sniff detects the content type of the image from its contents, ignoring any name or header supplied with it.
+func Sniff(data []byte) (string, error) {
+	detected := mimetype.Detect(data)
+	for _, supported := range supportedTypes {
+		if detected.Is(supported) {
+			return supported, nil
+		}
+	}
+
+	return detected.String(), ErrUnsupportedType
+}
+
+// Decode decodes an image, applying any EXIF orientation so the pixels are upright.
+// The returned image carries no metadata.
+func Decode(data []byte) (image.Image, error) {
+	if _, err := Sniff(data); err != nil {
+		return nil, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	if config.Width*config.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	return applyOrientation(img, jpegOrientation(data)), nil
+}
+
+// Processed is an uploaded image ready to be stored.
+type Processed struct {
+	// Sizes holds a JPEG for each of the standard sizes, keyed by size name.
+	Sizes map[string][]byte
+	Hash  uint64
+}
+
+// Process decodes an image, encodes a JPEG for each of the standard sizes and computes its perceptual hash.
+// Re-encoding from pixels means no EXIF or other metadata from the upload is kept.
+func Process(data []byte) (*Processed, error) {
+	img, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	processed := &Processed{Sizes: make(map[string][]byte, len(Sizes)), Hash: DHash(img)}
+	for _, size := range Sizes {
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, Resize(img, size.MaxEdge), &jpeg.Options{Quality: 85}); err != nil {
+			return nil, err
+		}
+
+		processed.Sizes[size.Name] = buf.Bytes()
+	}
+
+	return processed, nil
+}
+
+// Resize scales the image so its longest edge is at most maxEdge pixels.
+func Resize(img image.Image, maxEdge int) image.Image {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxEdge && height <= maxEdge {
+		width, height = max(width, 1), max(height, 1)
+	} else if width >= height {
+		width, height = maxEdge, max(height*maxEdge/width, 1)
+	} else {
+		width, height = max(width*maxEdge/height, 1), maxEdge
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, bounds, draw.Src, nil)
+	return dst
+}
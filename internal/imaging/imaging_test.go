@@ -0,0 +1,109 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"testing"
+)
+
+// withExifOrientation inserts an APP1 segment holding a little endian TIFF header with only an orientation tag.
+func withExifOrientation(jpg []byte, orientation byte) []byte {
+	tiff := []byte{
+		'I', 'I', 42, 0, 8, 0, 0, 0, // header, IFD0 at offset 8
+		1, 0, // one entry
+		0x12, 0x01, 3, 0, 1, 0, 0, 0, orientation, 0, 0, 0, // orientation, SHORT, count 1
+		0, 0, 0, 0, // no next IFD
+	}
+	segment := append([]byte("Exif\x00\x00"), tiff...)
+	length := len(segment) + 2
+
+	out := append([]byte{}, jpg[:2]...)
+	out = append(out, 0xFF, 0xE1, byte(length>>8), byte(length))
+	out = append(out, segment...)
+	return append(out, jpg[2:]...)
+}
+
+func testJpeg(t *testing.T, width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 100, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal("Unexpected error encoding jpeg", err)
+	}
+
+	return buf.Bytes()
+}
+
+func Test_ProcessResizesAndStripsMetadata(t *testing.T) {
+	data := withExifOrientation(testJpeg(t, 2000, 1000), 1)
+
+	processed, err := Process(data)
+	if err != nil {
+		t.Fatal("Unexpected error processing image", err)
+	}
+
+	for _, size := range Sizes {
+		out := processed.Sizes[size.Name]
+		if bytes.Contains(out, []byte("Exif")) {
+			t.Errorf("Expected %s image to have no EXIF data", size.Name)
+		}
+
+		config, err := jpeg.DecodeConfig(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("Unexpected error decoding %s image: %v", size.Name, err)
+		}
+
+		if config.Width != size.MaxEdge || config.Height != size.MaxEdge/2 {
+			t.Errorf("Expected %s image to be %dx%d but got %dx%d", size.Name, size.MaxEdge, size.MaxEdge/2, config.Width, config.Height)
+		}
+	}
+}
+
+func Test_DecodeAppliesExifOrientation(t *testing.T) {
+	data := withExifOrientation(testJpeg(t, 40, 20), 6)
+
+	img, err := Decode(data)
+	if err != nil {
+		t.Fatal("Unexpected error decoding image", err)
+	}
+
+	if img.Bounds().Dx() != 20 || img.Bounds().Dy() != 40 {
+		t.Errorf("Expected rotated image to be 20x40 but got %dx%d", img.Bounds().Dx(), img.Bounds().Dy())
+	}
+}
+
+func Test_SniffRejectsNonImages(t *testing.T) {
+	if _, err := Sniff([]byte("<html><body>not an image</body></html>")); err != ErrUnsupportedType {
+		t.Errorf("Expected unsupported type error but got %v", err)
+	}
+}
+
+func Test_DHashMatchesResizedCopies(t *testing.T) {
+	original, err := Decode(testJpeg(t, 400, 300))
+	if err != nil {
+		t.Fatal("Unexpected error decoding image", err)
+	}
+
+	different := image.NewRGBA(image.Rect(0, 0, 400, 300))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 300; y++ {
+			different.Set(x, y, color.RGBA{R: uint8(255 - x), G: uint8(y * x), B: uint8(y), A: 255})
+		}
+	}
+
+	hash := DHash(original)
+	if distance := HammingDistance(hash, DHash(Resize(original, 160))); distance > 4 {
+		t.Errorf("Expected resized copy to be within distance 4 but got %d", distance)
+	}
+
+	if distance := HammingDistance(hash, DHash(different)); distance <= 4 {
+		t.Errorf("Expected different image to be further than distance 4 but got %d", distance)
+	}
+}
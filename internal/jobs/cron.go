@@ -0,0 +1,181 @@
+package jobs
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSchedule = errors.New("invalid cron schedule")
+
+// Schedule is a parsed cron expression. Times are matched in UTC.
+type Schedule struct {
+	expr       string
+	minutes    uint64
+	hours      uint64
+	daysOfMon  uint64
+	months     uint64
+	daysOfWeek uint64
+	// when both day fields are restricted a time matches either of them, as in standard cron
+	anyDayOfMon  bool
+	anyDayOfWeek bool
+}
+
+var macros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// ParseSchedule parses a standard five field cron expression (minute, hour, day of month, month, day of week).
+// Fields accept *, numbers, ranges, lists and steps such as */15 or 1-5. The @hourly, @daily, @midnight, @weekly
+// and @monthly shorthands are also accepted.
+func ParseSchedule(expr string) (*Schedule, error) {
+	fields := strings.Fields(expr)
+	if macro, ok := macros[strings.TrimSpace(expr)]; ok {
+		fields = strings.Fields(macro)
+	}
+
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w %q: expected 5 fields", ErrInvalidSchedule, expr)
+	}
+
+	schedule := &Schedule{expr: expr}
+	bounds := []struct {
+		field    *uint64
+		min, max int
+	}{
+		{&schedule.minutes, 0, 59},
+		{&schedule.hours, 0, 23},
+		{&schedule.daysOfMon, 1, 31},
+		{&schedule.months, 1, 12},
+		{&schedule.daysOfWeek, 0, 7},
+	}
+
+	for i, bound := range bounds {
+		bits, err := parseField(fields[i], bound.min, bound.max)
+		if err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidSchedule, expr, err)
+		}
+
+		*bound.field = bits
+	}
+
+	// 7 is an alias for sunday
+	if schedule.daysOfWeek&(1<<7) != 0 {
+		schedule.daysOfWeek |= 1
+	}
+
+	schedule.anyDayOfMon = strings.HasPrefix(fields[2], "*")
+	schedule.anyDayOfWeek = strings.HasPrefix(fields[4], "*")
+
+	return schedule, nil
+}
+
+// MustParseSchedule is like ParseSchedule but panics if the expression is invalid, for schedules fixed in code.
+func MustParseSchedule(expr string) *Schedule {
+	schedule, err := ParseSchedule(expr)
+	if err != nil {
+		panic(err)
+	}
+
+	return schedule
+}
+
+func parseField(field string, min int, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if before, after, found := strings.Cut(part, "/"); found {
+			var err error
+			rangePart = before
+			step, err = strconv.Atoi(after)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+		}
+
+		low, high := min, max
+		if rangePart != "*" {
+			lowPart, highPart, isRange := strings.Cut(rangePart, "-")
+
+			var err error
+			low, err = strconv.Atoi(lowPart)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value in %q", part)
+			}
+
+			high = low
+			if isRange {
+				high, err = strconv.Atoi(highPart)
+				if err != nil {
+					return 0, fmt.Errorf("invalid range in %q", part)
+				}
+			} else if step > 1 {
+				high = max
+			}
+		}
+
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+
+	return bits, nil
+}
+
+// Next returns the first time after the given time which matches the schedule.
+func (s *Schedule) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+
+	// every schedule matches at least once in a little over four years, allowing for 29th February
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.months&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC)
+			continue
+		}
+
+		if s.hours&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour)
+			continue
+		}
+
+		if s.minutes&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return limit
+}
+
+func (s *Schedule) matchesDay(t time.Time) bool {
+	dayOfMon := s.daysOfMon&(1<<uint(t.Day())) != 0
+	dayOfWeek := s.daysOfWeek&(1<<uint(t.Weekday())) != 0
+
+	if s.anyDayOfMon || s.anyDayOfWeek {
+		return dayOfMon && dayOfWeek
+	}
+
+	return dayOfMon || dayOfWeek
+}
+
+func (s *Schedule) String() string {
+	return s.expr
+}
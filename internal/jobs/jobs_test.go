@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_ScheduleNextFindsFollowingMatch(t *testing.T) {
+	from := time.Date(2024, time.March, 15, 10, 7, 30, 0, time.UTC)
+
+	cases := []struct {
+		expr     string
+		expected time.Time
+	}{
+		{"*/15 * * * *", time.Date(2024, time.March, 15, 10, 15, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, time.March, 15, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, time.March, 16, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2024, time.March, 18, 9, 30, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 * 0", time.Date(2024, time.March, 17, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2024, time.March, 17, 12, 0, 0, 0, time.UTC)},
+		{"5,10 10 15 3 *", time.Date(2024, time.March, 15, 10, 10, 0, 0, time.UTC)},
+	}
+
+	for _, c := range cases {
+		schedule, err := ParseSchedule(c.expr)
+		if err != nil {
+			t.Errorf("Unexpected error parsing %q: %v", c.expr, err)
+			continue
+		}
+
+		if next := schedule.Next(from); !next.Equal(c.expected) {
+			t.Errorf("Expected %q to next run at %v but got %v", c.expr, c.expected, next)
+		}
+	}
+}
+
+func Test_ParseScheduleRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseSchedule(expr); !errors.Is(err, ErrInvalidSchedule) {
+			t.Errorf("Expected %q to be rejected but got %v", expr, err)
+		}
+	}
+}
+
+type fakeStore struct {
+	due  map[string]bool
+	runs []string
+}
+
+func (f *fakeStore) RunJob(ctx context.Context, name string, next func(time.Time) time.Time, run func(context.Context) error) (bool, error) {
+	if !f.due[name] {
+		return false, nil
+	}
+
+	if err := run(ctx); err != nil {
+		return true, err
+	}
+
+	f.runs = append(f.runs, name)
+	f.due[name] = false
+	return true, nil
+}
+
+func Test_RunnerRunsDueJobsAndRetriesFailures(t *testing.T) {
+	store := &fakeStore{due: map[string]bool{"ok": true, "failing": true, "idle": false}}
+	runner := NewRunner(store)
+
+	attempts := 0
+	runner.Add("failing", "* * * * *", func(ctx context.Context) error {
+		attempts++
+		if attempts == 1 {
+			return errors.New("boom")
+		}
+
+		return nil
+	})
+	runner.Add("ok", "* * * * *", func(ctx context.Context) error { return nil })
+	runner.Add("idle", "* * * * *", func(ctx context.Context) error {
+		t.Error("Expected job which is not due not to run")
+		return nil
+	})
+
+	runner.RunDue(context.Background())
+	runner.RunDue(context.Background())
+
+	if attempts != 2 || len(store.runs) != 2 || store.runs[0] != "ok" || store.runs[1] != "failing" {
+		t.Errorf("Expected failed job to be retried, got %d attempts and runs %v", attempts, store.runs)
+	}
+}
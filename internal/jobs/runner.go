@@ -0,0 +1,86 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const defaultPollInterval = 30 * time.Second
+
+// Store records job runs and ensures each job runs on only one replica at a time.
+type Store interface {
+	// RunJob calls run if the named job is due and no other replica holds its lock, then records the outcome and
+	// schedules the next run using next. A job whose run fails stays due, so it is retried on the next poll.
+	RunJob(ctx context.Context, name string, next func(time.Time) time.Time, run func(context.Context) error) (bool, error)
+}
+
+// Job is a task run periodically on its schedule.
+type Job struct {
+	Name     string
+	Schedule *Schedule
+	Run      func(context.Context) error
+}
+
+// Runner runs jobs in process. Jobs run at least once per scheduled time: a run interrupted before its outcome
+// is recorded is run again, so jobs must be safe to repeat.
+type Runner struct {
+	Store        Store
+	Jobs         []Job
+	PollInterval time.Duration
+}
+
+func NewRunner(store Store) *Runner {
+	return &Runner{Store: store, PollInterval: defaultPollInterval}
+}
+
+// Add registers a job on the given cron schedule.
+func (r *Runner) Add(name string, schedule string, run func(context.Context) error) error {
+	parsed, err := ParseSchedule(schedule)
+	if err != nil {
+		return err
+	}
+
+	r.Jobs = append(r.Jobs, Job{Name: name, Schedule: parsed, Run: run})
+	return nil
+}
+
+// Run polls for due jobs until the context is cancelled.
+func (r *Runner) Run(ctx context.Context) {
+	pollInterval := r.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = defaultPollInterval
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		r.RunDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunDue runs each job that is due, one after another.
+func (r *Runner) RunDue(ctx context.Context) {
+	for _, job := range r.Jobs {
+		if ctx.Err() != nil {
+			return
+		}
+
+		ran, err := r.Store.RunJob(ctx, job.Name, job.Schedule.Next, job.Run)
+		if err != nil {
+			slog.Error("Job failed", "job", job.Name, "error", err)
+			continue
+		}
+
+		if ran {
+			slog.Info("Job complete", "job", job.Name)
+		}
+	}
+}
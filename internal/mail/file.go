@@ -0,0 +1,47 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes each email to Dir as an .eml file instead of sending it, for local development and tests.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func NewFileMailer(dir string, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &FileMailer{Dir: dir, From: from}, nil
+}
+
+func (f *FileMailer) Send(ctx context.Context, message Message) error {
+	body, err := message.Bytes(f.From)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(f.Dir, fmt.Sprintf("%d-*.eml", time.Now().UnixNano()))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := file.Write(body); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// Files lists the emails written so far, oldest first.
+func (f *FileMailer) Files() ([]string, error) {
+	return filepath.Glob(filepath.Join(f.Dir, "*.eml"))
+}
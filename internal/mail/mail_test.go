@@ -0,0 +1,208 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chammond14/muzz/internal/mail/mailtest"
+)
+
+type fakeStore struct {
+	queued  []Queued
+	results map[int64]Result
+}
+
+func (f *fakeStore) ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]Queued, error) {
+	claimed := f.queued
+	f.queued = nil
+	return claimed, nil
+}
+
+func (f *fakeStore) RecordMailAttempt(ctx context.Context, id int64, result Result) error {
+	f.results[id] = result
+	return nil
+}
+
+type failingMailer struct{}
+
+func (failingMailer) Send(ctx context.Context, message Message) error {
+	return errors.New("connection refused")
+}
+
+func loadTemplates(t *testing.T) *Templates {
+	templates, err := LoadTemplates()
+	if err != nil {
+		t.Fatal("Unexpected error loading templates", err)
+	}
+
+	return templates
+}
+
+func Test_RenderUsesRecipientLocaleWithFallback(t *testing.T) {
+	templates := loadTemplates(t)
+	data := map[string]any{"name": "Ana", "scheduledFor": "2024-07-01"}
+
+	tests := []struct {
+		locale  string
+		subject string
+	}{
+		{"es", "Tu cuenta de Muzz será eliminada"},
+		{"es-MX", "Tu cuenta de Muzz será eliminada"},
+		{"en-GB", "Your Muzz account will be deleted"},
+		{"fr", "Your Muzz account will be deleted"},
+	}
+
+	for _, test := range tests {
+		message, err := templates.Render(TemplateAccountDeletion, test.locale, "ana@example.com", data)
+		if err != nil {
+			t.Fatalf("Unexpected error rendering %s: %v", test.locale, err)
+		}
+
+		if message.Subject != test.subject || message.To != "ana@example.com" || !strings.Contains(message.Text, "2024-07-01") {
+			t.Errorf("Unexpected %s email %+v", test.locale, message)
+		}
+	}
+
+	if _, err := templates.Render("missing", "en", "ana@example.com", data); err == nil {
+		t.Error("Expected an error rendering a missing template")
+	}
+}
+
+func Test_RenderEscapesHTML(t *testing.T) {
+	message, err := loadTemplates(t).Render(TemplateAccountDeletion, "en", "a@example.com", map[string]any{"name": "<b>Bob</b>"})
+	if err != nil {
+		t.Fatal("Unexpected error rendering", err)
+	}
+
+	if strings.Contains(message.HTML, "<b>Bob</b>") || !strings.Contains(message.HTML, "&lt;b&gt;Bob&lt;/b&gt;") {
+		t.Errorf("Expected the name to be escaped in %s", message.HTML)
+	}
+}
+
+func Test_SMTPMailerSendsMultipartEmail(t *testing.T) {
+	server, err := mailtest.NewServer()
+	if err != nil {
+		t.Fatal("Unexpected error starting SMTP server", err)
+	}
+	defer server.Close()
+
+	mailer := &SMTPMailer{Addr: server.Addr, From: "Muzz <no-reply@muzz.com>"}
+	message := Message{To: "Ana <ana@example.com>", Subject: "¡Hola!", Text: "Plain body", HTML: "<p>HTML body</p>"}
+	if err := mailer.Send(context.Background(), message); err != nil {
+		t.Fatal("Unexpected error sending", err)
+	}
+
+	received := server.Messages()
+	if len(received) != 1 || received[0].From != "no-reply@muzz.com" || len(received[0].To) != 1 || received[0].To[0] != "ana@example.com" {
+		t.Fatalf("Unexpected envelope %+v", received)
+	}
+
+	email, err := mail.ReadMessage(strings.NewReader(string(received[0].Data)))
+	if err != nil {
+		t.Fatal("Unexpected error parsing email", err)
+	}
+
+	if subject, _ := new(mime.WordDecoder).DecodeHeader(email.Header.Get("Subject")); subject != "¡Hola!" {
+		t.Errorf("Expected subject to round trip but got %q", subject)
+	}
+
+	_, params, _ := strings.Cut(email.Header.Get("Content-Type"), "boundary=")
+	parts := multipart.NewReader(email.Body, strings.Trim(params, `"`))
+	var bodies []string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatal("Unexpected error reading part", err)
+		}
+
+		body, _ := io.ReadAll(part)
+		bodies = append(bodies, part.Header.Get("Content-Type")+": "+string(body))
+	}
+
+	if len(bodies) != 2 || bodies[0] != "text/plain; charset=utf-8: Plain body" || bodies[1] != "text/html; charset=utf-8: <p>HTML body</p>" {
+		t.Errorf("Unexpected parts %q", bodies)
+	}
+}
+
+func Test_FileMailerWritesEmlFiles(t *testing.T) {
+	mailer, err := NewFileMailer(t.TempDir(), "no-reply@muzz.com")
+	if err != nil {
+		t.Fatal("Unexpected error creating mailer", err)
+	}
+
+	if err := mailer.Send(context.Background(), Message{To: "ana@example.com", Subject: "Hi", Text: "Hello"}); err != nil {
+		t.Fatal("Unexpected error sending", err)
+	}
+
+	files, err := mailer.Files()
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expected one email file but got %v, %v", files, err)
+	}
+
+	contents, _ := os.ReadFile(files[0])
+	if !strings.Contains(string(contents), "To: ana@example.com") {
+		t.Errorf("Unexpected email file %s", contents)
+	}
+}
+
+func Test_SenderRetriesUntilDead(t *testing.T) {
+	data, _ := json.Marshal(map[string]string{"name": "Ana", "scheduledFor": "2024-07-01"})
+	store := &fakeStore{
+		queued: []Queued{
+			{Id: 1, To: "ana@example.com", Template: TemplateAccountDeletion, Locale: "en", Data: data},
+			{Id: 2, To: "bob@example.com", Template: TemplateAccountDeletion, Locale: "en", Data: data, Attempts: 5},
+		},
+		results: map[int64]Result{},
+	}
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	sender := NewSender(store, failingMailer{}, loadTemplates(t))
+	sender.now = func() time.Time { return now }
+	if _, err := sender.SendOnce(context.Background()); err != nil {
+		t.Fatal("Unexpected error sending", err)
+	}
+
+	if result := store.results[1]; result.Status != StatusPending || !result.NextAttemptAt.Equal(now.Add(time.Minute)) || result.Error == nil {
+		t.Errorf("Expected a retry in a minute but got %+v", result)
+	}
+
+	if result := store.results[2]; result.Status != StatusDead {
+		t.Errorf("Expected the email to be dead after %d attempts but got %+v", sender.MaxAttempts, result)
+	}
+}
+
+func Test_SenderSendsThroughSMTP(t *testing.T) {
+	server, err := mailtest.NewServer()
+	if err != nil {
+		t.Fatal("Unexpected error starting SMTP server", err)
+	}
+	defer server.Close()
+
+	data, _ := json.Marshal(map[string]string{"name": "Ana", "scheduledFor": "2024-07-01"})
+	store := &fakeStore{
+		queued:  []Queued{{Id: 1, To: "ana@example.com", Template: TemplateAccountDeletion, Locale: "es", Data: data}},
+		results: map[int64]Result{},
+	}
+
+	sender := NewSender(store, &SMTPMailer{Addr: server.Addr, From: "no-reply@muzz.com"}, loadTemplates(t))
+	if _, err := sender.SendOnce(context.Background()); err != nil {
+		t.Fatal("Unexpected error sending", err)
+	}
+
+	if store.results[1].Status != StatusSent || len(server.Messages()) != 1 {
+		t.Errorf("Expected the email to be sent but got %+v", store.results[1])
+	}
+}
@@ -0,0 +1,131 @@
+// Package mailtest provides an in-process SMTP server for tests.
+package mailtest
+
+import (
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+)
+
+// Received is an email accepted by the server.
+type Received struct {
+	From string
+	To   []string
+	Data []byte
+}
+
+// Server is a minimal SMTP server which accepts every email and keeps it in memory. It does not offer
+// STARTTLS or AUTH.
+type Server struct {
+	Addr     string
+	listener net.Listener
+	mu       sync.Mutex
+	received []Received
+	wg       sync.WaitGroup
+}
+
+// NewServer starts a server on a random local port.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := &Server{Addr: listener.Addr().String(), listener: listener}
+	server.wg.Add(1)
+	go server.serve()
+	return server, nil
+}
+
+// Messages returns the emails received so far.
+func (s *Server) Messages() []Received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Received{}, s.received...)
+}
+
+// Close stops the server and waits for open connections to finish.
+func (s *Server) Close() error {
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+
+	text := textproto.NewConn(conn)
+	reply := func(code int, message string) {
+		text.PrintfLine("%d %s", code, message)
+	}
+
+	reply(220, "mailtest ready")
+
+	var current Received
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command, argument, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			reply(250, "mailtest")
+		case "MAIL":
+			current = Received{From: address(argument)}
+			reply(250, "OK")
+		case "RCPT":
+			current.To = append(current.To, address(argument))
+			reply(250, "OK")
+		case "DATA":
+			reply(354, "End data with <CR><LF>.<CR><LF>")
+			data, err := io.ReadAll(text.DotReader())
+			if err != nil {
+				return
+			}
+
+			current.Data = data
+			s.mu.Lock()
+			s.received = append(s.received, current)
+			s.mu.Unlock()
+			reply(250, "OK")
+		case "RSET":
+			current = Received{}
+			reply(250, "OK")
+		case "NOOP":
+			reply(250, "OK")
+		case "QUIT":
+			reply(221, "Bye")
+			return
+		default:
+			reply(502, "Command not implemented")
+		}
+	}
+}
+
+// address extracts the address from a MAIL FROM:<a@b> or RCPT TO:<a@b> argument.
+func address(argument string) string {
+	_, value, _ := strings.Cut(argument, ":")
+	return strings.Trim(strings.TrimSpace(value), "<>")
+}
@@ -0,0 +1,162 @@
+package mail
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// Statuses of a queued email.
+const (
+	StatusPending = "pending"
+	StatusSent    = "sent"
+	StatusDead    = "dead"
+)
+
+const (
+	defaultMaxAttempts  = 6
+	defaultBaseBackoff  = time.Minute
+	defaultMaxBackoff   = time.Hour
+	defaultBatchSize    = 20
+	defaultPollInterval = 5 * time.Second
+	// defaultLease is how long a claimed email is hidden from other senders while it is attempted
+	defaultLease = 2 * time.Minute
+)
+
+// Queued is an email waiting to be rendered and sent.
+type Queued struct {
+	Id       int64
+	To       string
+	Template string
+	Locale   string
+	Data     json.RawMessage
+	// Attempts counts previous failed attempts
+	Attempts int
+}
+
+// Result describes the outcome of an attempt to send a queued email.
+type Result struct {
+	Status        string
+	Error         *string
+	NextAttemptAt time.Time
+}
+
+// Store holds queued email.
+type Store interface {
+	// ClaimMail returns up to limit due emails, hiding them from other claims for the lease.
+	ClaimMail(ctx context.Context, limit int, lease time.Duration) ([]Queued, error)
+	RecordMailAttempt(ctx context.Context, id int64, result Result) error
+}
+
+// Sender renders and sends queued email, retrying failures with exponential backoff until MaxAttempts is reached.
+type Sender struct {
+	Store        Store
+	Mailer       Mailer
+	Templates    *Templates
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	BatchSize    int
+	PollInterval time.Duration
+	Lease        time.Duration
+	now          func() time.Time
+}
+
+func NewSender(store Store, mailer Mailer, templates *Templates) *Sender {
+	return &Sender{
+		Store:        store,
+		Mailer:       mailer,
+		Templates:    templates,
+		MaxAttempts:  defaultMaxAttempts,
+		BaseBackoff:  defaultBaseBackoff,
+		MaxBackoff:   defaultMaxBackoff,
+		BatchSize:    defaultBatchSize,
+		PollInterval: defaultPollInterval,
+		Lease:        defaultLease,
+		now:          time.Now,
+	}
+}
+
+// Run sends email until the context is cancelled.
+func (s *Sender) Run(ctx context.Context) {
+	for {
+		sent, err := s.SendOnce(ctx)
+		if err != nil {
+			slog.Error("Could not send email", "error", err)
+		}
+
+		if sent == s.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(s.PollInterval):
+		}
+	}
+}
+
+// SendOnce attempts a single batch of email, returning how many were attempted.
+func (s *Sender) SendOnce(ctx context.Context) (int, error) {
+	queued, err := s.Store.ClaimMail(ctx, s.BatchSize, s.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, email := range queued {
+		result := s.attempt(ctx, email)
+		if err := s.Store.RecordMailAttempt(ctx, email.Id, result); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(queued), nil
+}
+
+func (s *Sender) attempt(ctx context.Context, email Queued) Result {
+	err := s.send(ctx, email)
+	if err == nil {
+		return Result{Status: StatusSent}
+	}
+
+	message := err.Error()
+	result := Result{Error: &message}
+
+	attempts := email.Attempts + 1
+	if attempts >= s.MaxAttempts {
+		slog.Info("Email is dead", "email", email.Id, "template", email.Template, "attempts", attempts, "error", err)
+		result.Status = StatusDead
+		return result
+	}
+
+	result.Status = StatusPending
+	result.NextAttemptAt = s.now().Add(s.backoff(attempts))
+	slog.Info("Email failed to send", "email", email.Id, "template", email.Template, "attempts", attempts, "retryAt", result.NextAttemptAt, "error", err)
+	return result
+}
+
+func (s *Sender) send(ctx context.Context, email Queued) error {
+	var data map[string]any
+	if err := json.Unmarshal(email.Data, &data); err != nil {
+		return err
+	}
+
+	message, err := s.Templates.Render(email.Template, email.Locale, email.To, data)
+	if err != nil {
+		return err
+	}
+
+	return s.Mailer.Send(ctx, message)
+}
+
+// backoff doubles the wait after each failed attempt, up to MaxBackoff.
+func (s *Sender) backoff(attempts int) time.Duration {
+	wait := s.BaseBackoff
+	for i := 1; i < attempts && wait < s.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	return min(wait, s.MaxBackoff)
+}
@@ -0,0 +1,101 @@
+package mail
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// defaultSMTPTimeout bounds a whole SMTP conversation when the context has no deadline
+const defaultSMTPTimeout = 30 * time.Second
+
+// SMTPMailer sends email through an SMTP server, upgrading to TLS when the server offers STARTTLS.
+// Username and Password are only used when both are set.
+type SMTPMailer struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (s *SMTPMailer) Send(ctx context.Context, message Message) error {
+	body, err := message.Bytes(s.From)
+	if err != nil {
+		return err
+	}
+
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return err
+	}
+
+	to, err := mail.ParseAddress(message.To)
+	if err != nil {
+		return err
+	}
+
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(defaultSMTPTimeout)
+	}
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+
+	if s.Username != "" && s.Password != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from.Address); err != nil {
+		return err
+	}
+
+	if err := client.Rcpt(to.Address); err != nil {
+		return err
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(body); err != nil {
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
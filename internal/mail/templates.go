@@ -0,0 +1,113 @@
+package mail
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+)
+
+// DefaultLocale is used when a template has no variant for the recipient's locale.
+const DefaultLocale = "en"
+
+// Template names.
+const (
+	TemplateAccountDeletion = "account_deletion"
+	TemplatePasswordReset   = "password_reset"
+	TemplateVerifyEmail     = "verify_email"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+// Templates renders emails from the embedded templates. Each email is three files in a directory per locale,
+// templates/{locale}/{name}.subject.txt, {name}.txt and {name}.html.
+type Templates struct {
+	subjects map[string]*texttemplate.Template
+	texts    map[string]*texttemplate.Template
+	htmls    map[string]*htmltemplate.Template
+	locales  []string
+}
+
+// LoadTemplates parses every embedded template.
+func LoadTemplates() (*Templates, error) {
+	t := &Templates{
+		subjects: map[string]*texttemplate.Template{},
+		texts:    map[string]*texttemplate.Template{},
+		htmls:    map[string]*htmltemplate.Template{},
+	}
+
+	locales, err := fs.ReadDir(templateFiles, "templates")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, locale := range locales {
+		t.locales = append(t.locales, locale.Name())
+
+		files, err := fs.Glob(templateFiles, path.Join("templates", locale.Name(), "*.html"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			key := locale.Name() + "/" + strings.TrimSuffix(path.Base(file), ".html")
+
+			if t.htmls[key], err = htmltemplate.ParseFS(templateFiles, file); err != nil {
+				return nil, err
+			}
+
+			if t.texts[key], err = texttemplate.ParseFS(templateFiles, strings.TrimSuffix(file, ".html")+".txt"); err != nil {
+				return nil, err
+			}
+
+			if t.subjects[key], err = texttemplate.ParseFS(templateFiles, strings.TrimSuffix(file, ".html")+".subject.txt"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.Strings(t.locales)
+	return t, nil
+}
+
+// Locales lists the locales with templates.
+func (t *Templates) Locales() []string {
+	return t.locales
+}
+
+// Render builds the email for the template in the recipient's locale. A regional locale such as en-GB falls back
+// to its language, then to DefaultLocale.
+func (t *Templates) Render(name string, locale string, to string, data any) (Message, error) {
+	key := ""
+	for _, candidate := range []string{locale, strings.SplitN(locale, "-", 2)[0], DefaultLocale} {
+		if _, ok := t.htmls[candidate+"/"+name]; ok {
+			key = candidate + "/" + name
+			break
+		}
+	}
+
+	if key == "" {
+		return Message{}, fmt.Errorf("no email template %q", name)
+	}
+
+	var subject, text, html bytes.Buffer
+	if err := t.subjects[key].Execute(&subject, data); err != nil {
+		return Message{}, err
+	}
+
+	if err := t.texts[key].Execute(&text, data); err != nil {
+		return Message{}, err
+	}
+
+	if err := t.htmls[key].Execute(&html, data); err != nil {
+		return Message{}, err
+	}
+
+	return Message{To: to, Subject: strings.TrimSpace(subject.String()), Text: text.String(), HTML: html.String()}, nil
+}
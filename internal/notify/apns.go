@@ -0,0 +1,79 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// APNsPayload builds the JSON body of an Apple push notification. Data is added alongside the aps dictionary.
+func APNsPayload(n Notification) ([]byte, error) {
+	payload := map[string]any{
+		"aps": map[string]any{
+			"alert":     map[string]string{"title": n.Title, "body": n.Body},
+			"sound":     "default",
+			"thread-id": n.CollapseKey,
+		},
+	}
+
+	for key, value := range n.Data {
+		payload[key] = value
+	}
+
+	return json.Marshal(payload)
+}
+
+// APNsProvider sends notifications through the Apple Push Notification service HTTP API. AuthToken is the
+// provider token, which must be refreshed outside the app as Apple requires.
+type APNsProvider struct {
+	Endpoint  string
+	Topic     string
+	AuthToken string
+	Client    *http.Client
+}
+
+func NewAPNsProvider(endpoint string, topic string, authToken string) *APNsProvider {
+	return &APNsProvider{Endpoint: endpoint, Topic: topic, AuthToken: authToken, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (a *APNsProvider) Name() string {
+	return "apns"
+}
+
+func (a *APNsProvider) Send(ctx context.Context, device Device, n Notification) error {
+	body, err := APNsPayload(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.Endpoint+"/3/device/"+device.Token, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "bearer "+a.AuthToken)
+	req.Header.Set("apns-topic", a.Topic)
+	req.Header.Set("apns-push-type", "alert")
+	if n.CollapseKey != "" {
+		req.Header.Set("apns-collapse-id", n.CollapseKey)
+	}
+
+	res, err := a.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusGone:
+		return ErrDeviceUnregistered
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("apns responded with %d", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,132 @@
+package notify
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultBatchSize    = 50
+	defaultPollInterval = 5 * time.Second
+	defaultLease        = 2 * time.Minute
+)
+
+// Pending is a queued notification along with what is needed to send it.
+type Pending struct {
+	Id          int64
+	UserId      int32
+	Kind        string
+	Count       int
+	Devices     []Device
+	Preferences Preferences
+}
+
+// Store holds queued notifications.
+type Store interface {
+	// ClaimNotifications returns up to limit notifications due to be sent, hiding them from other claims for the lease.
+	ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]Pending, error)
+	// RecordNotification closes the notification with an outcome, or postpones it when sendAfter is set.
+	RecordNotification(ctx context.Context, id int64, outcome string, sendAfter *time.Time) error
+	RemoveDevice(ctx context.Context, token string) error
+}
+
+// Dispatcher sends queued notifications to each of the user's devices through the provider for its platform.
+type Dispatcher struct {
+	Store        Store
+	Providers    map[string]Provider
+	BatchSize    int
+	PollInterval time.Duration
+	Lease        time.Duration
+	now          func() time.Time
+}
+
+func NewDispatcher(store Store, providers map[string]Provider) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		Providers:    providers,
+		BatchSize:    defaultBatchSize,
+		PollInterval: defaultPollInterval,
+		Lease:        defaultLease,
+		now:          time.Now,
+	}
+}
+
+// Run sends notifications until the context is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		sent, err := d.DispatchOnce(ctx)
+		if err != nil {
+			slog.Error("Could not dispatch notifications", "error", err)
+		}
+
+		if sent == d.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.PollInterval):
+		}
+	}
+}
+
+// DispatchOnce handles a single batch of notifications, returning how many were claimed.
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	pending, err := d.Store.ClaimNotifications(ctx, d.BatchSize, d.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, notification := range pending {
+		outcome, sendAfter := d.dispatch(ctx, notification)
+		if err := d.Store.RecordNotification(ctx, notification.Id, outcome, sendAfter); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(pending), nil
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, pending Pending) (string, *time.Time) {
+	if !pending.Preferences.Allows(pending.Kind) {
+		return OutcomeDisabled, nil
+	}
+
+	if until, quiet := pending.Preferences.QuietUntil(d.now()); quiet {
+		return "", &until
+	}
+
+	if len(pending.Devices) == 0 {
+		return OutcomeNoDevices, nil
+	}
+
+	notification := Render(pending.Kind, pending.Count)
+	outcome := OutcomeFailed
+	for _, device := range pending.Devices {
+		provider, ok := d.Providers[device.Platform]
+		if !ok {
+			slog.Info("No notification provider for platform", "platform", device.Platform)
+			continue
+		}
+
+		err := provider.Send(ctx, device, notification)
+		if err == ErrDeviceUnregistered {
+			slog.Info("Removing unregistered device", "user", pending.UserId, "provider", provider.Name())
+			if err := d.Store.RemoveDevice(ctx, device.Token); err != nil {
+				slog.Error("Could not remove device", "error", err)
+			}
+			continue
+		}
+
+		if err != nil {
+			slog.Info("Could not send notification", "user", pending.UserId, "provider", provider.Name(), "error", err)
+			continue
+		}
+
+		outcome = OutcomeSent
+	}
+
+	return outcome, nil
+}
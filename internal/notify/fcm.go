@@ -0,0 +1,71 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// FCMPayload builds the JSON body of a Firebase Cloud Messaging v1 send request for a device.
+func FCMPayload(token string, n Notification) ([]byte, error) {
+	message := map[string]any{
+		"token":        token,
+		"notification": map[string]string{"title": n.Title, "body": n.Body},
+		"data":         n.Data,
+	}
+
+	if n.CollapseKey != "" {
+		message["android"] = map[string]string{"collapse_key": n.CollapseKey}
+	}
+
+	return json.Marshal(map[string]any{"message": message})
+}
+
+// FCMProvider sends notifications through the Firebase Cloud Messaging HTTP v1 API. Endpoint is the project's
+// messages:send URL and AuthToken an OAuth access token, which must be refreshed outside the app.
+type FCMProvider struct {
+	Endpoint  string
+	AuthToken string
+	Client    *http.Client
+}
+
+func NewFCMProvider(endpoint string, authToken string) *FCMProvider {
+	return &FCMProvider{Endpoint: endpoint, AuthToken: authToken, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (f *FCMProvider) Name() string {
+	return "fcm"
+}
+
+func (f *FCMProvider) Send(ctx context.Context, device Device, n Notification) error {
+	body, err := FCMPayload(device.Token, n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, f.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+f.AuthToken)
+
+	res, err := f.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound:
+		return ErrDeviceUnregistered
+	case res.StatusCode != http.StatusOK:
+		return fmt.Errorf("fcm responded with %d", res.StatusCode)
+	}
+
+	return nil
+}
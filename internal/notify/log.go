@@ -0,0 +1,53 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogProvider records notifications instead of sending them, for development. When Path is set each notification
+// is appended to that file as a JSON line, otherwise it is written to the log.
+type LogProvider struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (l *LogProvider) Name() string {
+	return "log"
+}
+
+func (l *LogProvider) Send(ctx context.Context, device Device, n Notification) error {
+	if l.Path == "" {
+		slog.Info("Notification", "platform", device.Platform, "token", device.Token, "title", n.Title, "body", n.Body)
+		return nil
+	}
+
+	line, err := json.Marshal(map[string]any{
+		"sentAt":   time.Now().UTC(),
+		"platform": device.Platform,
+		"token":    device.Token,
+		"title":    n.Title,
+		"body":     n.Body,
+		"collapse": n.CollapseKey,
+		"data":     n.Data,
+	})
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
@@ -0,0 +1,130 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Platforms a device can be registered for.
+const (
+	PlatformIOS     = "ios"
+	PlatformAndroid = "android"
+)
+
+// Kinds of notification.
+const (
+	KindMatch = "match"
+	// KindLikes collapses every like received within the digest window into one notification
+	KindLikes = "likes"
+)
+
+// Outcomes recorded once a notification is no longer pending.
+const (
+	OutcomeSent      = "sent"
+	OutcomeDisabled  = "disabled"
+	OutcomeNoDevices = "no_devices"
+	OutcomeFailed    = "failed"
+)
+
+// ErrDeviceUnregistered is returned by providers when a token is no longer valid, so the device is removed.
+var ErrDeviceUnregistered = errors.New("device token is no longer registered")
+
+// Device is a device registered to receive notifications for a user.
+type Device struct {
+	Token    string `json:"token"`
+	Platform string `json:"platform"`
+}
+
+// Notification is a message to show on a user's devices.
+type Notification struct {
+	Title string
+	Body  string
+	// CollapseKey lets a newer notification replace an older one of the same key on the device
+	CollapseKey string
+	Data        map[string]string
+}
+
+// Provider sends notifications to devices on one platform.
+type Provider interface {
+	Name() string
+	Send(context.Context, Device, Notification) error
+}
+
+// Render builds the notification for a kind, where count is how many matches or likes it covers.
+func Render(kind string, count int) Notification {
+	switch kind {
+	case KindMatch:
+		return Notification{
+			Title:       "It's a match!",
+			Body:        "You have a new match, say hello.",
+			CollapseKey: KindMatch,
+			Data:        map[string]string{"kind": KindMatch},
+		}
+	default:
+		body := "Someone liked you."
+		if count > 1 {
+			body = fmt.Sprintf("%d people liked you.", count)
+		}
+
+		return Notification{
+			Title:       "New likes",
+			Body:        body,
+			CollapseKey: KindLikes,
+			Data:        map[string]string{"kind": KindLikes, "count": fmt.Sprint(count)},
+		}
+	}
+}
+
+// Preferences are a user's choices about which notifications they receive and when.
+type Preferences struct {
+	Matches bool
+	Likes   bool
+	// QuietStart and QuietEnd are minutes after midnight in Timezone. Quiet hours may run past midnight.
+	QuietStart *int
+	QuietEnd   *int
+	Timezone   string
+}
+
+// DefaultPreferences apply to users who have not chosen their own.
+func DefaultPreferences() Preferences {
+	return Preferences{Matches: true, Likes: true, Timezone: "UTC"}
+}
+
+// Allows reports whether the user wants notifications of the kind.
+func (p Preferences) Allows(kind string) bool {
+	if kind == KindMatch {
+		return p.Matches
+	}
+
+	return p.Likes
+}
+
+// QuietUntil returns when the user's quiet hours end, if they are in quiet hours at the given time.
+func (p Preferences) QuietUntil(now time.Time) (time.Time, bool) {
+	if p.QuietStart == nil || p.QuietEnd == nil || *p.QuietStart == *p.QuietEnd {
+		return time.Time{}, false
+	}
+
+	location, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+	start, end := *p.QuietStart, *p.QuietEnd
+	endToday := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, location)
+
+	switch {
+	case start < end && minute >= start && minute < end:
+		return endToday, true
+	case start > end && minute >= start:
+		return endToday.AddDate(0, 0, 1), true
+	case start > end && minute < end:
+		return endToday, true
+	}
+
+	return time.Time{}, false
+}
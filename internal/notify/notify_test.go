@@ -0,0 +1,237 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	pending   []Pending
+	outcomes  map[int64]string
+	postponed map[int64]time.Time
+	removed   []string
+}
+
+func (f *fakeStore) ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]Pending, error) {
+	claimed := f.pending
+	f.pending = nil
+	return claimed, nil
+}
+
+func (f *fakeStore) RecordNotification(ctx context.Context, id int64, outcome string, sendAfter *time.Time) error {
+	if sendAfter != nil {
+		f.postponed[id] = *sendAfter
+		return nil
+	}
+
+	f.outcomes[id] = outcome
+	return nil
+}
+
+func (f *fakeStore) RemoveDevice(ctx context.Context, token string) error {
+	f.removed = append(f.removed, token)
+	return nil
+}
+
+type fakeProvider struct {
+	sent         []Notification
+	unregistered map[string]bool
+}
+
+func (f *fakeProvider) Name() string {
+	return "fake"
+}
+
+func (f *fakeProvider) Send(ctx context.Context, device Device, n Notification) error {
+	if f.unregistered[device.Token] {
+		return ErrDeviceUnregistered
+	}
+
+	f.sent = append(f.sent, n)
+	return nil
+}
+
+func newFakeStore(pending ...Pending) *fakeStore {
+	return &fakeStore{pending: pending, outcomes: map[int64]string{}, postponed: map[int64]time.Time{}}
+}
+
+func quietHours(start int, end int, timezone string) Preferences {
+	preferences := DefaultPreferences()
+	preferences.QuietStart, preferences.QuietEnd, preferences.Timezone = &start, &end, timezone
+	return preferences
+}
+
+func Test_QuietUntilHandlesHoursPastMidnight(t *testing.T) {
+	preferences := quietHours(22*60, 7*60, "UTC")
+
+	tests := []struct {
+		now   time.Time
+		quiet bool
+		until time.Time
+	}{
+		{time.Date(2024, 6, 1, 23, 30, 0, 0, time.UTC), true, time.Date(2024, 6, 2, 7, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 6, 2, 3, 0, 0, 0, time.UTC), true, time.Date(2024, 6, 2, 7, 0, 0, 0, time.UTC)},
+		{time.Date(2024, 6, 2, 7, 0, 0, 0, time.UTC), false, time.Time{}},
+		{time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC), false, time.Time{}},
+	}
+
+	for _, test := range tests {
+		until, quiet := preferences.QuietUntil(test.now)
+		if quiet != test.quiet || !until.Equal(test.until) {
+			t.Errorf("At %v expected quiet %v until %v but got %v until %v", test.now, test.quiet, test.until, quiet, until)
+		}
+	}
+}
+
+func Test_QuietUntilUsesUsersTimezone(t *testing.T) {
+	// 13:00 to 14:00 in New York is 17:00 to 18:00 UTC in summer
+	preferences := quietHours(13*60, 14*60, "America/New_York")
+
+	until, quiet := preferences.QuietUntil(time.Date(2024, 6, 1, 17, 30, 0, 0, time.UTC))
+	if !quiet || !until.Equal(time.Date(2024, 6, 1, 18, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected quiet until 18:00 UTC but got %v until %v", quiet, until)
+	}
+
+	if _, quiet := preferences.QuietUntil(time.Date(2024, 6, 1, 13, 30, 0, 0, time.UTC)); quiet {
+		t.Error("Expected 13:30 UTC not to be quiet in New York")
+	}
+}
+
+func Test_RenderCollapsesLikesIntoDigest(t *testing.T) {
+	if got := Render(KindLikes, 1).Body; got != "Someone liked you." {
+		t.Errorf("Expected single like body but got %q", got)
+	}
+
+	digest := Render(KindLikes, 3)
+	if digest.Body != "3 people liked you." || digest.CollapseKey != KindLikes || digest.Data["count"] != "3" {
+		t.Errorf("Expected a digest of 3 likes but got %+v", digest)
+	}
+}
+
+func Test_PayloadsFollowProviderFormats(t *testing.T) {
+	notification := Render(KindMatch, 1)
+
+	body, err := APNsPayload(notification)
+	if err != nil {
+		t.Fatal("Unexpected error building APNs payload", err)
+	}
+
+	var apns struct {
+		Aps struct {
+			Alert struct {
+				Title string `json:"title"`
+			} `json:"alert"`
+			ThreadId string `json:"thread-id"`
+		} `json:"aps"`
+		Kind string `json:"kind"`
+	}
+
+	if err := json.Unmarshal(body, &apns); err != nil || apns.Aps.Alert.Title != notification.Title || apns.Aps.ThreadId != KindMatch || apns.Kind != KindMatch {
+		t.Errorf("Unexpected APNs payload %s", body)
+	}
+
+	body, err = FCMPayload("token-1", notification)
+	if err != nil {
+		t.Fatal("Unexpected error building FCM payload", err)
+	}
+
+	var fcm struct {
+		Message struct {
+			Token        string            `json:"token"`
+			Notification map[string]string `json:"notification"`
+			Data         map[string]string `json:"data"`
+			Android      map[string]string `json:"android"`
+		} `json:"message"`
+	}
+
+	if err := json.Unmarshal(body, &fcm); err != nil || fcm.Message.Token != "token-1" || fcm.Message.Notification["body"] != notification.Body ||
+		fcm.Message.Data["kind"] != KindMatch || fcm.Message.Android["collapse_key"] != KindMatch {
+		t.Errorf("Unexpected FCM payload %s", body)
+	}
+}
+
+func Test_APNsProviderReportsUnregisteredDevices(t *testing.T) {
+	apns := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("apns-topic") != "com.muzz.app" || r.Header.Get("apns-collapse-id") != KindLikes {
+			t.Errorf("Unexpected headers %v", r.Header)
+		}
+
+		if r.URL.Path == "/3/device/gone" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+	}))
+	defer apns.Close()
+
+	provider := NewAPNsProvider(apns.URL, "com.muzz.app", "token")
+	if err := provider.Send(context.Background(), Device{Token: "live"}, Render(KindLikes, 2)); err != nil {
+		t.Error("Expected send to succeed but got", err)
+	}
+
+	if err := provider.Send(context.Background(), Device{Token: "gone"}, Render(KindLikes, 2)); err != ErrDeviceUnregistered {
+		t.Error("Expected unregistered device error but got", err)
+	}
+}
+
+func Test_DispatcherSendsToDevicesAndRemovesUnregistered(t *testing.T) {
+	provider := &fakeProvider{unregistered: map[string]bool{"old": true}}
+	store := newFakeStore(Pending{
+		Id:          1,
+		UserId:      4,
+		Kind:        KindLikes,
+		Count:       2,
+		Devices:     []Device{{Token: "old", Platform: PlatformIOS}, {Token: "new", Platform: PlatformIOS}},
+		Preferences: DefaultPreferences(),
+	})
+
+	if _, err := NewDispatcher(store, map[string]Provider{PlatformIOS: provider}).DispatchOnce(context.Background()); err != nil {
+		t.Fatal("Unexpected error dispatching", err)
+	}
+
+	if store.outcomes[1] != OutcomeSent || len(provider.sent) != 1 || provider.sent[0].Body != "2 people liked you." {
+		t.Errorf("Expected the digest to be sent once but got %q and %+v", store.outcomes[1], provider.sent)
+	}
+
+	if len(store.removed) != 1 || store.removed[0] != "old" {
+		t.Errorf("Expected the unregistered device to be removed but got %v", store.removed)
+	}
+}
+
+func Test_DispatcherHonoursPreferences(t *testing.T) {
+	disabled := DefaultPreferences()
+	disabled.Likes = false
+
+	provider := &fakeProvider{}
+	devices := []Device{{Token: "a", Platform: PlatformAndroid}}
+	store := newFakeStore(
+		Pending{Id: 1, Kind: KindLikes, Count: 1, Devices: devices, Preferences: disabled},
+		Pending{Id: 2, Kind: KindMatch, Count: 1, Devices: devices, Preferences: quietHours(22*60, 7*60, "UTC")},
+		Pending{Id: 3, Kind: KindMatch, Count: 1, Preferences: DefaultPreferences()},
+	)
+
+	dispatcher := NewDispatcher(store, map[string]Provider{PlatformAndroid: provider})
+	dispatcher.now = func() time.Time { return time.Date(2024, 6, 1, 23, 0, 0, 0, time.UTC) }
+	if _, err := dispatcher.DispatchOnce(context.Background()); err != nil {
+		t.Fatal("Unexpected error dispatching", err)
+	}
+
+	if store.outcomes[1] != OutcomeDisabled {
+		t.Errorf("Expected disabled likes to be dropped but got %q", store.outcomes[1])
+	}
+
+	if until, ok := store.postponed[2]; !ok || !until.Equal(time.Date(2024, 6, 2, 7, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the match to wait for quiet hours to end but got %v", until)
+	}
+
+	if store.outcomes[3] != OutcomeNoDevices {
+		t.Errorf("Expected no devices outcome but got %q", store.outcomes[3])
+	}
+
+	if len(provider.sent) != 0 {
+		t.Errorf("Expected nothing to be sent but got %+v", provider.sent)
+	}
+}
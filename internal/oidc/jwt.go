@@ -0,0 +1,211 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is how far the provider's clock is allowed to be from ours when checking token times
+const clockSkew = time.Minute
+
+// keyRefreshInterval limits how often keys are fetched for tokens signed with an unknown key id
+const keyRefreshInterval = time.Minute
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyId     string `json:"kid"`
+}
+
+type claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified flexBool `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience is a single string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	*a = list
+	return nil
+}
+
+// flexBool is a boolean some providers, such as Apple, send as a string
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	case "false", "null":
+		*b = false
+	default:
+		return fmt.Errorf("invalid boolean %s", data)
+	}
+
+	return nil
+}
+
+// VerifyIDToken checks the token is signed with RS256 by one of the provider's keys, was issued by the provider for
+// this client with the nonce, and has not expired.
+func (p *Provider) VerifyIDToken(ctx context.Context, raw string, nonce string) (*Identity, error) {
+	if _, err := p.Discover(ctx); err != nil {
+		return nil, err
+	}
+
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrInvalidToken)
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	// only RS256 is accepted, so a token cannot choose a weaker algorithm or none
+	if h.Algorithm != "RS256" {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidToken, h.Algorithm)
+	}
+
+	key, err := p.keys.get(ctx, h.KeyId)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+
+	now := p.timeNow()
+	switch {
+	case strings.TrimSuffix(c.Issuer, "/") != p.Issuer:
+		return nil, fmt.Errorf("%w: issued by %q", ErrInvalidToken, c.Issuer)
+	case !slices.Contains(c.Audience, p.ClientID):
+		return nil, fmt.Errorf("%w: not issued for this client", ErrInvalidToken)
+	case now.Add(-clockSkew).Unix() > c.Expiry:
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	case now.Add(clockSkew).Unix() < c.IssuedAt:
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidToken)
+	case c.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidToken)
+	case c.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidToken)
+	}
+
+	return &Identity{Subject: c.Subject, Email: c.Email, EmailVerified: bool(c.EmailVerified), Name: c.Name}, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}
+
+// keySet caches the provider's signing keys, fetching them again when a token uses a key id it does not know,
+// as happens when the provider rotates its keys.
+type keySet struct {
+	uri       string
+	provider  *Provider
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyId   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+}
+
+func (ks *keySet) get(ctx context.Context, keyId string) (*rsa.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if key, ok := ks.keys[keyId]; ok {
+		return key, nil
+	}
+
+	if ks.provider.timeNow().Sub(ks.fetchedAt) < keyRefreshInterval {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, keyId)
+	}
+
+	var document struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := ks.provider.getJSON(ctx, ks.uri, &document); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range document.Keys {
+		if k.KeyType != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+
+		keys[k.KeyId] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+
+	ks.keys = keys
+	ks.fetchedAt = ks.provider.timeNow()
+
+	if key, ok := ks.keys[keyId]; ok {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidToken, keyId)
+}
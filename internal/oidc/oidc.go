@@ -0,0 +1,218 @@
+// Package oidc implements the relying party side of OpenID Connect sign in, using the authorization code flow with
+// PKCE and validating ID tokens against the provider's published keys.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrExchangeFailed = errors.New("could not exchange authorization code")
+	ErrInvalidToken   = errors.New("id token is invalid")
+)
+
+// Identity is the user an ID token was issued for.
+type Identity struct {
+	// Subject identifies the user at the provider, and unlike the email never changes
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// Metadata is the part of the provider's discovery document the flow needs.
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider is an OpenID Connect provider the service is registered with as a client. Its discovery document and keys
+// are fetched when first needed, so a provider being unreachable does not stop the service starting.
+type Provider struct {
+	Name         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback registered with the provider
+	RedirectURL string
+	Scopes      []string
+	HTTPClient  *http.Client
+
+	now      func() time.Time
+	mu       sync.Mutex
+	metadata *Metadata
+	keys     *keySet
+}
+
+// NewProvider returns a provider requesting the openid, email and profile scopes.
+func NewProvider(name string, issuer string, clientID string, clientSecret string, redirectURL string) *Provider {
+	return &Provider{
+		Name:         name,
+		Issuer:       strings.TrimSuffix(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		HTTPClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GenerateToken returns a random URL safe value, used for the state, nonce and PKCE verifier.
+func GenerateToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// CodeChallenge returns the S256 PKCE challenge for a verifier.
+func CodeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// Discover returns the provider's metadata, fetching it the first time.
+func (p *Provider) Discover(ctx context.Context) (*Metadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.metadata != nil {
+		return p.metadata, nil
+	}
+
+	metadata := &Metadata{}
+	if err := p.getJSON(ctx, p.Issuer+"/.well-known/openid-configuration", metadata); err != nil {
+		return nil, err
+	}
+
+	if strings.TrimSuffix(metadata.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q", metadata.Issuer)
+	}
+
+	p.metadata = metadata
+	p.keys = &keySet{uri: metadata.JWKSURI, provider: p}
+	return metadata, nil
+}
+
+// AuthCodeURL returns the provider's page the user is sent to to sign in.
+func (p *Provider) AuthCodeURL(ctx context.Context, state string, nonce string, verifier string) (string, error) {
+	metadata, err := p.Discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {CodeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange swaps the authorization code from the callback for an ID token, and returns the identity it was issued for.
+// The nonce and verifier must be the ones the sign in was started with.
+func (p *Provider) Exchange(ctx context.Context, code string, verifier string, nonce string) (*Identity, error) {
+	metadata, err := p.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	res, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: token endpoint returned %d: %s", ErrExchangeFailed, res.StatusCode, body)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+
+	if err := json.Unmarshal(body, &tokens); err != nil || tokens.IDToken == "" {
+		return nil, fmt.Errorf("%w: no id token in response", ErrExchangeFailed)
+	}
+
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+func (p *Provider) timeNow() time.Time {
+	if p.now == nil {
+		return time.Now()
+	}
+
+	return p.now()
+}
+
+func (p *Provider) getJSON(ctx context.Context, uri string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", uri, res.StatusCode)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
@@ -0,0 +1,123 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/chammond14/muzz/internal/oidc/oidctest"
+)
+
+func newTestProvider(t *testing.T) (*oidctest.Server, *Provider) {
+	server, err := oidctest.NewServer()
+	if err != nil {
+		t.Fatal("Unexpected error starting provider", err)
+	}
+	t.Cleanup(server.Close)
+
+	return server, NewProvider("test", server.URL, "muzz", "secret", "https://muzz.com/auth/test/callback")
+}
+
+// signIn follows the authorization URL to the provider, returning the code and state it redirects back with.
+func signIn(t *testing.T, authURL string) (string, string) {
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal("Unexpected error signing in", err)
+	}
+	res.Body.Close()
+
+	callback, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("Expected redirect to callback but got %d %s", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	return callback.Query().Get("code"), callback.Query().Get("state")
+}
+
+func Test_authorizationCodeFlowReturnsIdentity(t *testing.T) {
+	server, provider := newTestProvider(t)
+	server.SetUser(oidctest.User{Subject: "abc", Email: "bob@muzz.com", EmailVerified: true, Name: "Bob"})
+	ctx := context.Background()
+
+	verifier, _ := GenerateToken()
+	authURL, err := provider.AuthCodeURL(ctx, "the-state", "the-nonce", verifier)
+	if err != nil {
+		t.Fatal("Unexpected error building authorization url", err)
+	}
+
+	code, state := signIn(t, authURL)
+	if state != "the-state" {
+		t.Errorf("Expected state to be returned but got %q", state)
+	}
+
+	identity, err := provider.Exchange(ctx, code, verifier, "the-nonce")
+	if err != nil {
+		t.Fatal("Unexpected error exchanging code", err)
+	}
+
+	if identity.Subject != "abc" || identity.Email != "bob@muzz.com" || !identity.EmailVerified || identity.Name != "Bob" {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+}
+
+func Test_exchangeRejectsWrongVerifier(t *testing.T) {
+	_, provider := newTestProvider(t)
+	ctx := context.Background()
+
+	authURL, err := provider.AuthCodeURL(ctx, "state", "nonce", "the-verifier")
+	if err != nil {
+		t.Fatal("Unexpected error building authorization url", err)
+	}
+
+	code, _ := signIn(t, authURL)
+	if _, err := provider.Exchange(ctx, code, "another-verifier", "nonce"); !errors.Is(err, ErrExchangeFailed) {
+		t.Errorf("Expected exchange to fail but got %v", err)
+	}
+}
+
+func Test_verifyIDTokenRejectsInvalidTokens(t *testing.T) {
+	server, provider := newTestProvider(t)
+	ctx := context.Background()
+	now := time.Now()
+	user := oidctest.User{Subject: "abc"}
+
+	valid := func() map[string]any {
+		return map[string]any{"iss": server.URL, "sub": "abc", "aud": []string{"muzz"}, "exp": now.Add(time.Hour).Unix(), "iat": now.Unix(), "nonce": "nonce"}
+	}
+
+	if _, err := provider.VerifyIDToken(ctx, server.Sign(valid()), "nonce"); err != nil {
+		t.Fatal("Expected token to be valid but got", err)
+	}
+
+	signed := server.IDToken("muzz", "nonce", user)
+	tests := map[string]string{
+		"wrong nonce":    server.IDToken("muzz", "other", user),
+		"wrong audience": server.IDToken("other-client", "nonce", user),
+		"bad signature":  signed[:len(signed)-10] + "AAAAAAAAAA",
+		"alg none":       "eyJhbGciOiJub25lIn0.eyJzdWIiOiJhYmMifQ.",
+	}
+
+	expired := valid()
+	expired["exp"] = now.Add(-time.Hour).Unix()
+	tests["expired"] = server.Sign(expired)
+
+	issuer := valid()
+	issuer["iss"] = "https://evil.example.com"
+	tests["wrong issuer"] = server.Sign(issuer)
+
+	for name, token := range tests {
+		if _, err := provider.VerifyIDToken(ctx, token, "nonce"); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("Expected %s token to be invalid but got %v", name, err)
+		}
+	}
+}
+
+func Test_codeChallengeMatchesRFC7636Example(t *testing.T) {
+	if challenge := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"); challenge != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Unexpected challenge %s", challenge)
+	}
+}
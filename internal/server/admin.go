@@ -0,0 +1,159 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/chammond14/muzz/internal/db"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+type SearchUsersResponse struct {
+	Results []*db.AccountDetails `json:"results"`
+}
+
+type AssignRoleRequest struct {
+	Role string `json:"role" validate:"required,oneof=user moderator admin"`
+}
+
+func (s *Server) searchUsersHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "searchUsersHandler")
+
+	query := r.URL.Query()
+	role := query.Get("role")
+	if role != "" && role != db.RoleUser && role != db.RoleModerator && role != db.RoleAdmin {
+		slog.Info("Unknown role", "Handler", "searchUsersHandler", "role", role)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if query.Has("limit") {
+		var err error
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 || limit > maxSearchLimit {
+			slog.Info("Invalid limit", "Handler", "searchUsersHandler", "limit", query.Get("limit"))
+			writeErrorResponse(w, ErrValidationError)
+			return
+		}
+	}
+
+	accounts, err := s.Store.SearchProfiles(r.Context(), query.Get("q"), role, limit)
+	if err != nil {
+		slog.Info("Could not search profiles", "Handler", "searchUsersHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	s.recordAudit(r, AuditUsersSearched, nil, map[string]string{"q": query.Get("q"), "role": role})
+
+	slog.Info("Request Complete", "Handler", "searchUsersHandler")
+	writeJsonResponse(w, http.StatusOK, SearchUsersResponse{Results: accounts})
+}
+
+func (s *Server) getUserHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "getUserHandler")
+
+	targetId, err := parseUserIdPathValue(r)
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+
+	account, err := s.Store.GetAccountDetails(r.Context(), targetId)
+	if err != nil {
+		slog.Info("Could not load profile", "Handler", "getUserHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditProfileViewed, &targetId, nil)
+
+	slog.Info("Request Complete", "Handler", "getUserHandler")
+	writeJsonResponse(w, http.StatusOK, account)
+}
+
+func (s *Server) forceLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "forceLogoutHandler")
+
+	targetId, err := parseUserIdPathValue(r)
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+
+	if _, err := s.Store.GetAccountDetails(r.Context(), targetId); err != nil {
+		slog.Info("Could not load profile", "Handler", "forceLogoutHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	if err := s.Store.RevokeSessions(r.Context(), targetId); err != nil {
+		slog.Info("Could not revoke sessions", "Handler", "forceLogoutHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	s.recordAudit(r, AuditSessionsRevoked, &targetId, nil)
+
+	slog.Info("Request Complete", "Handler", "forceLogoutHandler")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) assignRoleHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "assignRoleHandler")
+
+	targetId, err := parseUserIdPathValue(r)
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+
+	assignRequest, err := createRequestBodyFromRequest(r, &AssignRoleRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "assignRoleHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("assignRoleHandler", assignRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "assignRoleHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	// admins changing their own role could leave nobody able to assign roles
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	if userId == targetId {
+		slog.Info("Admin attempted to change own role", "Handler", "assignRoleHandler", "user", userId)
+		writeErrorResponse(w, ErrForbidden)
+		return
+	}
+
+	account, err := s.Store.SetRole(r.Context(), targetId, assignRequest.Role)
+	if err != nil {
+		slog.Info("Could not assign role", "Handler", "assignRoleHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditRoleAssigned, &targetId, map[string]string{"role": assignRequest.Role})
+
+	slog.Info("Request Complete", "Handler", "assignRoleHandler")
+	writeJsonResponse(w, http.StatusOK, account)
+}
+
+func parseUserIdPathValue(r *http.Request) (int32, error) {
+	userId, err := strconv.ParseInt(r.PathValue("id"), 10, 32)
+	if err != nil {
+		return 0, ErrInvalidRequest
+	}
+
+	return int32(userId), nil
+}
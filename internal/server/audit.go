@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chammond14/muzz/internal/db"
+)
+
+const (
+	AuditLoginSucceeded         = "login.succeeded"
+	AuditLoginFailed            = "login.failed"
+	AuditReportCreated          = "report.created"
+	AuditReportClaimed          = "report.claimed"
+	AuditReportResolved         = "report.resolved"
+	AuditUsersSearched          = "admin.users.searched"
+	AuditProfileViewed          = "admin.profile.viewed"
+	AuditSessionsRevoked        = "admin.sessions.revoked"
+	AuditRoleAssigned           = "admin.role.assigned"
+	AuditPhotoMatchResolved     = "admin.photo_match.resolved"
+	AuditDeletionRequested      = "account.deletion_requested"
+	AuditAccountPurged          = "account.purged"
+	AuditExportRequested        = "account.export_requested"
+	AuditWebhookCreated         = "admin.webhook.created"
+	AuditWebhookDeleted         = "admin.webhook.deleted"
+	AuditWebhookDeliveryRetried = "admin.webhook_delivery.retried"
+	AuditPasswordResetRequested = "password.reset_requested"
+	AuditPasswordReset          = "password.reset"
+	AuditEmailVerified          = "account.email_verified"
+	AuditTwoFactorEnabled       = "account.2fa_enabled"
+	AuditTwoFactorDisabled      = "account.2fa_disabled"
+	AuditIdentityLinked         = "account.identity_linked"
+	AuditIdentityUnlinked       = "account.identity_unlinked"
+	AuditPhoneChanged           = "account.phone_changed"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+)
+
+type AuditLogResponse struct {
+	Results []*db.AuditEntry `json:"results"`
+}
+
+// recordAudit writes an entry to the audit trail on behalf of the logged in user, if there is one.
+func (s *Server) recordAudit(r *http.Request, action string, targetId *int32, details map[string]string) {
+	var actorId *int32
+	if userId, ok := r.Context().Value(contextKeyUserId).(int32); ok {
+		actorId = &userId
+	}
+
+	s.recordAuditAs(r, actorId, action, targetId, details)
+}
+
+// recordAuditAs writes an entry to the audit trail for an explicit actor, for requests made before a session exists.
+// Failing to record is logged rather than failing the request.
+func (s *Server) recordAuditAs(r *http.Request, actorId *int32, action string, targetId *int32, details map[string]string) {
+	entry := db.AuditEntry{
+		ActorId:   actorId,
+		TargetId:  targetId,
+		Action:    action,
+		Details:   details,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+
+	if err := s.Store.RecordAudit(r.Context(), entry); err != nil {
+		slog.Error("Could not record audit entry", "action", action, "error", err)
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+func (s *Server) auditLogHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "auditLogHandler")
+
+	filter, err := parseAuditFilter(r)
+	if err != nil {
+		slog.Info("Invalid audit log filter", "Handler", "auditLogHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	entries, err := s.Store.GetAuditLog(r.Context(), filter)
+	if err != nil {
+		slog.Info("Could not load audit log", "Handler", "auditLogHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "auditLogHandler")
+	writeJsonResponse(w, http.StatusOK, AuditLogResponse{Results: entries})
+}
+
+// parseAuditFilter reads the actor, target, action, since, until and limit query parameters.
+// since and until are RFC 3339 timestamps.
+func parseAuditFilter(r *http.Request) (db.AuditFilter, error) {
+	query := r.URL.Query()
+	filter := db.AuditFilter{Action: query.Get("action"), Limit: defaultAuditLimit}
+
+	for param, target := range map[string]**int32{"actor": &filter.ActorId, "target": &filter.TargetId} {
+		if !query.Has(param) {
+			continue
+		}
+
+		id, err := strconv.ParseInt(query.Get(param), 10, 32)
+		if err != nil {
+			return filter, err
+		}
+
+		userId := int32(id)
+		*target = &userId
+	}
+
+	for param, target := range map[string]**time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if !query.Has(param) {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, query.Get(param))
+		if err != nil {
+			return filter, err
+		}
+
+		t = t.UTC()
+		*target = &t
+	}
+
+	if query.Has("limit") {
+		limit, err := strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 || limit > maxAuditLimit {
+			return filter, ErrValidationError
+		}
+
+		filter.Limit = limit
+	}
+
+	return filter, nil
+}
+
+// pruneAuditLog removes audit entries older than the retention period. A zero retention keeps entries forever.
+func (s *Server) pruneAuditLog(ctx context.Context) error {
+	if s.AuditRetention <= 0 {
+		return nil
+	}
+
+	_, err := s.Store.PruneAuditLog(ctx, time.Now().Add(-s.AuditRetention))
+	return err
+}
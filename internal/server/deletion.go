@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chammond14/muzz/internal/db"
+)
+
+const (
+	defaultDeletionGracePeriod = 30 * 24 * time.Hour
+)
+
+// DeleteAccountRequest confirms the deletion with the password, or for profiles with a phone number a code sent to it
+type DeleteAccountRequest struct {
+	Password string `json:"password" validate:"required_without=Code"`
+	Code     string `json:"code" validate:"omitempty,len=6,numeric"`
+}
+
+type DeleteAccountResponse struct {
+	DeletionScheduledFor time.Time `json:"deletionScheduledFor"`
+}
+
+func (s *Server) deletionGracePeriod() time.Duration {
+	if s.DeletionGracePeriod <= 0 {
+		return defaultDeletionGracePeriod
+	}
+
+	return s.DeletionGracePeriod
+}
+
+func (s *Server) deleteMeHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "deleteMeHandler")
+
+	deleteRequest, err := createRequestBodyFromRequest(r, &DeleteAccountRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "deleteMeHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("deleteMeHandler", deleteRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "deleteMeHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	var scheduledFor time.Time
+	if deleteRequest.Code != "" {
+		var ok bool
+		if scheduledFor, ok = s.requestPhoneDeletion(w, r, userId, deleteRequest.Code); !ok {
+			return
+		}
+	} else {
+		scheduledFor, err = s.Store.RequestDeletion(r.Context(), userId, deleteRequest.Password, s.deletionGracePeriod())
+		if err != nil {
+			slog.Info("Could not delete account", "Handler", "deleteMeHandler", "error", err)
+			writeErrorResponse(w, err)
+			return
+		}
+	}
+
+	s.recordAudit(r, AuditDeletionRequested, &userId, map[string]string{"scheduledFor": scheduledFor.Format(time.RFC3339)})
+
+	slog.Info("Request Complete", "Handler", "deleteMeHandler")
+	writeJsonResponse(w, http.StatusAccepted, DeleteAccountResponse{DeletionScheduledFor: scheduledFor})
+}
+
+// requestPhoneDeletion checks the code sent to the user's phone and schedules the deletion, writing the error response
+// when it can't.
+func (s *Server) requestPhoneDeletion(w http.ResponseWriter, r *http.Request, userId int32, code string) (time.Time, bool) {
+	profile, err := s.Store.GetProfile(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not load profile", "Handler", "deleteMeHandler", "error", err)
+		writeErrorResponse(w, err)
+		return time.Time{}, false
+	}
+
+	if profile.Phone == "" {
+		slog.Info("Profile has no phone to confirm deletion with", "Handler", "deleteMeHandler", "user", userId)
+		writeErrorResponse(w, ErrValidationError)
+		return time.Time{}, false
+	}
+
+	phone, ok := s.verifyPhoneCode(w, r, "deleteMeHandler", &PhoneVerifyRequest{Phone: profile.Phone, Code: code})
+	if !ok {
+		return time.Time{}, false
+	}
+
+	scheduledFor, err := s.Store.RequestPhoneDeletion(r.Context(), userId, phone, s.deletionGracePeriod())
+	if err != nil {
+		slog.Info("Could not delete account", "Handler", "deleteMeHandler", "error", err)
+		writeErrorResponse(w, err)
+		return time.Time{}, false
+	}
+
+	return scheduledFor, true
+}
+
+// purgeDeletedProfiles erases the accounts whose deletion grace period has passed, along with their photos and exports.
+func (s *Server) purgeDeletedProfiles(ctx context.Context) error {
+	purged, err := s.Store.PurgeDeletedProfiles(ctx)
+
+	// profiles purged before any failure are already gone from the db, so their blobs are still cleaned up
+	for _, profile := range purged {
+		for _, prefix := range profile.PhotoPrefixes {
+			s.deletePhotoBlobs(ctx, prefix)
+		}
+
+		for _, key := range profile.ExportKeys {
+			if err := s.Blobs.Delete(ctx, key); err != nil {
+				slog.Error("Could not delete export blob", "key", key, "error", err)
+			}
+		}
+
+		entry := db.AuditEntry{
+			TargetId: &profile.Id,
+			Action:   AuditAccountPurged,
+			Details:  map[string]string{"photos": strconv.Itoa(len(profile.PhotoPrefixes))},
+		}
+
+		if err := s.Store.RecordAudit(ctx, entry); err != nil {
+			slog.Error("Could not record audit entry", "action", AuditAccountPurged, "error", err)
+		}
+	}
+
+	return err
+}
@@ -3,8 +3,18 @@ package server
 import "errors"
 
 var (
-	ErrInvalidRequest  = errors.New("invalid request")
-	ErrMustBeLoggedIn  = errors.New("please log in to your account")
-	ErrValidationError = errors.New("request body contained unexpected values")
-	ErrUnexpectedError = errors.New("unexpected error occurred")
+	ErrInvalidRequest          = errors.New("invalid request")
+	ErrMustBeLoggedIn          = errors.New("please log in to your account")
+	ErrValidationError         = errors.New("request body contained unexpected values")
+	ErrUnexpectedError         = errors.New("unexpected error occurred")
+	ErrForbidden               = errors.New("you do not have permission to do that")
+	ErrPhotoTooLarge           = errors.New("photo is too large")
+	ErrUnsupportedMediaType    = errors.New("photo must be a jpeg, png or webp image")
+	ErrExportLinkExpired       = errors.New("export link has expired")
+	ErrEmailNotVerified        = errors.New("please verify your email to do that")
+	ErrVerificationLinkExpired = errors.New("verification link has expired, request a new one")
+	ErrProviderNotFound        = errors.New("sign in provider not found")
+	ErrSignInFailed            = errors.New("could not sign in with the provider, please try again")
+	ErrIdentityEmailRequired   = errors.New("the provider did not share an email, which is needed to create an account")
+	ErrTooManyRequests         = errors.New("too many requests, please try again later")
 )
@@ -0,0 +1,14 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// outboxRetention is how long published events are kept, to help investigate deliveries
+const outboxRetention = 7 * 24 * time.Hour
+
+func (s *Server) pruneOutbox(ctx context.Context) error {
+	_, err := s.Store.PruneOutbox(ctx, time.Now().Add(-outboxRetention))
+	return err
+}
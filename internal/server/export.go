@@ -0,0 +1,271 @@
+package server
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/chammond14/muzz/internal/db"
+	"github.com/chammond14/muzz/internal/imaging"
+)
+
+const (
+	defaultExportURLTTL = time.Hour
+	// exportLimit is how many exports a user can request a day
+	exportLimit = 5
+	// exportStaleAfter is how long an export can stay pending before the job builds it again, which is long enough
+	// that only builds cut off by a restart are picked up
+	exportStaleAfter = 30 * time.Minute
+)
+
+type ExportResponse struct {
+	*db.DataExport
+	// DownloadUrl is a signed link to the archive, only present once the export is ready
+	DownloadUrl string     `json:"downloadUrl,omitempty"`
+	ExpiresAt   *time.Time `json:"downloadExpiresAt,omitempty"`
+}
+
+func (s *Server) exportURLTTL() time.Duration {
+	if s.ExportURLTTL <= 0 {
+		return defaultExportURLTTL
+	}
+
+	return s.ExportURLTTL
+}
+
+func (s *Server) createExportHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "createExportHandler")
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	if !s.rateLimit(w, r, "export:"+strconv.Itoa(int(userId)), exportLimit, 24*time.Hour) {
+		return
+	}
+
+	export, created, err := s.Store.CreateExport(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not create export", "Handler", "createExportHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	// an export that is already pending is being built, so it is returned as is
+	if created {
+		s.tasks.Add(1)
+		go func() {
+			defer s.tasks.Done()
+			s.buildExport(context.Background(), export)
+		}()
+
+		s.recordAudit(r, AuditExportRequested, &userId, map[string]string{"export": export.Id})
+	}
+
+	slog.Info("Request Complete", "Handler", "createExportHandler")
+	writeJsonResponse(w, http.StatusAccepted, &ExportResponse{DataExport: export})
+}
+
+func (s *Server) getExportHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "getExportHandler")
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	export, err := s.Store.GetExport(r.Context(), r.PathValue("id"))
+	if err == nil && export.UserId != userId {
+		err = db.ErrExportNotFound
+	}
+
+	if err != nil {
+		slog.Info("Could not load export", "Handler", "getExportHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	response := &ExportResponse{DataExport: export}
+	if export.Status == db.ExportStatusReady {
+		expiresAt := time.Now().Add(s.exportURLTTL()).Truncate(time.Second)
+		response.DownloadUrl = s.signExportURL(export.Id, expiresAt)
+		response.ExpiresAt = &expiresAt
+	}
+
+	slog.Info("Request Complete", "Handler", "getExportHandler")
+	writeJsonResponse(w, http.StatusOK, response)
+}
+
+// downloadExportHandler serves a ready export to anyone holding a valid signed link, so it is not authenticated.
+func (s *Server) downloadExportHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "downloadExportHandler")
+
+	exportId := r.PathValue("id")
+	query := r.URL.Query()
+	if err := s.verifyExportSignature(exportId, query.Get("expires"), query.Get("signature"), time.Now()); err != nil {
+		slog.Info("Invalid export link", "Handler", "downloadExportHandler", "error", err)
+		writeErrorResponse(w, ErrForbidden)
+		return
+	}
+
+	export, err := s.Store.GetExport(r.Context(), exportId)
+	if err == nil && (export.Status != db.ExportStatusReady || export.BlobKey == nil) {
+		err = db.ErrExportNotFound
+	}
+
+	if err != nil {
+		slog.Info("Could not load export", "Handler", "downloadExportHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	archive, err := s.Blobs.Get(r.Context(), *export.BlobKey)
+	if err != nil {
+		slog.Error("Could not load export archive", "Handler", "downloadExportHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+	defer archive.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="muzz-export-%s.zip"`, export.Id))
+	w.Header().Set("Cache-Control", "private, no-store")
+	io.Copy(w, archive)
+}
+
+// buildExport gathers the user's data into a zip archive in the blob store and records the outcome on the export.
+func (s *Server) buildExport(ctx context.Context, export *db.DataExport) {
+	slog.Info("Building export", "export", export.Id, "user", export.UserId)
+
+	status, blobKey := db.ExportStatusReady, fmt.Sprintf("exports/%d/%s.zip", export.UserId, export.Id)
+
+	data, err := s.Store.GetExportData(ctx, export.UserId)
+	if err == nil {
+		var archive bytes.Buffer
+		err = writeExportArchive(&archive, data, func(photo *db.Photo) (io.ReadCloser, error) {
+			return s.Blobs.Get(ctx, photoKey(photo.BlobPrefix, exportPhotoSize))
+		})
+
+		if err == nil {
+			err = s.Blobs.Put(ctx, blobKey, &archive, "application/zip")
+		}
+	}
+
+	if err != nil {
+		slog.Error("Could not build export", "export", export.Id, "error", err)
+		status, blobKey = db.ExportStatusFailed, ""
+	}
+
+	if err := s.Store.CompleteExport(ctx, export.Id, status, blobKey); err != nil {
+		slog.Error("Could not complete export", "export", export.Id, "error", err)
+	}
+
+	slog.Info("Building export complete", "export", export.Id, "status", status)
+}
+
+// retryStaleExports builds the exports left pending by a build which never finished, such as one cut off by a restart.
+func (s *Server) retryStaleExports(ctx context.Context) error {
+	exports, err := s.Store.ClaimStaleExports(ctx, exportStaleAfter)
+	if err != nil {
+		return err
+	}
+
+	for _, export := range exports {
+		s.buildExport(ctx, export)
+	}
+
+	return nil
+}
+
+// exportPhotoSize is the largest size photos are stored at, which is the closest to the original upload
+var exportPhotoSize = imaging.Sizes[len(imaging.Sizes)-1].Name
+
+// writeExportArchive writes a zip with a JSON file for each kind of data held about the user, and their photos.
+func writeExportArchive(w io.Writer, data *db.ExportData, openPhoto func(*db.Photo) (io.ReadCloser, error)) error {
+	archive := zip.NewWriter(w)
+
+	files := []struct {
+		name    string
+		content any
+	}{
+		{"profile.json", data.Profile},
+		{"preferences.json", data.Preferences},
+		{"swipes.json", data.Swipes},
+		{"matches.json", data.Matches},
+		{"sessions.json", data.Sessions},
+		{"photos.json", data.Photos},
+	}
+
+	for _, file := range files {
+		fileWriter, err := archive.Create(file.name)
+		if err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(fileWriter)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.content); err != nil {
+			return err
+		}
+	}
+
+	for _, photo := range data.Photos {
+		if err := writeExportPhoto(archive, photo, openPhoto); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+func writeExportPhoto(archive *zip.Writer, photo *db.Photo, openPhoto func(*db.Photo) (io.ReadCloser, error)) error {
+	photoReader, err := openPhoto(photo)
+	if err != nil {
+		return err
+	}
+	defer photoReader.Close()
+
+	fileWriter, err := archive.Create(fmt.Sprintf("photos/%d.jpg", photo.Id))
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(fileWriter, photoReader)
+	return err
+}
+
+// signExportURL returns a link to download the export which stops working after expiresAt.
+func (s *Server) signExportURL(exportId string, expiresAt time.Time) string {
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	query := url.Values{"expires": {expires}, "signature": {s.exportSignature(exportId, expires)}}
+
+	return "/exports/" + url.PathEscape(exportId) + "?" + query.Encode()
+}
+
+func (s *Server) verifyExportSignature(exportId string, expires string, signature string, now time.Time) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidRequest
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(s.exportSignature(exportId, expires))) {
+		return ErrForbidden
+	}
+
+	if now.Unix() > expiresAt {
+		return ErrExportLinkExpired
+	}
+
+	return nil
+}
+
+func (s *Server) exportSignature(exportId string, expires string) string {
+	mac := hmac.New(sha256.New, s.ExportSigningKey)
+	mac.Write([]byte(exportId + "\n" + expires))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -2,21 +2,44 @@ package server
 
 import (
 	"math"
+	"slices"
 	"sort"
 
 	"github.com/chammond14/muzz/internal/db"
 )
 
-func sortProfilesByLocation(profiles []*db.DiscoverProfile, location db.Location) {
+// sortProfilesByLocation orders the profiles nearest first, then by the number of interests shared with the viewer.
+// When verifiedFirst is set, profiles with a verified email come before the rest.
+func sortProfilesByLocation(profiles []*db.DiscoverProfile, location db.Location, interests []string, verifiedFirst bool) {
 	for _, profile := range profiles {
 		profile.DistanceFromMe = getDistanceInKm(location.Lat, location.Long, profile.Lat, profile.Long)
+		profile.SharedInterests = countSharedInterests(interests, profile.Interests)
 	}
 
 	sort.Slice(profiles, func(i, j int) bool {
-		return profiles[i].DistanceFromMe < profiles[j].DistanceFromMe
+		if verifiedFirst && profiles[i].Verified != profiles[j].Verified {
+			return profiles[i].Verified
+		}
+
+		if profiles[i].DistanceFromMe != profiles[j].DistanceFromMe {
+			return profiles[i].DistanceFromMe < profiles[j].DistanceFromMe
+		}
+
+		return profiles[i].SharedInterests > profiles[j].SharedInterests
 	})
 }
 
+func countSharedInterests(mine []string, theirs []string) int {
+	shared := 0
+	for _, interest := range theirs {
+		if slices.Contains(mine, interest) {
+			shared++
+		}
+	}
+
+	return shared
+}
+
 func getDistanceInKm(lat1 float64, lon1 float64, lat2 float64, lon2 float64) int {
 	radLat1 := float64(math.Pi * lat1 / 180)
 	radLat2 := float64(math.Pi * lat2 / 180)
@@ -37,3 +60,36 @@ func getDistanceInKm(lat1 float64, lon1 float64, lat2 float64, lon2 float64) int
 
 	return int(dist)
 }
+
+// applyPreferences fills in any filter the discover request left out from the user's stored preferences.
+// Dealbreaker preferences narrow the filters even when they were supplied. It returns false when the
+// filters and dealbreakers cannot both be satisfied, meaning no profile can match.
+func applyPreferences(filters *db.DiscoverFilters, preferences *db.Preferences) bool {
+	if preferences.MinAge != nil && (filters.MinAge == 0 || preferences.AgeDealbreaker && *preferences.MinAge > filters.MinAge) {
+		filters.MinAge = *preferences.MinAge
+	}
+
+	if preferences.MaxAge != nil && (filters.MaxAge == 0 || preferences.AgeDealbreaker && *preferences.MaxAge < filters.MaxAge) {
+		filters.MaxAge = *preferences.MaxAge
+	}
+
+	if len(preferences.Genders) > 0 {
+		if len(filters.Genders) == 0 {
+			filters.Genders = preferences.Genders
+		} else if preferences.GenderDealbreaker {
+			filters.Genders = slices.DeleteFunc(slices.Clone(filters.Genders), func(gender string) bool {
+				return !slices.Contains(preferences.Genders, gender)
+			})
+
+			if len(filters.Genders) == 0 {
+				return false
+			}
+		}
+	}
+
+	if preferences.MaxDistanceKm != nil && (filters.MaxDistanceKm == 0 || preferences.DistanceDealbreaker && *preferences.MaxDistanceKm < filters.MaxDistanceKm) {
+		filters.MaxDistanceKm = *preferences.MaxDistanceKm
+	}
+
+	return filters.MinAge == 0 || filters.MaxAge == 0 || filters.MinAge <= filters.MaxAge
+}
@@ -29,9 +29,78 @@ func Test_sortProfilesByLocationSortsLocationsCorrectly(t *testing.T) {
 
 	profiles := []*db.DiscoverProfile{profile1, profile2}
 
-	sortProfilesByLocation(profiles, db.Location{Lat: -0.08768348444653988, Long: 51.508050972200834})
+	sortProfilesByLocation(profiles, db.Location{Lat: -0.08768348444653988, Long: 51.508050972200834}, nil, false)
 
 	if profiles[0].DistanceFromMe > profiles[1].DistanceFromMe {
 		t.Errorf("expected first profile in slice to have lesser distance")
 	}
 }
+
+func Test_sortProfilesByLocationCountsSharedInterests(t *testing.T) {
+	location := db.Location{Lat: 51.5, Long: -0.1}
+	profile1 := &db.DiscoverProfile{Id: 1, Lat: 51.5, Long: -0.1, Interests: []string{"climbing"}}
+	profile2 := &db.DiscoverProfile{Id: 2, Lat: 51.5, Long: -0.1, Interests: []string{"climbing", "jazz", "chess"}}
+
+	profiles := []*db.DiscoverProfile{profile1, profile2}
+
+	sortProfilesByLocation(profiles, location, []string{"jazz", "climbing"}, false)
+
+	if profiles[0].Id != 2 || profiles[0].SharedInterests != 2 || profiles[1].SharedInterests != 1 {
+		t.Errorf("expected equally distant profiles to be ordered by shared interests, got %+v %+v", profiles[0], profiles[1])
+	}
+}
+
+func Test_applyPreferencesFillsInMissingFilters(t *testing.T) {
+	minAge, maxAge, distance := 25, 35, 50
+	preferences := &db.Preferences{MinAge: &minAge, MaxAge: &maxAge, Genders: []string{"female"}, MaxDistanceKm: &distance}
+	filters := &db.DiscoverFilters{MaxAge: 40}
+
+	if !applyPreferences(filters, preferences) {
+		t.Fatal("expected filters to be satisfiable")
+	}
+
+	if filters.MinAge != 25 || filters.MaxAge != 40 || filters.MaxDistanceKm != 50 {
+		t.Errorf("expected request filters to override preferences but got %+v", filters)
+	}
+
+	if len(filters.Genders) != 1 || filters.Genders[0] != "female" {
+		t.Errorf("expected stored genders but got %v", filters.Genders)
+	}
+}
+
+func Test_applyPreferencesEnforcesDealbreakers(t *testing.T) {
+	maxAge := 35
+	preferences := &db.Preferences{MaxAge: &maxAge, AgeDealbreaker: true, Genders: []string{"female"}, GenderDealbreaker: true}
+
+	filters := &db.DiscoverFilters{MaxAge: 40, Genders: []string{"female", "male"}}
+	if !applyPreferences(filters, preferences) {
+		t.Fatal("expected filters to be satisfiable")
+	}
+
+	if filters.MaxAge != 35 {
+		t.Errorf("expected dealbreaker max age of 35 but got %d", filters.MaxAge)
+	}
+
+	if len(filters.Genders) != 1 || filters.Genders[0] != "female" {
+		t.Errorf("expected genders to be narrowed by dealbreaker but got %v", filters.Genders)
+	}
+
+	filters = &db.DiscoverFilters{Genders: []string{"male"}}
+	if applyPreferences(filters, preferences) {
+		t.Error("expected filters outside of dealbreaker genders to be unsatisfiable")
+	}
+}
+
+func Test_sortProfilesByLocationCanRankVerifiedFirst(t *testing.T) {
+	location := db.Location{Lat: 51.5, Long: -0.1}
+	profile1 := &db.DiscoverProfile{Id: 1, Lat: 51.5, Long: -0.1}
+	profile2 := &db.DiscoverProfile{Id: 2, Lat: 52.5, Long: -0.1, Verified: true}
+
+	profiles := []*db.DiscoverProfile{profile1, profile2}
+
+	sortProfilesByLocation(profiles, location, nil, true)
+
+	if profiles[0].Id != 2 {
+		t.Errorf("expected the verified profile first, got %+v", profiles[0])
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+const (
+	readinessReady    = "ready"
+	readinessNotReady = "not_ready"
+	readinessDraining = "draining"
+
+	checkOK      = "ok"
+	checkError   = "error"
+	checkPending = "pending"
+	checkSkipped = "skipped"
+)
+
+// DependencyStatus describes the state of one dependency checked for readiness
+type DependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Pending lists the migrations which have not been applied
+	Pending []string `json:"pending,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// healthzHandler reports that the process is up and serving requests. It checks nothing else, so a restart is only
+// triggered by the process itself being stuck. Health checks run every few seconds, so they are not logged.
+func (s *Server) healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJsonResponse(w, http.StatusOK, map[string]string{"status": checkOK})
+}
+
+// readyzHandler reports whether the service can handle requests: Postgres is reachable and every migration has been
+// applied. It returns 503 once a shutdown has started, so no more requests are routed here.
+func (s *Server) readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if s.draining.Load() {
+		writeJsonResponse(w, http.StatusServiceUnavailable, ReadinessResponse{Status: readinessDraining, Dependencies: map[string]DependencyStatus{}})
+		return
+	}
+
+	response := ReadinessResponse{Status: readinessReady, Dependencies: map[string]DependencyStatus{}}
+
+	// errors are logged rather than returned, as the endpoint does not need a session
+	if err := s.Store.TestConnection(r.Context()); err != nil {
+		slog.Error("Readiness check failed", "dependency", "database", "error", err)
+		response.Dependencies["database"] = DependencyStatus{Status: checkError, Error: "database is unreachable"}
+		response.Dependencies["migrations"] = DependencyStatus{Status: checkSkipped}
+	} else {
+		response.Dependencies["database"] = DependencyStatus{Status: checkOK}
+
+		pending, err := s.Store.PendingMigrations(r.Context())
+		switch {
+		case err != nil:
+			slog.Error("Readiness check failed", "dependency", "migrations", "error", err)
+			response.Dependencies["migrations"] = DependencyStatus{Status: checkError, Error: "could not read applied migrations"}
+		case len(pending) > 0:
+			slog.Error("Readiness check failed", "dependency", "migrations", "pending", pending)
+			response.Dependencies["migrations"] = DependencyStatus{Status: checkPending, Pending: pending}
+		default:
+			response.Dependencies["migrations"] = DependencyStatus{Status: checkOK}
+		}
+	}
+
+	status := http.StatusOK
+	for _, dependency := range response.Dependencies {
+		if dependency.Status != checkOK {
+			response.Status = readinessNotReady
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	writeJsonResponse(w, status, response)
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/chammond14/muzz/internal/db"
+	"github.com/chammond14/muzz/internal/oidc"
+)
+
+// oauthStateTTL is how long a user has to sign in at the provider
+const oauthStateTTL = 10 * time.Minute
+
+type LinkIdentityResponse struct {
+	AuthorizationURL string `json:"authorizationUrl"`
+}
+
+type IdentitiesResponse struct {
+	Results []*db.FederatedIdentity `json:"results"`
+}
+
+type IdentityLinkedResponse struct {
+	Provider string `json:"provider"`
+}
+
+func (s *Server) oidcProvider(r *http.Request) (*oidc.Provider, error) {
+	provider, ok := s.OIDCProviders[r.PathValue("provider")]
+	if !ok {
+		return nil, ErrProviderNotFound
+	}
+
+	return provider, nil
+}
+
+// startOAuth stores the state, nonce and PKCE verifier for a sign in and returns the provider's URL to send the user to.
+func (s *Server) startOAuth(ctx context.Context, provider *oidc.Provider, userId *int32) (string, error) {
+	state, err := oidc.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	nonce, err := oidc.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	verifier, err := oidc.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+
+	oauthState := db.OAuthState{StateHash: hashResetToken(state), Provider: provider.Name, Nonce: nonce, Verifier: verifier, UserId: userId}
+	if err := s.Store.CreateOAuthState(ctx, oauthState, oauthStateTTL); err != nil {
+		return "", err
+	}
+
+	return provider.AuthCodeURL(ctx, state, nonce, verifier)
+}
+
+func (s *Server) oauthStartHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "oauthStartHandler")
+
+	provider, err := s.oidcProvider(r)
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+
+	authURL, err := s.startOAuth(r.Context(), provider, nil)
+	if err != nil {
+		slog.Error("Could not start sign in", "Handler", "oauthStartHandler", "provider", provider.Name, "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "oauthStartHandler")
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+func (s *Server) linkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "linkIdentityHandler")
+
+	provider, err := s.oidcProvider(r)
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	authURL, err := s.startOAuth(r.Context(), provider, &userId)
+	if err != nil {
+		slog.Error("Could not start sign in", "Handler", "linkIdentityHandler", "provider", provider.Name, "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "linkIdentityHandler")
+	writeJsonResponse(w, http.StatusOK, LinkIdentityResponse{AuthorizationURL: authURL})
+}
+
+// oauthCallbackHandler completes a sign in at the provider. It links the identity when the sign in was started by a
+// logged in user, and otherwise logs in to the linked profile, creating one for an identity seen for the first time.
+func (s *Server) oauthCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "oauthCallbackHandler")
+
+	provider, err := s.oidcProvider(r)
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+
+	query := r.URL.Query()
+	if query.Get("error") != "" || query.Get("code") == "" || query.Get("state") == "" {
+		slog.Info("Sign in was not completed", "Handler", "oauthCallbackHandler", "provider", provider.Name, "error", query.Get("error"))
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	state, err := s.Store.ConsumeOAuthState(r.Context(), hashResetToken(query.Get("state")), provider.Name)
+	if err != nil {
+		slog.Info("Invalid sign in state", "Handler", "oauthCallbackHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	identity, err := provider.Exchange(r.Context(), query.Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		slog.Info("Could not complete sign in", "Handler", "oauthCallbackHandler", "provider", provider.Name, "error", err)
+		writeErrorResponse(w, ErrSignInFailed)
+		return
+	}
+
+	if state.UserId != nil {
+		if err := s.Store.LinkIdentity(r.Context(), *state.UserId, provider.Name, *identity); err != nil {
+			slog.Info("Could not link identity", "Handler", "oauthCallbackHandler", "error", err)
+			writeErrorResponse(w, err)
+			return
+		}
+
+		s.recordAuditAs(r, state.UserId, AuditIdentityLinked, state.UserId, map[string]string{"provider": provider.Name})
+
+		slog.Info("Request Complete", "Handler", "oauthCallbackHandler")
+		writeJsonResponse(w, http.StatusOK, IdentityLinkedResponse{Provider: provider.Name})
+		return
+	}
+
+	userId, twoFactor, err := s.Store.FederatedLogin(r.Context(), provider.Name, *identity)
+	if err == db.ErrIdentityNotFound {
+		userId, err = s.createFederatedProfile(r, provider.Name, identity)
+	}
+
+	if err != nil {
+		slog.Info("Could not log in with identity", "Handler", "oauthCallbackHandler", "provider", provider.Name, "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.completeLogin(w, r, "oauthCallbackHandler", userId, twoFactor, map[string]string{"method": provider.Name})
+}
+
+// createFederatedProfile creates a profile for an identity seen for the first time. Like /user/create, the details
+// the provider does not share are random until the user edits them.
+func (s *Server) createFederatedProfile(r *http.Request, provider string, identity *oidc.Identity) (int32, error) {
+	if identity.Email == "" {
+		return 0, ErrIdentityEmailRequired
+	}
+
+	name := identity.Name
+	if name == "" {
+		name = s.Generator.Generate()
+	}
+
+	gender := genders[rand.IntN(len(genders))]
+	location := db.Location{Lat: -0.08768348444653988, Long: 51.508050972200834}
+
+	// the password is never shown, so the profile can only log in with the provider until the password is reset
+	profile, err := s.Store.CreateFederatedProfile(r.Context(), provider, *identity, randomDateOfBirth(time.Now()), name, gender, s.Generator.Generate(), location)
+	if err != nil {
+		return 0, err
+	}
+
+	if !profile.EmailVerified {
+		if err := s.sendVerificationEmail(r.Context(), profile.Id, profile.Email); err != nil {
+			slog.Info("Could not send verification email", "Handler", "oauthCallbackHandler", "error", err)
+		}
+	}
+
+	s.recordAuditAs(r, &profile.Id, AuditIdentityLinked, &profile.Id, map[string]string{"provider": provider, "created": "true"})
+	return profile.Id, nil
+}
+
+func (s *Server) listIdentitiesHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "listIdentitiesHandler")
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	identities, err := s.Store.GetIdentities(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not load identities", "Handler", "listIdentitiesHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "listIdentitiesHandler")
+	writeJsonResponse(w, http.StatusOK, IdentitiesResponse{Results: identities})
+}
+
+func (s *Server) unlinkIdentityHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "unlinkIdentityHandler")
+
+	provider := r.PathValue("provider")
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	if err := s.Store.UnlinkIdentity(r.Context(), userId, provider); err != nil {
+		slog.Info("Could not unlink identity", "Handler", "unlinkIdentityHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditIdentityUnlinked, &userId, map[string]string{"provider": provider})
+
+	slog.Info("Request Complete", "Handler", "unlinkIdentityHandler")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) purgeExpiredOAuthStates(ctx context.Context) error {
+	_, err := s.Store.PurgeExpiredOAuthStates(ctx)
+	return err
+}
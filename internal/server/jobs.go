@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/chammond14/muzz/internal/db"
+	"github.com/chammond14/muzz/internal/jobs"
+)
+
+type JobsResponse struct {
+	Results []*db.JobState `json:"results"`
+}
+
+// jobRunner schedules the periodic maintenance jobs. Schedules are cron expressions evaluated in UTC.
+func (s *Server) jobRunner() (*jobs.Runner, error) {
+	runner := jobs.NewRunner(s.Store)
+
+	scheduled := []struct {
+		name     string
+		schedule string
+		run      func(context.Context) error
+	}{
+		{"purge-expired-sessions", "*/15 * * * *", s.purgeExpiredSessions},
+		{"purge-password-resets", "@hourly", s.purgeExpiredPasswordResets},
+		{"purge-login-challenges", "@hourly", s.purgeExpiredLoginChallenges},
+		{"purge-oauth-states", "@hourly", s.purgeExpiredOAuthStates},
+		{"purge-phone-codes", "@hourly", s.purgeExpiredPhoneCodes},
+		{"prune-rate-limits", "@hourly", s.pruneRateLimits},
+		{"prune-audit-log", "@hourly", s.pruneAuditLog},
+		{"purge-deleted-profiles", "@hourly", s.purgeDeletedProfiles},
+		{"retry-stale-exports", "*/15 * * * *", s.retryStaleExports},
+		{"prune-outbox", "@daily", s.pruneOutbox},
+		{"prune-notifications", "@daily", s.pruneNotifications},
+		{"prune-mail", "@daily", s.pruneMail},
+	}
+
+	for _, job := range scheduled {
+		if err := runner.Add(job.name, job.schedule, job.run); err != nil {
+			return nil, err
+		}
+	}
+
+	return runner, nil
+}
+
+func (s *Server) purgeExpiredSessions(ctx context.Context) error {
+	_, err := s.Store.PurgeExpiredSessions(ctx)
+	return err
+}
+
+func (s *Server) listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "listJobsHandler")
+
+	jobStates, err := s.Store.GetJobs(r.Context())
+	if err != nil {
+		slog.Info("Could not load jobs", "Handler", "listJobsHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "listJobsHandler")
+	writeJsonResponse(w, http.StatusOK, JobsResponse{Results: jobStates})
+}
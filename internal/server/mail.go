@@ -0,0 +1,14 @@
+package server
+
+import (
+	"context"
+	"time"
+)
+
+// mailRetention is how long sent and dead emails are kept in the mail outbox, to help investigate deliveries
+const mailRetention = 30 * 24 * time.Hour
+
+func (s *Server) pruneMail(ctx context.Context) error {
+	_, err := s.Store.PruneMail(ctx, time.Now().Add(-mailRetention))
+	return err
+}
@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/0x6flab/namegenerator"
+	"github.com/chammond14/muzz/internal/blob"
 	"github.com/chammond14/muzz/internal/db"
 	"github.com/go-playground/validator/v10"
 	"github.com/joho/godotenv"
@@ -35,12 +36,24 @@ func TestMain(m *testing.M) {
 		panic(testErr)
 	}
 
+	mediaDir, err := os.MkdirTemp("", "muzz-media")
+	if err != nil {
+		panic(err)
+	}
+
+	blobs, err := blob.NewLocalStore(mediaDir, "/media")
+	if err != nil {
+		panic(err)
+	}
+
 	TestServer = Server{
 		Store:     datastore,
 		Validate:  validator.New(validator.WithRequiredStructEnabled()),
 		Generator: namegenerator.NewGenerator(),
+		Blobs:     blobs,
 	}
 
 	code := m.Run()
+	os.RemoveAll(mediaDir)
 	os.Exit(code)
 }
@@ -2,13 +2,18 @@ package server
 
 import (
 	"context"
+	"log/slog"
 	"net/http"
+
+	"github.com/chammond14/muzz/internal/db"
 )
 
 type contextKey int
 
 const (
 	contextKeyUserId contextKey = iota
+	contextKeyRole
+	contextKeyVerified
 )
 
 func (s *Server) authenticate(sh ServerHandler) ServerHandler {
@@ -19,15 +24,37 @@ func (s *Server) authenticate(sh ServerHandler) ServerHandler {
 			return
 		}
 
-		userId, err := s.Store.GetSession(r.Context(), sessionToken)
+		session, err := s.Store.GetSession(r.Context(), sessionToken)
+		if err == db.ErrAccountSuspended || err == db.ErrAccountBanned {
+			writeErrorResponse(w, err)
+			return
+		}
+
 		if err != nil {
 			writeErrorResponse(w, ErrMustBeLoggedIn)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), contextKeyUserId, userId)
+		ctx := context.WithValue(r.Context(), contextKeyUserId, session.UserId)
+		ctx = context.WithValue(ctx, contextKeyRole, session.Role)
+		ctx = context.WithValue(ctx, contextKeyVerified, session.Verified)
 		r = r.WithContext(ctx)
 
 		sh(w, r)
 	}
 }
+
+// authorize checks the logged in user's role grants the permission before calling the handler.
+// It must wrap a handler that has already been authenticated.
+func (s *Server) authorize(permission Permission, sh ServerHandler) ServerHandler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role, _ := r.Context().Value(contextKeyRole).(string)
+		if !hasPermission(role, permission) {
+			slog.Info("User does not have permission", "user", r.Context().Value(contextKeyUserId), "role", role, "permission", permission)
+			writeErrorResponse(w, ErrForbidden)
+			return
+		}
+
+		sh(w, r)
+	}
+}
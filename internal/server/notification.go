@@ -0,0 +1,202 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chammond14/muzz/internal/events"
+	"github.com/chammond14/muzz/internal/notify"
+)
+
+const (
+	// defaultLikeDigestWindow is how long likes are collected before they are sent as one notification
+	defaultLikeDigestWindow = 15 * time.Minute
+	// notificationRetention is how long sent notifications are kept, to help investigate deliveries
+	notificationRetention = 30 * 24 * time.Hour
+)
+
+type DeviceRequest struct {
+	Token    string `json:"token" validate:"required,max=4096"`
+	Platform string `json:"platform" validate:"required,oneof=ios android"`
+}
+
+// QuietHours are local times in the user's timezone, formatted as HH:MM. They may run past midnight.
+type QuietHours struct {
+	Start string `json:"start" validate:"required,datetime=15:04"`
+	End   string `json:"end" validate:"required,datetime=15:04"`
+}
+
+type NotificationPreferencesRequest struct {
+	Matches    bool        `json:"matches"`
+	Likes      bool        `json:"likes"`
+	QuietHours *QuietHours `json:"quietHours"`
+	Timezone   string      `json:"timezone" validate:"omitempty,timezone"`
+}
+
+type NotificationPreferencesResponse struct {
+	Matches    bool        `json:"matches"`
+	Likes      bool        `json:"likes"`
+	QuietHours *QuietHours `json:"quietHours"`
+	Timezone   string      `json:"timezone"`
+}
+
+// notifyMatch is subscribed to match.created events. The swiper learns of the match from their swipe response,
+// so only the other profile is notified.
+func (s *Server) notifyMatch(ctx context.Context, event events.Event) error {
+	var match events.MatchCreated
+	if err := json.Unmarshal(event.Payload, &match); err != nil {
+		return err
+	}
+
+	return s.Store.EnqueueMatchNotification(ctx, match.User2Id, match.MatchId)
+}
+
+// notifyLike is subscribed to swipe.liked events, adding the like to the liked profile's digest.
+func (s *Server) notifyLike(ctx context.Context, event events.Event) error {
+	var like events.SwipeLiked
+	if err := json.Unmarshal(event.Payload, &like); err != nil {
+		return err
+	}
+
+	window := s.LikeDigestWindow
+	if window <= 0 {
+		window = defaultLikeDigestWindow
+	}
+
+	return s.Store.EnqueueLikeNotification(ctx, like.SwipedId, like.SwiperId, time.Now().UTC().Add(window))
+}
+
+func (s *Server) pruneNotifications(ctx context.Context) error {
+	_, err := s.Store.PruneNotifications(ctx, time.Now().Add(-notificationRetention))
+	return err
+}
+
+func (s *Server) registerDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "registerDeviceHandler")
+
+	deviceRequest, err := createRequestBodyFromRequest(r, &DeviceRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "registerDeviceHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("registerDeviceHandler", deviceRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "registerDeviceHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	device, err := s.Store.RegisterDevice(r.Context(), userId, deviceRequest.Token, deviceRequest.Platform)
+	if err != nil {
+		slog.Info("Could not register device", "Handler", "registerDeviceHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "registerDeviceHandler")
+	writeJsonResponse(w, http.StatusOK, device)
+}
+
+func (s *Server) deleteDeviceHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "deleteDeviceHandler")
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	if err := s.Store.DeleteDevice(r.Context(), userId, r.PathValue("token")); err != nil {
+		slog.Info("Could not delete device", "Handler", "deleteDeviceHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "deleteDeviceHandler")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "getNotificationPreferencesHandler")
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	preferences, err := s.Store.GetNotificationPreferences(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not load notification preferences", "Handler", "getNotificationPreferencesHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "getNotificationPreferencesHandler")
+	writeJsonResponse(w, http.StatusOK, notificationPreferencesResponse(preferences))
+}
+
+func (s *Server) setNotificationPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "setNotificationPreferencesHandler")
+
+	preferencesRequest, err := createRequestBodyFromRequest(r, &NotificationPreferencesRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "setNotificationPreferencesHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("setNotificationPreferencesHandler", preferencesRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "setNotificationPreferencesHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	preferences := notify.Preferences{
+		Matches:  preferencesRequest.Matches,
+		Likes:    preferencesRequest.Likes,
+		Timezone: preferencesRequest.Timezone,
+	}
+
+	if preferences.Timezone == "" {
+		preferences.Timezone = "UTC"
+	}
+
+	if preferencesRequest.QuietHours != nil {
+		start := minutesAfterMidnight(preferencesRequest.QuietHours.Start)
+		end := minutesAfterMidnight(preferencesRequest.QuietHours.End)
+		preferences.QuietStart, preferences.QuietEnd = &start, &end
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	preferences, err = s.Store.SetNotificationPreferences(r.Context(), userId, preferences)
+	if err != nil {
+		slog.Info("Could not save notification preferences", "Handler", "setNotificationPreferencesHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "setNotificationPreferencesHandler")
+	writeJsonResponse(w, http.StatusOK, notificationPreferencesResponse(preferences))
+}
+
+func notificationPreferencesResponse(preferences notify.Preferences) NotificationPreferencesResponse {
+	response := NotificationPreferencesResponse{
+		Matches:  preferences.Matches,
+		Likes:    preferences.Likes,
+		Timezone: preferences.Timezone,
+	}
+
+	if preferences.QuietStart != nil && preferences.QuietEnd != nil {
+		response.QuietHours = &QuietHours{
+			Start: fmt.Sprintf("%02d:%02d", *preferences.QuietStart/60, *preferences.QuietStart%60),
+			End:   fmt.Sprintf("%02d:%02d", *preferences.QuietEnd/60, *preferences.QuietEnd%60),
+		}
+	}
+
+	return response
+}
+
+// minutesAfterMidnight converts a validated HH:MM time to minutes after midnight.
+func minutesAfterMidnight(clock string) int {
+	parsed, _ := time.Parse("15:04", clock)
+	return parsed.Hour()*60 + parsed.Minute()
+}
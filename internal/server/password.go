@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+const (
+	defaultPasswordResetTTL = time.Hour
+	defaultPasswordResetURL = "muzz://password-reset"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email,max=254"`
+}
+
+// ForgotPasswordResponse is the same whether or not an account exists for the email.
+type ForgotPasswordResponse struct {
+	Message string `json:"message"`
+}
+
+type ResetPasswordRequest struct {
+	Token string `json:"token" validate:"required,max=100"`
+	// bcrypt only uses the first 72 bytes of a password
+	Password string `json:"password" validate:"required,min=8,max=72"`
+}
+
+func (s *Server) passwordResetTTL() time.Duration {
+	if s.PasswordResetTTL <= 0 {
+		return defaultPasswordResetTTL
+	}
+
+	return s.PasswordResetTTL
+}
+
+// passwordResetLink builds the link emailed to the user, which opens the app's reset page with the token.
+func (s *Server) passwordResetLink(token string) (string, error) {
+	base := s.PasswordResetURL
+	if base == "" {
+		base = defaultPasswordResetURL
+	}
+
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+	return link.String(), nil
+}
+
+// hashResetToken returns the hash a reset token is stored as, so the tokens cannot be used by anyone reading the db.
+func hashResetToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func (s *Server) forgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "forgotPasswordHandler")
+
+	forgotRequest, err := createRequestBodyFromRequest(r, &ForgotPasswordRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "forgotPasswordHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("forgotPasswordHandler", forgotRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "forgotPasswordHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		slog.Error("Could not generate reset token", "Handler", "forgotPasswordHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+	link, err := s.passwordResetLink(token)
+	if err != nil {
+		slog.Error("Could not build reset link", "Handler", "forgotPasswordHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	userId, err := s.Store.RequestPasswordReset(r.Context(), forgotRequest.Email, hashResetToken(token), link, s.passwordResetTTL())
+	if err != nil {
+		slog.Info("Could not request password reset", "Handler", "forgotPasswordHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	if userId != nil {
+		s.recordAuditAs(r, nil, AuditPasswordResetRequested, userId, nil)
+	}
+
+	slog.Info("Request Complete", "Handler", "forgotPasswordHandler")
+	writeJsonResponse(w, http.StatusAccepted, ForgotPasswordResponse{Message: "If an account exists for this email, a password reset link has been sent to it."})
+}
+
+func (s *Server) resetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "resetPasswordHandler")
+
+	resetRequest, err := createRequestBodyFromRequest(r, &ResetPasswordRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "resetPasswordHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("resetPasswordHandler", resetRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "resetPasswordHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	userId, err := s.Store.ResetPassword(r.Context(), hashResetToken(resetRequest.Token), resetRequest.Password)
+	if err != nil {
+		slog.Info("Could not reset password", "Handler", "resetPasswordHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAuditAs(r, &userId, AuditPasswordReset, &userId, nil)
+
+	slog.Info("Request Complete", "Handler", "resetPasswordHandler")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) purgeExpiredPasswordResets(ctx context.Context) error {
+	_, err := s.Store.PurgeExpiredPasswordResets(ctx)
+	return err
+}
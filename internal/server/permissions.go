@@ -0,0 +1,46 @@
+package server
+
+import (
+	"slices"
+
+	"github.com/chammond14/muzz/internal/db"
+)
+
+type Permission string
+
+const (
+	PermissionModerateReports Permission = "reports:moderate"
+	PermissionSearchUsers     Permission = "users:search"
+	PermissionViewProfiles    Permission = "users:view"
+	PermissionRevokeSessions  Permission = "sessions:revoke"
+	PermissionAssignRoles     Permission = "roles:assign"
+	PermissionViewAuditLog    Permission = "audit:view"
+	PermissionReviewPhotos    Permission = "photos:review"
+	PermissionViewJobs        Permission = "jobs:view"
+	PermissionManageWebhooks  Permission = "webhooks:manage"
+)
+
+var rolePermissions = map[string][]Permission{
+	db.RoleUser: {},
+	db.RoleModerator: {
+		PermissionModerateReports,
+		PermissionSearchUsers,
+		PermissionViewProfiles,
+		PermissionReviewPhotos,
+	},
+	db.RoleAdmin: {
+		PermissionModerateReports,
+		PermissionSearchUsers,
+		PermissionViewProfiles,
+		PermissionRevokeSessions,
+		PermissionAssignRoles,
+		PermissionViewAuditLog,
+		PermissionReviewPhotos,
+		PermissionViewJobs,
+		PermissionManageWebhooks,
+	},
+}
+
+func hasPermission(role string, permission Permission) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"math/big"
+	mathrand "math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/chammond14/muzz/internal/db"
+	"github.com/chammond14/muzz/internal/sms"
+)
+
+const (
+	phoneCodeTTL = 10 * time.Minute
+
+	// codes sent to each phone number, and from each address, per hour
+	phoneCodeLimit   = 5
+	phoneCodeIPLimit = 20
+	// codes checked for each phone number per hour, on top of the attempts allowed for each code
+	phoneVerifyLimit = 10
+)
+
+type PhoneCodeRequest struct {
+	Phone string `json:"phone" validate:"required,max=32"`
+}
+
+type PhoneCodeResponse struct {
+	Message string `json:"message"`
+}
+
+type PhoneVerifyRequest struct {
+	Phone string `json:"phone" validate:"required,max=32"`
+	Code  string `json:"code" validate:"required,len=6,numeric"`
+}
+
+func generatePhoneCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%06d", n.Int64()), nil
+}
+
+// smsProvider returns the configured provider, writing messages to the log when none is set.
+func (s *Server) smsProvider() sms.Provider {
+	if s.SMSProvider == nil {
+		return &sms.ConsoleProvider{}
+	}
+
+	return s.SMSProvider
+}
+
+// verifyPhoneCode normalises the phone number and checks the code sent to it, returning the normalised number.
+// It writes the error response itself and returns false when the code cannot be accepted.
+func (s *Server) verifyPhoneCode(w http.ResponseWriter, r *http.Request, handler string, verifyRequest *PhoneVerifyRequest) (string, bool) {
+	phone, err := sms.NormalizePhone(verifyRequest.Phone)
+	if err != nil {
+		slog.Info("Invalid phone number", "Handler", handler)
+		writeErrorResponse(w, err)
+		return "", false
+	}
+
+	if !s.rateLimit(w, r, "phone-verify:"+phone, phoneVerifyLimit, time.Hour) {
+		return "", false
+	}
+
+	if err := s.Store.VerifyPhoneCode(r.Context(), phone, verifyRequest.Code); err != nil {
+		slog.Info("Could not verify phone code", "Handler", handler, "error", err)
+		writeErrorResponse(w, err)
+		return "", false
+	}
+
+	return phone, true
+}
+
+func (s *Server) sendPhoneCodeHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "sendPhoneCodeHandler")
+
+	codeRequest, err := createRequestBodyFromRequest(r, &PhoneCodeRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "sendPhoneCodeHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("sendPhoneCodeHandler", codeRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "sendPhoneCodeHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	phone, err := sms.NormalizePhone(codeRequest.Phone)
+	if err != nil {
+		slog.Info("Invalid phone number", "Handler", "sendPhoneCodeHandler")
+		writeErrorResponse(w, err)
+		return
+	}
+
+	if !s.rateLimit(w, r, "phone-code-ip:"+clientIP(r), phoneCodeIPLimit, time.Hour) ||
+		!s.rateLimit(w, r, "phone-code:"+phone, phoneCodeLimit, time.Hour) {
+		return
+	}
+
+	code, err := generatePhoneCode()
+	if err != nil {
+		slog.Error("Could not generate phone code", "Handler", "sendPhoneCodeHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	if err := s.Store.CreatePhoneCode(r.Context(), phone, code, phoneCodeTTL); err != nil {
+		slog.Info("Could not store phone code", "Handler", "sendPhoneCodeHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	body := fmt.Sprintf("Your Muzz code is %s. It expires in %d minutes.", code, int(phoneCodeTTL.Minutes()))
+	if err := s.smsProvider().Send(r.Context(), phone, body); err != nil {
+		slog.Error("Could not send phone code", "Handler", "sendPhoneCodeHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "sendPhoneCodeHandler")
+	writeJsonResponse(w, http.StatusAccepted, PhoneCodeResponse{Message: "A code has been sent to your phone."})
+}
+
+// phoneLoginHandler logs in with a code sent to the phone, creating a profile for a number seen for the first time.
+func (s *Server) phoneLoginHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "phoneLoginHandler")
+
+	verifyRequest, err := createRequestBodyFromRequest(r, &PhoneVerifyRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "phoneLoginHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("phoneLoginHandler", verifyRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "phoneLoginHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	phone, ok := s.verifyPhoneCode(w, r, "phoneLoginHandler", verifyRequest)
+	if !ok {
+		return
+	}
+
+	userId, twoFactor, err := s.Store.PhoneLogin(r.Context(), phone)
+	if err == db.ErrProfileNotFound {
+		userId, err = s.createPhoneProfile(r.Context(), phone)
+	}
+
+	if err != nil {
+		slog.Info("Could not log in with phone", "Handler", "phoneLoginHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.completeLogin(w, r, "phoneLoginHandler", userId, twoFactor, map[string]string{"method": "phone"})
+}
+
+// createPhoneProfile creates a profile for a phone number seen for the first time. Like /user/create, its details
+// are random until the user edits them.
+func (s *Server) createPhoneProfile(ctx context.Context, phone string) (int32, error) {
+	gender := genders[mathrand.IntN(len(genders))]
+	location := db.Location{Lat: -0.08768348444653988, Long: 51.508050972200834}
+
+	// the password is never shown, as the profile logs in with codes sent to the phone
+	profile, err := s.Store.CreatePhoneProfile(ctx, phone, randomDateOfBirth(time.Now()), s.Generator.Generate(), gender, s.Generator.Generate(), location)
+	if err != nil {
+		return 0, err
+	}
+
+	return profile.Id, nil
+}
+
+// setPhoneHandler adds or changes the logged in user's phone number, using a code sent to the new number.
+func (s *Server) setPhoneHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "setPhoneHandler")
+
+	verifyRequest, err := createRequestBodyFromRequest(r, &PhoneVerifyRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "setPhoneHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("setPhoneHandler", verifyRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "setPhoneHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	phone, ok := s.verifyPhoneCode(w, r, "setPhoneHandler", verifyRequest)
+	if !ok {
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	profile, err := s.Store.SetPhone(r.Context(), userId, phone)
+	if err != nil {
+		slog.Info("Could not set phone", "Handler", "setPhoneHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditPhoneChanged, &userId, nil)
+
+	slog.Info("Request Complete", "Handler", "setPhoneHandler")
+	writeJsonResponse(w, http.StatusOK, profile.Private())
+}
+
+func (s *Server) purgeExpiredPhoneCodes(ctx context.Context) error {
+	_, err := s.Store.PurgeExpiredPhoneCodes(ctx)
+	return err
+}
+
+func (s *Server) pruneRateLimits(ctx context.Context) error {
+	_, err := s.Store.PruneRateLimits(ctx)
+	return err
+}
@@ -0,0 +1,463 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chammond14/muzz/internal/blob"
+	"github.com/chammond14/muzz/internal/db"
+	"github.com/chammond14/muzz/internal/imaging"
+	"github.com/google/uuid"
+)
+
+const (
+	maxPhotosPerProfile       = 6
+	defaultMaxPhotoBytes      = 10 << 20
+	defaultPhotoMatchDistance = 5
+	defaultMediaURLTTL        = time.Hour
+	// multipartOverhead allows for the multipart boundaries and headers around the photo itself
+	multipartOverhead = 1 << 20
+)
+
+type PhotoResponse struct {
+	*db.Photo
+	Urls map[string]string `json:"urls"`
+}
+
+type PhotosResponse struct {
+	Results []*PhotoResponse `json:"results"`
+}
+
+type PhotoMatchResponse struct {
+	*db.PhotoMatch
+	PhotoUrls        map[string]string `json:"photoUrls"`
+	MatchedPhotoUrls map[string]string `json:"matchedPhotoUrls"`
+}
+
+type PhotoMatchesResponse struct {
+	Results []*PhotoMatchResponse `json:"results"`
+}
+
+type SimilarPhotoResponse struct {
+	*db.SimilarPhoto
+	Urls map[string]string `json:"urls"`
+}
+
+type SimilarPhotosResponse struct {
+	Results []*SimilarPhotoResponse `json:"results"`
+}
+
+type ResolvePhotoMatchRequest struct {
+	Status string `json:"status" validate:"required,oneof=confirmed dismissed"`
+}
+
+type ReorderPhotosRequest struct {
+	PhotoIds []int `json:"photos" validate:"required,min=1,max=6,dive,min=1"`
+}
+
+// photoUrls returns a signed address of each standard size of a photo, keyed by size name.
+func (s *Server) photoUrls(blobPrefix string) map[string]string {
+	now := time.Now()
+	urls := make(map[string]string, len(imaging.Sizes))
+	for _, size := range imaging.Sizes {
+		urls[size.Name] = s.signMediaURL(photoKey(blobPrefix, size.Name), now)
+	}
+
+	return urls
+}
+
+func (s *Server) mediaURLTTL() time.Duration {
+	if s.MediaURLTTL <= 0 {
+		return defaultMediaURLTTL
+	}
+
+	return s.MediaURLTTL
+}
+
+// signMediaURL returns a link to a blob which stops working after between one and two MediaURLTTL. Rounding the
+// expiry means responses within the same period hand out the same link, so clients can keep caching the photo.
+func (s *Server) signMediaURL(key string, now time.Time) string {
+	ttl := s.mediaURLTTL()
+	expires := strconv.FormatInt(now.Truncate(ttl).Add(2*ttl).Unix(), 10)
+	query := url.Values{"expires": {expires}, "signature": {s.mediaSignature(key, expires)}}
+
+	return s.Blobs.URL(key) + "?" + query.Encode()
+}
+
+func (s *Server) verifyMediaSignature(key string, expires string, signature string, now time.Time) (time.Time, error) {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidRequest
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(s.mediaSignature(key, expires))) || now.Unix() > expiresAt {
+		return time.Time{}, ErrForbidden
+	}
+
+	return time.Unix(expiresAt, 0), nil
+}
+
+func (s *Server) mediaSignature(key string, expires string) string {
+	mac := hmac.New(sha256.New, s.MediaSigningKey)
+	mac.Write([]byte(key + "\n" + expires))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func photoKey(blobPrefix string, size string) string {
+	return fmt.Sprintf("%s/%s.jpg", blobPrefix, size)
+}
+
+func (s *Server) photosResponse(photos []*db.Photo) PhotosResponse {
+	response := PhotosResponse{Results: make([]*PhotoResponse, 0, len(photos))}
+	for _, photo := range photos {
+		response.Results = append(response.Results, &PhotoResponse{Photo: photo, Urls: s.photoUrls(photo.BlobPrefix)})
+	}
+
+	return response
+}
+
+func (s *Server) addDiscoverPhotos(profiles []*db.DiscoverProfile) {
+	for _, profile := range profiles {
+		profile.Photos = make([]map[string]string, 0, len(profile.PhotoPrefixes))
+		for _, prefix := range profile.PhotoPrefixes {
+			profile.Photos = append(profile.Photos, s.photoUrls(prefix))
+		}
+	}
+}
+
+func (s *Server) uploadPhotoHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "uploadPhotoHandler")
+
+	maxBytes := s.MaxPhotoBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxPhotoBytes
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+multipartOverhead)
+	file, _, err := r.FormFile("photo")
+	if err != nil {
+		slog.Info("Could not read photo from request", "Handler", "uploadPhotoHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		slog.Info("Could not read photo", "Handler", "uploadPhotoHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	if int64(len(data)) > maxBytes {
+		slog.Info("Photo too large", "Handler", "uploadPhotoHandler", "limit", maxBytes)
+		writeErrorResponse(w, ErrPhotoTooLarge)
+		return
+	}
+
+	processed, err := imaging.Process(data)
+	if err != nil {
+		slog.Info("Could not process photo", "Handler", "uploadPhotoHandler", "error", err)
+		switch err {
+		case imaging.ErrUnsupportedType:
+			writeErrorResponse(w, ErrUnsupportedMediaType)
+		case imaging.ErrTooLarge:
+			writeErrorResponse(w, ErrPhotoTooLarge)
+		default:
+			writeErrorResponse(w, ErrInvalidRequest)
+		}
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	blobPrefix := fmt.Sprintf("photos/%d/%s", userId, uuid.New().String())
+	for size, image := range processed.Sizes {
+		err = s.Blobs.Put(r.Context(), photoKey(blobPrefix, size), bytes.NewReader(image), "image/jpeg")
+		if err != nil {
+			slog.Error("Could not store photo", "Handler", "uploadPhotoHandler", "error", err)
+			s.deletePhotoBlobs(r.Context(), blobPrefix)
+			writeErrorResponse(w, ErrUnexpectedError)
+			return
+		}
+	}
+
+	newPhoto := db.NewPhoto{
+		UserId:        userId,
+		BlobPrefix:    blobPrefix,
+		Hash:          processed.Hash,
+		MatchDistance: s.photoMatchDistance(),
+	}
+
+	photo, err := s.Store.AddPhoto(r.Context(), newPhoto, maxPhotosPerProfile)
+	if err != nil {
+		slog.Info("Could not add photo", "Handler", "uploadPhotoHandler", "error", err)
+		s.deletePhotoBlobs(r.Context(), blobPrefix)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "uploadPhotoHandler")
+	writeJsonResponse(w, http.StatusOK, &PhotoResponse{Photo: photo, Urls: s.photoUrls(blobPrefix)})
+}
+
+func (s *Server) listPhotosHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "listPhotosHandler")
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	photos, err := s.Store.GetPhotos(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not load photos", "Handler", "listPhotosHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "listPhotosHandler")
+	writeJsonResponse(w, http.StatusOK, s.photosResponse(photos))
+}
+
+func (s *Server) deletePhotoHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "deletePhotoHandler")
+
+	photoId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	photo, err := s.Store.DeletePhoto(r.Context(), userId, photoId)
+	if err != nil {
+		slog.Info("Could not delete photo", "Handler", "deletePhotoHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.deletePhotoBlobs(r.Context(), photo.BlobPrefix)
+
+	slog.Info("Request Complete", "Handler", "deletePhotoHandler")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) setPrimaryPhotoHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "setPrimaryPhotoHandler")
+
+	photoId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	photos, err := s.Store.SetPrimaryPhoto(r.Context(), userId, photoId)
+	if err != nil {
+		slog.Info("Could not set primary photo", "Handler", "setPrimaryPhotoHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "setPrimaryPhotoHandler")
+	writeJsonResponse(w, http.StatusOK, s.photosResponse(photos))
+}
+
+func (s *Server) reorderPhotosHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "reorderPhotosHandler")
+
+	reorderRequest, err := createRequestBodyFromRequest(r, &ReorderPhotosRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "reorderPhotosHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("reorderPhotosHandler", reorderRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "reorderPhotosHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	photos, err := s.Store.ReorderPhotos(r.Context(), userId, reorderRequest.PhotoIds)
+	if err != nil {
+		slog.Info("Could not reorder photos", "Handler", "reorderPhotosHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "reorderPhotosHandler")
+	writeJsonResponse(w, http.StatusOK, s.photosResponse(photos))
+}
+
+// mediaHandler serves photos to anyone holding a valid signed link, so it is not authenticated. Links are only
+// handed out in responses which may show the photo, such as discover, and stop working once they expire.
+func (s *Server) mediaHandler(w http.ResponseWriter, r *http.Request) {
+	// only photos are served here, other blobs such as data exports are served by their own handlers
+	key := r.PathValue("key")
+	if !strings.HasPrefix(key, "photos/") {
+		http.NotFound(w, r)
+		return
+	}
+
+	query := r.URL.Query()
+	expiresAt, err := s.verifyMediaSignature(key, query.Get("expires"), query.Get("signature"), time.Now())
+	if err != nil {
+		slog.Info("Invalid media link", "Handler", "mediaHandler", "error", err)
+		writeErrorResponse(w, ErrForbidden)
+		return
+	}
+
+	blobReader, err := s.Blobs.Get(r.Context(), key)
+	if err == blob.ErrNotFound || err == blob.ErrInvalidKey {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		slog.Error("Could not load blob", "Handler", "mediaHandler", "key", key, "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+	defer blobReader.Close()
+
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+
+	// blob keys are never reused, so the photo can be cached for as long as the link is valid
+	w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d, immutable", int(time.Until(expiresAt).Seconds())))
+	io.Copy(w, blobReader)
+}
+
+func (s *Server) photoMatchDistance() int {
+	if s.PhotoMatchDistance <= 0 {
+		return defaultPhotoMatchDistance
+	}
+
+	return s.PhotoMatchDistance
+}
+
+func (s *Server) listPhotoMatchesHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "listPhotoMatchesHandler")
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != db.PhotoMatchStatusOpen && status != db.PhotoMatchStatusConfirmed && status != db.PhotoMatchStatusDismissed {
+		slog.Info("Unknown photo match status", "Handler", "listPhotoMatchesHandler", "status", status)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	matches, err := s.Store.GetPhotoMatches(r.Context(), status)
+	if err != nil {
+		slog.Info("Could not load photo matches", "Handler", "listPhotoMatchesHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	response := PhotoMatchesResponse{Results: make([]*PhotoMatchResponse, 0, len(matches))}
+	for _, match := range matches {
+		response.Results = append(response.Results, &PhotoMatchResponse{
+			PhotoMatch:       match,
+			PhotoUrls:        s.photoUrls(match.PhotoPrefix),
+			MatchedPhotoUrls: s.photoUrls(match.MatchedPhotoPrefix),
+		})
+	}
+
+	slog.Info("Request Complete", "Handler", "listPhotoMatchesHandler")
+	writeJsonResponse(w, http.StatusOK, response)
+}
+
+func (s *Server) resolvePhotoMatchHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "resolvePhotoMatchHandler")
+
+	matchId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	resolveRequest, err := createRequestBodyFromRequest(r, &ResolvePhotoMatchRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "resolvePhotoMatchHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("resolvePhotoMatchHandler", resolveRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "resolvePhotoMatchHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	if err := s.Store.ResolvePhotoMatch(r.Context(), matchId, userId, resolveRequest.Status); err != nil {
+		slog.Info("Could not resolve photo match", "Handler", "resolvePhotoMatchHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditPhotoMatchResolved, nil, map[string]string{"match": strconv.Itoa(matchId), "status": resolveRequest.Status})
+
+	slog.Info("Request Complete", "Handler", "resolvePhotoMatchHandler")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// similarPhotosHandler finds every other profile with a photo similar to the given photo.
+// The optional distance parameter widens or narrows the search, up to db.MaxPhashDistance.
+func (s *Server) similarPhotosHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "similarPhotosHandler")
+
+	photoId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	distance := s.photoMatchDistance()
+	if r.URL.Query().Has("distance") {
+		distance, err = strconv.Atoi(r.URL.Query().Get("distance"))
+		if err != nil || distance < 0 || distance > db.MaxPhashDistance {
+			writeErrorResponse(w, ErrValidationError)
+			return
+		}
+	}
+
+	similar, err := s.Store.GetSimilarPhotos(r.Context(), photoId, distance)
+	if err != nil {
+		slog.Info("Could not load similar photos", "Handler", "similarPhotosHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	response := SimilarPhotosResponse{Results: make([]*SimilarPhotoResponse, 0, len(similar))}
+	for _, photo := range similar {
+		response.Results = append(response.Results, &SimilarPhotoResponse{SimilarPhoto: photo, Urls: s.photoUrls(photo.PhotoPrefix)})
+	}
+
+	slog.Info("Request Complete", "Handler", "similarPhotosHandler")
+	writeJsonResponse(w, http.StatusOK, response)
+}
+
+// deletePhotoBlobs removes every size of a photo from the blob store. Failures are logged, leaving orphaned files
+// rather than failing the request.
+func (s *Server) deletePhotoBlobs(ctx context.Context, blobPrefix string) {
+	for _, size := range imaging.Sizes {
+		if err := s.Blobs.Delete(ctx, photoKey(blobPrefix, size.Name)); err != nil {
+			slog.Error("Could not delete photo blob", "prefix", blobPrefix, "size", size.Name, "error", err)
+		}
+	}
+}
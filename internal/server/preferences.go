@@ -0,0 +1,76 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/chammond14/muzz/internal/db"
+)
+
+type PreferencesRequest struct {
+	MinAge              *int     `json:"minAge" validate:"omitempty,min=18,max=150"`
+	MaxAge              *int     `json:"maxAge" validate:"omitempty,min=18,max=150"`
+	Genders             []string `json:"genders" validate:"dive,oneof=male female other"`
+	MaxDistanceKm       *int     `json:"maxDistanceKm" validate:"omitempty,min=1,max=20000"`
+	AgeDealbreaker      bool     `json:"ageDealbreaker"`
+	GenderDealbreaker   bool     `json:"genderDealbreaker"`
+	DistanceDealbreaker bool     `json:"distanceDealbreaker"`
+}
+
+func (s *Server) getPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "getPreferencesHandler")
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	preferences, err := s.Store.GetPreferences(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not load preferences", "Handler", "getPreferencesHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "getPreferencesHandler")
+	writeJsonResponse(w, http.StatusOK, preferences)
+}
+
+func (s *Server) setPreferencesHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "setPreferencesHandler")
+
+	preferencesRequest, err := createRequestBodyFromRequest(r, &PreferencesRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "setPreferencesHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("setPreferencesHandler", preferencesRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "setPreferencesHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	if preferencesRequest.MinAge != nil && preferencesRequest.MaxAge != nil && *preferencesRequest.MinAge > *preferencesRequest.MaxAge {
+		slog.Info("minAge is greater than maxAge", "handler", "setPreferencesHandler")
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	preferences, err := s.Store.SetPreferences(r.Context(), userId, db.Preferences{
+		MinAge:              preferencesRequest.MinAge,
+		MaxAge:              preferencesRequest.MaxAge,
+		Genders:             preferencesRequest.Genders,
+		MaxDistanceKm:       preferencesRequest.MaxDistanceKm,
+		AgeDealbreaker:      preferencesRequest.AgeDealbreaker,
+		GenderDealbreaker:   preferencesRequest.GenderDealbreaker,
+		DistanceDealbreaker: preferencesRequest.DistanceDealbreaker,
+	})
+	if err != nil {
+		slog.Info("Could not save preferences", "Handler", "setPreferencesHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "setPreferencesHandler")
+	writeJsonResponse(w, http.StatusOK, preferences)
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/chammond14/muzz/internal/db"
+)
+
+type LocationRequest struct {
+	Lat  float64 `json:"lat" validate:"min=-90,max=90"`
+	Long float64 `json:"long" validate:"min=-180,max=180"`
+}
+
+// maxAge is the oldest date of birth accepted, to catch mistyped years
+const maxAge = 120
+
+type UpdateProfileRequest struct {
+	Name        *string          `json:"name" validate:"omitempty,min=1,max=50"`
+	Gender      *string          `json:"gender" validate:"omitempty,oneof=male female other"`
+	Bio         *string          `json:"bio" validate:"omitempty,max=500"`
+	Visibility  *string          `json:"visibility" validate:"omitempty,oneof=visible paused incognito"`
+	Locale      *string          `json:"locale" validate:"omitempty,bcp47_language_tag"`
+	Location    *LocationRequest `json:"location" validate:"omitempty"`
+	DateOfBirth *string          `json:"dateOfBirth" validate:"omitempty,datetime=2006-01-02"`
+}
+
+// CreateUserResponse is the only response which contains a password, as the generated
+// credentials are otherwise unknown to the caller.
+type CreateUserResponse struct {
+	*db.PrivateProfile
+	Password string `json:"password"`
+}
+
+func (s *Server) getMeHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "getMeHandler")
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	profile, err := s.Store.GetProfile(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not load profile", "Handler", "getMeHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	private := profile.Private()
+	private.Prompts, err = s.Store.GetPromptAnswers(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not load prompt answers", "Handler", "getMeHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "getMeHandler")
+	writeJsonResponse(w, http.StatusOK, private)
+}
+
+func (s *Server) updateMeHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "updateMeHandler")
+
+	updateRequest, err := createRequestBodyFromRequest(r, &UpdateProfileRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "updateMeHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("updateMeHandler", updateRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "updateMeHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	update := db.ProfileUpdate{
+		Name:       updateRequest.Name,
+		Gender:     updateRequest.Gender,
+		Bio:        updateRequest.Bio,
+		Visibility: updateRequest.Visibility,
+		Locale:     updateRequest.Locale,
+	}
+
+	if updateRequest.Location != nil {
+		update.Location = &db.Location{Lat: updateRequest.Location.Lat, Long: updateRequest.Location.Long}
+	}
+
+	if updateRequest.DateOfBirth != nil {
+		dateOfBirth, _ := time.Parse(db.DateOfBirthLayout, *updateRequest.DateOfBirth)
+		now := time.Now()
+		if dateOfBirth.Before(now.AddDate(-maxAge, 0, 0)) {
+			slog.Info("Date of birth is too long ago", "Handler", "updateMeHandler")
+			writeErrorResponse(w, ErrValidationError)
+			return
+		}
+
+		if !db.IsAdult(dateOfBirth, now) {
+			slog.Info("Date of birth is under the minimum age", "Handler", "updateMeHandler")
+			writeErrorResponse(w, db.ErrUnderage)
+			return
+		}
+
+		update.DateOfBirth = &dateOfBirth
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	profile, err := s.Store.UpdateProfile(r.Context(), userId, update)
+	if err != nil {
+		slog.Info("Could not update profile", "Handler", "updateMeHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "updateMeHandler")
+	writeJsonResponse(w, http.StatusOK, profile.Private())
+}
+
+func (s *Server) getProfileHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "getProfileHandler")
+
+	profileId, err := parseUserIdPathValue(r)
+	if err != nil {
+		writeErrorResponse(w, err)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	profile, err := s.Store.GetPublicProfile(r.Context(), userId, profileId)
+	if err != nil {
+		slog.Info("Could not load profile", "Handler", "getProfileHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "getProfileHandler")
+	writeJsonResponse(w, http.StatusOK, profile)
+}
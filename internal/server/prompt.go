@@ -0,0 +1,160 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/chammond14/muzz/internal/db"
+)
+
+const (
+	maxPromptAnswers = 3
+	maxInterests     = 10
+)
+
+type PromptAnswerRequest struct {
+	PromptId string `json:"prompt" validate:"required"`
+	Answer   string `json:"answer" validate:"required,max=300"`
+}
+
+type SetPromptsRequest struct {
+	Answers []PromptAnswerRequest `json:"answers" validate:"max=3,dive"`
+}
+
+type PromptsResponse struct {
+	Results []db.PromptAnswer `json:"results"`
+}
+
+type PromptCatalogueResponse struct {
+	Results []db.Prompt `json:"results"`
+}
+
+type SetInterestsRequest struct {
+	Interests []string `json:"interests" validate:"max=10,dive,min=1,max=30"`
+}
+
+type InterestsResponse struct {
+	Interests []string `json:"interests"`
+}
+
+func (s *Server) promptCatalogueHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "promptCatalogueHandler")
+
+	slog.Info("Request Complete", "Handler", "promptCatalogueHandler")
+	writeJsonResponse(w, http.StatusOK, PromptCatalogueResponse{Results: db.Prompts})
+}
+
+func (s *Server) setPromptsHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "setPromptsHandler")
+
+	promptsRequest, err := createRequestBodyFromRequest(r, &SetPromptsRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "setPromptsHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("setPromptsHandler", promptsRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "setPromptsHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	answers, err := promptAnswers(promptsRequest.Answers)
+	if err != nil {
+		slog.Info("Invalid prompt answers", "Handler", "setPromptsHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	answers, err = s.Store.SetPromptAnswers(r.Context(), userId, answers)
+	if err != nil {
+		slog.Info("Could not save prompt answers", "Handler", "setPromptsHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "setPromptsHandler")
+	writeJsonResponse(w, http.StatusOK, PromptsResponse{Results: answers})
+}
+
+func (s *Server) setInterestsHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "setInterestsHandler")
+
+	interestsRequest, err := createRequestBodyFromRequest(r, &SetInterestsRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "setInterestsHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("setInterestsHandler", interestsRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "setInterestsHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	interests, err := normaliseInterests(interestsRequest.Interests)
+	if err != nil {
+		slog.Info("Invalid interests", "Handler", "setInterestsHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	profile, err := s.Store.UpdateProfile(r.Context(), userId, db.ProfileUpdate{Interests: interests})
+	if err != nil {
+		slog.Info("Could not save interests", "Handler", "setInterestsHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "setInterestsHandler")
+	writeJsonResponse(w, http.StatusOK, InterestsResponse{Interests: profile.Interests})
+}
+
+// promptAnswers checks each answer is to a prompt in the catalogue, answered at most once and not blank.
+func promptAnswers(requests []PromptAnswerRequest) ([]db.PromptAnswer, error) {
+	answers := make([]db.PromptAnswer, 0, len(requests))
+	for _, request := range requests {
+		if _, ok := db.FindPrompt(request.PromptId); !ok {
+			return nil, ErrValidationError
+		}
+
+		if slices.ContainsFunc(answers, func(answer db.PromptAnswer) bool { return answer.PromptId == request.PromptId }) {
+			return nil, ErrValidationError
+		}
+
+		answer := strings.TrimSpace(request.Answer)
+		if answer == "" {
+			return nil, ErrValidationError
+		}
+
+		answers = append(answers, db.PromptAnswer{PromptId: request.PromptId, Answer: answer})
+	}
+
+	return answers, nil
+}
+
+// normaliseInterests lowercases and trims the interests so tags compare equal, dropping duplicates, and rejects
+// blank interests. The result is never nil, so it always replaces the stored interests.
+func normaliseInterests(interests []string) ([]string, error) {
+	normalised := make([]string, 0, len(interests))
+	for _, interest := range interests {
+		interest = strings.ToLower(strings.TrimSpace(interest))
+		if interest == "" {
+			return nil, ErrValidationError
+		}
+
+		if !slices.Contains(normalised, interest) {
+			normalised = append(normalised, interest)
+		}
+	}
+
+	return normalised, nil
+}
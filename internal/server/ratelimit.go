@@ -0,0 +1,28 @@
+package server
+
+import (
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// rateLimit counts the request against the key's window. Once the limit is exceeded it writes a 429 with a
+// Retry-After header and returns false, and the handler must stop.
+func (s *Server) rateLimit(w http.ResponseWriter, r *http.Request, key string, limit int, window time.Duration) bool {
+	allowed, retryAfter, err := s.Store.ConsumeRateLimit(r.Context(), key, limit, window)
+	if err != nil {
+		slog.Info("Could not check rate limit", "key", key, "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return false
+	}
+
+	if !allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		writeErrorResponse(w, ErrTooManyRequests)
+		return false
+	}
+
+	return true
+}
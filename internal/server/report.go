@@ -0,0 +1,157 @@
+package server
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/chammond14/muzz/internal/db"
+)
+
+type ReportRequest struct {
+	UserId  int32  `json:"user" validate:"required"`
+	Reason  string `json:"reason" validate:"required,oneof=spam harassment inappropriate_content fake_profile underage other"`
+	Details string `json:"details" validate:"max=2000"`
+}
+
+type ReportsResponse struct {
+	Results []*db.Report `json:"results"`
+}
+
+type ResolveReportRequest struct {
+	Outcome  string `json:"outcome" validate:"required,oneof=actioned dismissed"`
+	Action   string `json:"action" validate:"required_if=Outcome actioned,omitempty,oneof=warn suspend ban"`
+	Duration string `json:"duration" validate:"required_if=Action suspend"`
+}
+
+func (s *Server) reportHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "reportHandler")
+
+	reportRequest, err := createRequestBodyFromRequest(r, &ReportRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "reportHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("reportHandler", reportRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "reportHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	report, err := s.Store.CreateReport(r.Context(), userId, reportRequest.UserId, reportRequest.Reason, reportRequest.Details)
+	if err != nil {
+		slog.Info("Could not report user", "Handler", "reportHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditReportCreated, &report.ReportedId, map[string]string{"report": strconv.Itoa(report.Id), "reason": report.Reason})
+
+	slog.Info("Request Complete", "Handler", "reportHandler")
+	writeJsonResponse(w, http.StatusOK, report)
+}
+
+func (s *Server) listReportsHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "listReportsHandler")
+
+	status := r.URL.Query().Get("status")
+	if status != "" && status != db.ReportStatusOpen && status != db.ReportStatusActioned && status != db.ReportStatusDismissed {
+		slog.Info("Unknown report status", "Handler", "listReportsHandler", "status", status)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	reports, err := s.Store.GetReports(r.Context(), status)
+	if err != nil {
+		slog.Info("Could not load reports", "Handler", "listReportsHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "listReportsHandler")
+	writeJsonResponse(w, http.StatusOK, ReportsResponse{Results: reports})
+}
+
+func (s *Server) claimReportHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "claimReportHandler")
+
+	reportId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	report, err := s.Store.ClaimReport(r.Context(), reportId, userId)
+	if err != nil {
+		slog.Info("Could not claim report", "Handler", "claimReportHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditReportClaimed, &report.ReportedId, map[string]string{"report": strconv.Itoa(report.Id)})
+
+	slog.Info("Request Complete", "Handler", "claimReportHandler")
+	writeJsonResponse(w, http.StatusOK, report)
+}
+
+func (s *Server) resolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "resolveReportHandler")
+
+	reportId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	resolveRequest, err := createRequestBodyFromRequest(r, &ResolveReportRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "resolveReportHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("resolveReportHandler", resolveRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "resolveReportHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	resolution := db.Resolution{Status: resolveRequest.Outcome}
+	if resolveRequest.Outcome == db.ReportStatusActioned {
+		resolution.Action = resolveRequest.Action
+	}
+
+	if resolution.Action == db.ReportActionSuspend {
+		resolution.SuspendFor, err = time.ParseDuration(resolveRequest.Duration)
+		if err != nil || resolution.SuspendFor <= 0 {
+			slog.Info("Invalid suspension duration", "Handler", "resolveReportHandler", "duration", resolveRequest.Duration)
+			writeErrorResponse(w, ErrValidationError)
+			return
+		}
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	report, err := s.Store.ResolveReport(r.Context(), reportId, userId, resolution)
+	if err != nil {
+		slog.Info("Could not resolve report", "Handler", "resolveReportHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditReportResolved, &report.ReportedId, map[string]string{
+		"report":   strconv.Itoa(report.Id),
+		"outcome":  resolution.Status,
+		"action":   resolution.Action,
+		"duration": resolveRequest.Duration,
+	})
+
+	slog.Info("Request Complete", "Handler", "resolveReportHandler")
+	writeJsonResponse(w, http.StatusOK, report)
+}
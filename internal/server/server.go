@@ -1,14 +1,27 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log/slog"
 	"math/rand/v2"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/0x6flab/namegenerator"
+	"github.com/chammond14/muzz/internal/blob"
 	"github.com/chammond14/muzz/internal/db"
+	"github.com/chammond14/muzz/internal/events"
+	"github.com/chammond14/muzz/internal/mail"
+	"github.com/chammond14/muzz/internal/notify"
+	"github.com/chammond14/muzz/internal/oidc"
+	"github.com/chammond14/muzz/internal/sms"
+	"github.com/chammond14/muzz/internal/webhooks"
 	"github.com/go-playground/validator/v10"
 )
 
@@ -19,28 +32,120 @@ type ServerError struct {
 type ServerHandler func(http.ResponseWriter, *http.Request)
 
 type Server struct {
-	Validate  *validator.Validate
-	Generator namegenerator.NameGenerator
-	Store     db.ProfileStore
+	Validate       *validator.Validate
+	Generator      namegenerator.NameGenerator
+	Store          db.ProfileStore
+	Blobs          blob.Store
+	AuditRetention time.Duration
+	MaxPhotoBytes  int64
+	// PhotoMatchDistance is the largest perceptual hash distance at which photos on different profiles are flagged
+	PhotoMatchDistance int
+	// DeletionGracePeriod is how long a deleted account waits before it is purged, during which logging in restores it
+	DeletionGracePeriod time.Duration
+	// ExportSigningKey signs data export download links, which are valid for ExportURLTTL
+	ExportSigningKey []byte
+	ExportURLTTL     time.Duration
+	// MediaSigningKey signs photo links, which are valid for between one and two MediaURLTTL
+	MediaSigningKey []byte
+	MediaURLTTL     time.Duration
+	// EventSinks receive every event written to the outbox. Bus, when set, should be one of them, and delivers
+	// events to subscribers within the app.
+	EventSinks []events.Sink
+	Bus        *events.Bus
+	// NotificationProviders send push notifications to devices, keyed by platform
+	NotificationProviders map[string]notify.Provider
+	// LikeDigestWindow is how long likes are collected before they are sent as one notification
+	LikeDigestWindow time.Duration
+	// Mailer sends the email queued in the mail outbox, rendered from MailTemplates
+	Mailer        mail.Mailer
+	MailTemplates *mail.Templates
+	// PasswordResetURL is the page reset links open, with the token added as a query parameter. Links are valid
+	// for PasswordResetTTL.
+	PasswordResetURL string
+	PasswordResetTTL time.Duration
+	// PublicURL is where the service is reached from outside, used for links in emails
+	PublicURL string
+	// EmailVerificationKey signs email verification links, which are valid for EmailVerificationTTL
+	EmailVerificationKey []byte
+	EmailVerificationTTL time.Duration
+	// UnverifiedRestrictions lists the actions, such as ActionSwipe, which need a verified email
+	UnverifiedRestrictions []string
+	// DiscoverVerifiedFirst ranks profiles with a verified email ahead of the rest in discover
+	DiscoverVerifiedFirst bool
+	// LoginChallengeTTL is how long a user with two factor authentication has to enter a code after their password
+	LoginChallengeTTL time.Duration
+	// OIDCProviders are the OpenID Connect providers users can log in with, by name
+	OIDCProviders map[string]*oidc.Provider
+	// SMSProvider sends the codes used to log in with a phone number
+	SMSProvider sms.Provider
+	// ReadTimeout, WriteTimeout and IdleTimeout configure the HTTP server. ShutdownTimeout is how long in-flight
+	// requests and background work have to finish once the server is stopped.
+	ReadTimeout     time.Duration
+	WriteTimeout    time.Duration
+	IdleTimeout     time.Duration
+	ShutdownTimeout time.Duration
+	// ShutdownDelay is how long the server keeps accepting requests after it is stopped, while /readyz reports it is
+	// draining, so a load balancer has time to stop routing requests to it
+	ShutdownDelay time.Duration
+
+	// tasks tracks work started by a request which outlives it, such as building an export
+	tasks sync.WaitGroup
+	// draining is set once the server has been stopped
+	draining atomic.Bool
 }
 
+const (
+	defaultReadTimeout     = 15 * time.Second
+	defaultWriteTimeout    = 60 * time.Second
+	defaultIdleTimeout     = 2 * time.Minute
+	defaultShutdownTimeout = 30 * time.Second
+
+	// login attempts for each username, and from each address, per hour
+	loginLimit   = 10
+	loginIPLimit = 50
+)
+
 var genders = []string{"male", "female", "other"}
 
+// randomDateOfBirth returns a date of birth for a generated profile, aged from db.MinimumAge to 99.
+func randomDateOfBirth(now time.Time) time.Time {
+	// going back up to 364 more days keeps the age below the next birthday
+	return dateOfBirthAged(now, db.MinimumAge+rand.IntN(100-db.MinimumAge), rand.IntN(365))
+}
+
+// dateOfBirthAged returns the date of birth of someone who turned years old the given number of days before now.
+// A birthday which doesn't exist that year, like 29 February, falls on the last day of the month instead, since
+// normalising it to 1 March would leave them a day short of the age.
+func dateOfBirthAged(now time.Time, years int, days int) time.Time {
+	year, month, day := now.Date()
+	lastDay := time.Date(year-years, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+
+	return time.Date(year-years, month, min(day, lastDay), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -days)
+}
+
 type LoginRequest struct {
 	Username string `json:"username" validate:"required"`
 	Password string `json:"password" validate:"required"`
 }
 
+// LoginResponse contains a session token, or when the user has two factor authentication on, a challenge to
+// complete at /login/2fa.
 type LoginResponse struct {
-	Token string `json:"token"`
+	Token              string     `json:"token,omitempty"`
+	Challenge          string     `json:"challenge,omitempty"`
+	ChallengeExpiresAt *time.Time `json:"challengeExpiresAt,omitempty"`
 }
 
+// DiscoverRequest filters override the user's stored preferences, except where a preference is a dealbreaker.
 type DiscoverRequest struct {
-	MinAge  int      `json:"minAge" validate:"omitempty,min=18,max=150"`
-	MaxAge  int      `json:"maxAge" validate:"omitempty,min=18,max=150"`
-	Genders []string `json:"genders" validate:"dive,oneof=male female other"`
-	Lat     float64  `json:"lat" validate:"required"`
-	Long    float64  `json:"long" validate:"required"`
+	MinAge      int      `json:"minAge" validate:"omitempty,min=18,max=150"`
+	MaxAge      int      `json:"maxAge" validate:"omitempty,min=18,max=150"`
+	Genders     []string `json:"genders" validate:"dive,oneof=male female other"`
+	MaxDistance int      `json:"maxDistance" validate:"omitempty,min=1,max=20000"`
+	Mutual      bool     `json:"mutual"`
+	Interests   []string `json:"interests" validate:"max=10,dive,min=1,max=30"`
+	Lat         float64  `json:"lat" validate:"required"`
+	Long        float64  `json:"long" validate:"required"`
 }
 
 type DiscoverResponse struct {
@@ -74,15 +179,53 @@ func (s *Server) loginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sessionToken, err := s.Store.Login(r.Context(), loginRequest.Username, loginRequest.Password)
+	if !s.rateLimit(w, r, "login-ip:"+clientIP(r), loginIPLimit, time.Hour) ||
+		!s.rateLimit(w, r, "login:"+strings.ToLower(loginRequest.Username), loginLimit, time.Hour) {
+		return
+	}
+
+	userId, twoFactor, err := s.Store.CheckCredentials(r.Context(), loginRequest.Username, loginRequest.Password)
 	if err != nil {
 		slog.Info("Could not login user", "user", loginRequest.Username, "error", err)
+		if err == db.ErrLoginFailed {
+			s.recordAuditAs(r, nil, AuditLoginFailed, nil, map[string]string{"username": loginRequest.Username})
+		}
+
 		writeErrorResponse(w, err)
 		return
 	}
 
-	slog.Info("Request Complete", "Handler", "loginHandler")
-	writeJsonResponse(w, http.StatusOK, LoginResponse{Token: sessionToken})
+	s.completeLogin(w, r, "loginHandler", userId, twoFactor, nil)
+}
+
+// completeLogin starts a session for a user who has passed the first factor, or when they have two factor
+// authentication on, returns a challenge to complete at /login/2fa.
+func (s *Server) completeLogin(w http.ResponseWriter, r *http.Request, handler string, userId int32, twoFactor bool, details map[string]string) {
+	if twoFactor {
+		expiresAt := time.Now().UTC().Add(s.loginChallengeTTL())
+		challenge, err := s.startLoginChallenge(r.Context(), userId)
+		if err != nil {
+			slog.Info("Could not start login challenge", "Handler", handler, "user", userId, "error", err)
+			writeErrorResponse(w, ErrUnexpectedError)
+			return
+		}
+
+		slog.Info("Request Complete", "Handler", handler, "twoFactor", true)
+		writeJsonResponse(w, http.StatusOK, LoginResponse{Challenge: challenge, ChallengeExpiresAt: &expiresAt})
+		return
+	}
+
+	session, err := s.Store.StartSession(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not start session", "Handler", handler, "user", userId, "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAuditAs(r, &session.UserId, AuditLoginSucceeded, &session.UserId, details)
+
+	slog.Info("Request Complete", "Handler", handler)
+	writeJsonResponse(w, http.StatusOK, LoginResponse{Token: session.Token})
 }
 
 func (s *Server) createUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -91,19 +234,22 @@ func (s *Server) createUserHandler(w http.ResponseWriter, r *http.Request) {
 	name := s.Generator.Generate()
 	email := fmt.Sprintf("%s@muzz.com", name)
 	password := s.Generator.Generate()
-	age := rand.IntN(100)
 	gender := genders[rand.IntN(len(genders))]
 	location := db.Location{Lat: -0.08768348444653988, Long: 51.508050972200834}
 
-	profile, err := s.Store.CreateProfile(r.Context(), age, name, gender, email, password, location)
+	profile, err := s.Store.CreateProfile(r.Context(), randomDateOfBirth(time.Now()), name, gender, email, password, location)
 	if err != nil {
 		slog.Info("Error creating profile", "error", err)
 		writeErrorResponse(w, err)
 		return
 	}
 
+	if err := s.sendVerificationEmail(r.Context(), profile.Id, profile.Email); err != nil {
+		slog.Info("Could not send verification email", "Handler", "createUserHandler", "error", err)
+	}
+
 	slog.Info("Request Complete", "Handler", "createUserHandler")
-	writeJsonResponse(w, http.StatusOK, profile)
+	writeJsonResponse(w, http.StatusOK, CreateUserResponse{PrivateProfile: profile.Private(), Password: password})
 }
 
 func (s *Server) discoverHandler(w http.ResponseWriter, r *http.Request) {
@@ -123,22 +269,51 @@ func (s *Server) discoverHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	interests, err := normaliseInterests(discoverRequest.Interests)
+	if err != nil {
+		slog.Info("Invalid interests", "Handler", "discoverHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
 	userId := r.Context().Value(contextKeyUserId).(int32)
+	userLocation := db.Location{Lat: discoverRequest.Lat, Long: discoverRequest.Long}
 	dbFilters := &db.DiscoverFilters{
-		Genders: discoverRequest.Genders,
-		MaxAge:  discoverRequest.MaxAge,
-		MinAge:  discoverRequest.MinAge,
+		Genders:       discoverRequest.Genders,
+		MaxAge:        discoverRequest.MaxAge,
+		MinAge:        discoverRequest.MinAge,
+		MaxDistanceKm: discoverRequest.MaxDistance,
+		Location:      userLocation,
+		Mutual:        discoverRequest.Mutual,
+		Interests:     interests,
 	}
 
-	discoverResults, err := s.Store.GetDiscoverProfiles(r.Context(), userId, *dbFilters)
+	preferences, err := s.Store.GetPreferences(r.Context(), userId)
 	if err != nil {
-		slog.Info("Could not load discover profiles", "Handler", "discoverHandler", "error", err)
+		slog.Info("Could not load preferences", "Handler", "discoverHandler", "error", err)
 		writeErrorResponse(w, ErrUnexpectedError)
 		return
 	}
 
-	userLocation := db.Location{Lat: discoverRequest.Lat, Long: discoverRequest.Long}
-	sortProfilesByLocation(discoverResults, userLocation)
+	discoverResults := []*db.DiscoverProfile{}
+	if applyPreferences(dbFilters, preferences) {
+		discoverResults, err = s.Store.GetDiscoverProfiles(r.Context(), userId, *dbFilters)
+		if err != nil {
+			slog.Info("Could not load discover profiles", "Handler", "discoverHandler", "error", err)
+			writeErrorResponse(w, ErrUnexpectedError)
+			return
+		}
+	}
+
+	profile, err := s.Store.GetProfile(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not load profile", "Handler", "discoverHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	sortProfilesByLocation(discoverResults, userLocation, profile.Interests, s.DiscoverVerifiedFirst)
+	s.addDiscoverPhotos(discoverResults)
 
 	slog.Info("Request Complete", "Handler", "discoverHandler")
 	writeJsonResponse(w, http.StatusOK, DiscoverResponse{Results: discoverResults})
@@ -178,18 +353,188 @@ func (s *Server) swipeHandler(w http.ResponseWriter, r *http.Request) {
 	writeJsonResponse(w, http.StatusOK, response)
 }
 
-func (s *Server) Start(addr string) {
-
+// Start serves requests on addr until ctx is cancelled. It then stops accepting connections, waits up to
+// ShutdownTimeout for in-flight requests and background work to finish, and stops the workers. The error is nil
+// only when everything finished in time.
+func (s *Server) Start(ctx context.Context, addr string) error {
 	mux := http.NewServeMux()
 
+	mux.HandleFunc("GET /healthz", s.healthzHandler)
+	mux.HandleFunc("GET /readyz", s.readyzHandler)
 	mux.HandleFunc("GET /user/create", s.createUserHandler)
 	mux.HandleFunc("POST /login", s.loginHandler)
-	mux.HandleFunc("POST /discover", s.authenticate(s.discoverHandler))
-	mux.HandleFunc("POST /swipe", s.authenticate(s.swipeHandler))
+	mux.HandleFunc("POST /password/forgot", s.forgotPasswordHandler)
+	mux.HandleFunc("POST /password/reset", s.resetPasswordHandler)
+	mux.HandleFunc("POST /login/2fa", s.loginTwoFactorHandler)
+	mux.HandleFunc("POST /phone/otp", s.sendPhoneCodeHandler)
+	mux.HandleFunc("POST /phone/login", s.phoneLoginHandler)
+	mux.HandleFunc("PUT /me/phone", s.authenticate(s.setPhoneHandler))
+	mux.HandleFunc("GET /auth/{provider}", s.oauthStartHandler)
+	mux.HandleFunc("GET /auth/{provider}/callback", s.oauthCallbackHandler)
+	mux.HandleFunc("GET /me/identities", s.authenticate(s.listIdentitiesHandler))
+	mux.HandleFunc("POST /me/identities/{provider}", s.authenticate(s.linkIdentityHandler))
+	mux.HandleFunc("DELETE /me/identities/{provider}", s.authenticate(s.unlinkIdentityHandler))
+	mux.HandleFunc("POST /me/2fa/setup", s.authenticate(s.setupTwoFactorHandler))
+	mux.HandleFunc("POST /me/2fa/confirm", s.authenticate(s.confirmTwoFactorHandler))
+	mux.HandleFunc("DELETE /me/2fa", s.authenticate(s.disableTwoFactorHandler))
+	mux.HandleFunc("GET /verify-email", s.verifyEmailHandler)
+	mux.HandleFunc("POST /me/verify-email", s.authenticate(s.resendVerificationHandler))
+	mux.HandleFunc("POST /discover", s.authenticate(s.requireVerified(ActionDiscover, s.discoverHandler)))
+	mux.HandleFunc("POST /swipe", s.authenticate(s.requireVerified(ActionSwipe, s.swipeHandler)))
+	mux.HandleFunc("GET /me", s.authenticate(s.getMeHandler))
+	mux.HandleFunc("PATCH /me", s.authenticate(s.updateMeHandler))
+	mux.HandleFunc("DELETE /me", s.authenticate(s.deleteMeHandler))
+	mux.HandleFunc("POST /me/export", s.authenticate(s.createExportHandler))
+	mux.HandleFunc("GET /me/export/{id}", s.authenticate(s.getExportHandler))
+	mux.HandleFunc("GET /exports/{id}", s.downloadExportHandler)
+	mux.HandleFunc("POST /me/devices", s.authenticate(s.registerDeviceHandler))
+	mux.HandleFunc("DELETE /me/devices/{token}", s.authenticate(s.deleteDeviceHandler))
+	mux.HandleFunc("GET /me/notification-preferences", s.authenticate(s.getNotificationPreferencesHandler))
+	mux.HandleFunc("PUT /me/notification-preferences", s.authenticate(s.setNotificationPreferencesHandler))
+	mux.HandleFunc("GET /me/preferences", s.authenticate(s.getPreferencesHandler))
+	mux.HandleFunc("PUT /me/preferences", s.authenticate(s.setPreferencesHandler))
+	mux.HandleFunc("PUT /me/prompts", s.authenticate(s.setPromptsHandler))
+	mux.HandleFunc("PUT /me/interests", s.authenticate(s.setInterestsHandler))
+	mux.HandleFunc("GET /prompts", s.authenticate(s.promptCatalogueHandler))
+	mux.HandleFunc("GET /me/photos", s.authenticate(s.listPhotosHandler))
+	mux.HandleFunc("POST /me/photos", s.authenticate(s.requireVerified(ActionPhotos, s.uploadPhotoHandler)))
+	mux.HandleFunc("PUT /me/photos/order", s.authenticate(s.reorderPhotosHandler))
+	mux.HandleFunc("PUT /me/photos/{id}/primary", s.authenticate(s.setPrimaryPhotoHandler))
+	mux.HandleFunc("DELETE /me/photos/{id}", s.authenticate(s.deletePhotoHandler))
+	mux.HandleFunc("GET /media/{key...}", s.mediaHandler)
+	mux.HandleFunc("GET /profiles/{id}", s.authenticate(s.getProfileHandler))
+	mux.HandleFunc("POST /reports", s.authenticate(s.reportHandler))
+	mux.HandleFunc("GET /admin/reports", s.authenticate(s.authorize(PermissionModerateReports, s.listReportsHandler)))
+	mux.HandleFunc("POST /admin/reports/{id}/claim", s.authenticate(s.authorize(PermissionModerateReports, s.claimReportHandler)))
+	mux.HandleFunc("POST /admin/reports/{id}/resolve", s.authenticate(s.authorize(PermissionModerateReports, s.resolveReportHandler)))
+	mux.HandleFunc("GET /admin/users", s.authenticate(s.authorize(PermissionSearchUsers, s.searchUsersHandler)))
+	mux.HandleFunc("GET /admin/users/{id}", s.authenticate(s.authorize(PermissionViewProfiles, s.getUserHandler)))
+	mux.HandleFunc("POST /admin/users/{id}/logout", s.authenticate(s.authorize(PermissionRevokeSessions, s.forceLogoutHandler)))
+	mux.HandleFunc("PUT /admin/users/{id}/role", s.authenticate(s.authorize(PermissionAssignRoles, s.assignRoleHandler)))
+	mux.HandleFunc("GET /admin/photo-matches", s.authenticate(s.authorize(PermissionReviewPhotos, s.listPhotoMatchesHandler)))
+	mux.HandleFunc("POST /admin/photo-matches/{id}/resolve", s.authenticate(s.authorize(PermissionReviewPhotos, s.resolvePhotoMatchHandler)))
+	mux.HandleFunc("GET /admin/photos/{id}/similar", s.authenticate(s.authorize(PermissionReviewPhotos, s.similarPhotosHandler)))
+	mux.HandleFunc("GET /admin/webhooks", s.authenticate(s.authorize(PermissionManageWebhooks, s.listWebhooksHandler)))
+	mux.HandleFunc("POST /admin/webhooks", s.authenticate(s.authorize(PermissionManageWebhooks, s.createWebhookHandler)))
+	mux.HandleFunc("DELETE /admin/webhooks/{id}", s.authenticate(s.authorize(PermissionManageWebhooks, s.deleteWebhookHandler)))
+	mux.HandleFunc("GET /admin/webhooks/{id}/deliveries", s.authenticate(s.authorize(PermissionManageWebhooks, s.webhookDeliveriesHandler)))
+	mux.HandleFunc("POST /admin/webhook-deliveries/{id}/retry", s.authenticate(s.authorize(PermissionManageWebhooks, s.retryWebhookDeliveryHandler)))
+	mux.HandleFunc("GET /admin/jobs", s.authenticate(s.authorize(PermissionViewJobs, s.listJobsHandler)))
+	mux.HandleFunc("GET /admin/audit", s.authenticate(s.authorize(PermissionViewAuditLog, s.auditLogHandler)))
+
+	runner, err := s.jobRunner()
+	if err != nil {
+		return fmt.Errorf("could not schedule jobs: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	// workers are stopped once requests have drained, as requests queue work for them
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	startWorker := func(run func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(workerCtx)
+		}()
+	}
+
+	startWorker(runner.Run)
+	if s.Bus != nil {
+		s.Bus.Subscribe(events.AllTypes, s.enqueueWebhookDeliveries)
+		s.Bus.Subscribe(events.TypeMatchCreated, s.notifyMatch)
+		s.Bus.Subscribe(events.TypeSwipeLiked, s.notifyLike)
+	}
+
+	startWorker(events.NewRelay(s.Store, s.EventSinks...).Run)
+	startWorker(webhooks.NewDispatcher(s.Store).Run)
+	startWorker(notify.NewDispatcher(s.Store, s.NotificationProviders).Run)
+	if s.Mailer != nil {
+		startWorker(mail.NewSender(s.Store, s.Mailer, s.MailTemplates).Run)
+	}
+
+	httpServer := s.httpServer(mux)
+	served := make(chan error, 1)
+	go func() {
+		served <- httpServer.Serve(listener)
+	}()
+
+	slog.Info("Running on port", "ADDRESS", listener.Addr().String())
+	select {
+	case err = <-served:
+		slog.Error("Server stopped listening", "error", err)
+	case <-ctx.Done():
+		err = s.drain(httpServer)
+	}
+
+	stopWorkers()
+	workers.Wait()
+
+	slog.Info("Server stopped", "error", err)
+	return err
+}
+
+func (s *Server) httpServer(handler http.Handler) *http.Server {
+	httpServer := &http.Server{
+		Handler:      handler,
+		ReadTimeout:  s.ReadTimeout,
+		WriteTimeout: s.WriteTimeout,
+		IdleTimeout:  s.IdleTimeout,
+	}
+
+	if httpServer.ReadTimeout <= 0 {
+		httpServer.ReadTimeout = defaultReadTimeout
+	}
+
+	if httpServer.WriteTimeout <= 0 {
+		httpServer.WriteTimeout = defaultWriteTimeout
+	}
+
+	if httpServer.IdleTimeout <= 0 {
+		httpServer.IdleTimeout = defaultIdleTimeout
+	}
+
+	return httpServer
+}
+
+// drain reports the server as draining for ShutdownDelay, then stops it accepting connections and waits for in-flight
+// requests, then background tasks, to finish. Connections still open after ShutdownTimeout are closed.
+func (s *Server) drain(httpServer *http.Server) error {
+	s.draining.Store(true)
+	if s.ShutdownDelay > 0 {
+		slog.Info("Shutting down, reporting not ready", "delay", s.ShutdownDelay)
+		time.Sleep(s.ShutdownDelay)
+	}
+
+	timeout := s.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
+	slog.Info("Shutting down, draining requests", "timeout", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := httpServer.Shutdown(ctx); err != nil {
+		httpServer.Close()
+		return fmt.Errorf("could not drain requests: %w", err)
+	}
 
-	slog.Info("Running on port", "ADDRESS", addr)
-	if err := http.ListenAndServe(addr, mux); err != nil {
-		slog.Info("Server stopped listening", "error", err)
+	tasksDone := make(chan struct{})
+	go func() {
+		s.tasks.Wait()
+		close(tasksDone)
+	}()
+
+	select {
+	case <-tasksDone:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("could not finish background tasks: %w", ctx.Err())
 	}
 }
 
@@ -221,10 +566,26 @@ func writeErrorResponse(w http.ResponseWriter, err error) {
 	switch err {
 	case ErrValidationError:
 		status = http.StatusBadRequest
-	case ErrMustBeLoggedIn:
+	case ErrMustBeLoggedIn, db.ErrLoginChallengeInvalid, ErrSignInFailed:
 		status = http.StatusUnauthorized
-	case ErrInvalidRequest:
+	case ErrInvalidRequest, db.ErrReportInvalid, db.ErrPhotoOrderInvalid, db.ErrResetTokenInvalid, db.ErrTwoFactorNotSetUp,
+		db.ErrTwoFactorCodeInvalid, db.ErrOAuthStateInvalid, ErrIdentityEmailRequired, db.ErrPhoneCodeInvalid, sms.ErrInvalidPhone,
+		db.ErrUnderage:
 		status = http.StatusBadRequest
+	case ErrForbidden, ErrExportLinkExpired, ErrEmailNotVerified, ErrVerificationLinkExpired, db.ErrAccountSuspended, db.ErrAccountBanned, db.ErrProfilePaused, db.ErrPasswordIncorrect:
+		status = http.StatusForbidden
+	case db.ErrReportNotFound, db.ErrProfileNotFound, db.ErrPhotoNotFound, db.ErrPhotoMatchNotFound, db.ErrExportNotFound,
+		db.ErrWebhookNotFound, db.ErrWebhookDeliveryNotFound, db.ErrDeviceNotFound, db.ErrIdentityNotFound, ErrProviderNotFound:
+		status = http.StatusNotFound
+	case db.ErrReportNotClaimable, db.ErrPhotoLimitReached, db.ErrEmailAlreadyVerified, db.ErrTwoFactorEnabled,
+		db.ErrIdentityLinked, db.ErrIdentityEmailInUse, db.ErrPhoneInUse:
+		status = http.StatusConflict
+	case ErrPhotoTooLarge:
+		status = http.StatusRequestEntityTooLarge
+	case ErrTooManyRequests:
+		status = http.StatusTooManyRequests
+	case ErrUnsupportedMediaType:
+		status = http.StatusUnsupportedMediaType
 	default:
 		status = http.StatusInternalServerError
 	}
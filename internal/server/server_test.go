@@ -1,14 +1,27 @@
 package server
 
 import (
+	"archive/zip"
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/chammond14/muzz/internal/db"
+	"github.com/chammond14/muzz/internal/notify"
+	"github.com/chammond14/muzz/internal/oidc"
+	"github.com/go-playground/validator/v10"
 )
 
 func Test_loginHandlerReturnsErrorWhenInvalidCredentials(t *testing.T) {
@@ -201,6 +214,67 @@ func Test_discoverHandlerReturnsProfiles(t *testing.T) {
 	}
 }
 
+// createPreferencesProfile creates a visible profile which only wants to see the given genders.
+func createPreferencesProfile(t *testing.T, genders []string) int32 {
+	userId := createVisibilityProfile(t, db.VisibilityVisible)
+	if _, err := TestServer.Store.SetPreferences(context.Background(), userId, db.Preferences{Genders: genders}); err != nil {
+		t.Fatal("Unexpected error setting preferences", err)
+	}
+
+	return userId
+}
+
+func Test_discoverHandlerMutualAppliesCandidatePreferences(t *testing.T) {
+	// profiles are created with the "other" gender
+	viewer := createVisibilityProfile(t, db.VisibilityVisible)
+	excludesViewer := createPreferencesProfile(t, []string{"male"})
+	includesViewer := createPreferencesProfile(t, []string{"other"})
+	noPreferences := createVisibilityProfile(t, db.VisibilityVisible)
+
+	tests := map[string]struct {
+		profile int32
+		mutual  bool
+		shown   bool
+	}{
+		"excludes viewer":            {profile: excludesViewer, mutual: false, shown: true},
+		"excludes viewer and mutual": {profile: excludesViewer, mutual: true, shown: false},
+		"includes viewer":            {profile: includesViewer, mutual: false, shown: true},
+		"includes viewer and mutual": {profile: includesViewer, mutual: true, shown: true},
+		"no preferences":             {profile: noPreferences, mutual: false, shown: true},
+		"no preferences and mutual":  {profile: noPreferences, mutual: true, shown: true},
+	}
+
+	for name, test := range tests {
+		var body bytes.Buffer
+		if err := json.NewEncoder(&body).Encode(&DiscoverRequest{Mutual: test.mutual, Lat: -0.123, Long: 123}); err != nil {
+			t.Fatal("Unexpected error encoding json", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/discover", &body)
+		req = req.WithContext(context.WithValue(req.Context(), contextKeyUserId, viewer))
+		res := httptest.NewRecorder()
+
+		TestServer.discoverHandler(res, req)
+
+		resBody := &DiscoverResponse{}
+		if err := json.NewDecoder(res.Body).Decode(resBody); err != nil {
+			t.Error("Unexpected error decoding json", err)
+		}
+
+		if res.Code != http.StatusOK {
+			t.Errorf("Expected 200 for %s but got %d", name, res.Code)
+		}
+
+		shown := slices.ContainsFunc(resBody.Results, func(profile *db.DiscoverProfile) bool {
+			return profile.Id == test.profile
+		})
+
+		if shown != test.shown {
+			t.Errorf("Expected %s profile shown to be %v but got %v", name, test.shown, shown)
+		}
+	}
+}
+
 func Test_swipeHandlerReturnsNoMatchIdWhenNoMatch(t *testing.T) {
 	body := &SwipeRequest{UserId: 1, Liked: true}
 	var bytes bytes.Buffer
@@ -271,13 +345,124 @@ func Test_swipeHandlerReturnsMatchIdWhenMatch(t *testing.T) {
 	}
 }
 
+// createVisibilityProfile creates a profile with the given visibility.
+func createVisibilityProfile(t *testing.T, visibility string) int32 {
+	ctx := context.Background()
+	email := fmt.Sprintf("visibility-%d@muzz.test", time.Now().UnixNano())
+	profile, err := TestServer.Store.CreateProfile(ctx, randomDateOfBirth(time.Now()), "Visibility", "other", email, "password", db.Location{})
+	if err != nil {
+		t.Fatal("Unexpected error creating profile", err)
+	}
+
+	if _, err := TestServer.Store.UpdateProfile(ctx, profile.Id, db.ProfileUpdate{Visibility: &visibility}); err != nil {
+		t.Fatal("Unexpected error setting visibility", err)
+	}
+
+	return profile.Id
+}
+
+// banProfile bans a profile the way a moderator would, by resolving a report against it.
+func banProfile(t *testing.T, userId int32) int32 {
+	ctx := context.Background()
+	moderator := createVisibilityProfile(t, db.VisibilityVisible)
+	report, err := TestServer.Store.CreateReport(ctx, moderator, userId, "spam", "")
+	if err != nil {
+		t.Fatal("Unexpected error reporting profile", err)
+	}
+
+	if _, err := TestServer.Store.ClaimReport(ctx, report.Id, moderator); err != nil {
+		t.Fatal("Unexpected error claiming report", err)
+	}
+
+	resolution := db.Resolution{Status: db.ReportStatusActioned, Action: db.ReportActionBan}
+	if _, err := TestServer.Store.ResolveReport(ctx, report.Id, moderator, resolution); err != nil {
+		t.Fatal("Unexpected error banning profile", err)
+	}
+
+	return userId
+}
+
+func Test_getProfileHandlerHidesProfilesDiscoverWouldNotShow(t *testing.T) {
+	viewer := createVisibilityProfile(t, db.VisibilityVisible)
+
+	likedViewer := createVisibilityProfile(t, db.VisibilityIncognito)
+	if _, _, err := TestServer.Store.Swipe(context.Background(), likedViewer, viewer, true); err != nil {
+		t.Fatal("Unexpected error liking viewer", err)
+	}
+
+	paused := createVisibilityProfile(t, db.VisibilityPaused)
+
+	tests := map[string]struct {
+		viewer  int32
+		profile int32
+		code    int
+	}{
+		"visible":               {viewer: viewer, profile: createVisibilityProfile(t, db.VisibilityVisible), code: http.StatusOK},
+		"paused":                {viewer: viewer, profile: createVisibilityProfile(t, db.VisibilityPaused), code: http.StatusNotFound},
+		"incognito":             {viewer: viewer, profile: createVisibilityProfile(t, db.VisibilityIncognito), code: http.StatusNotFound},
+		"incognito and liked":   {viewer: viewer, profile: likedViewer, code: http.StatusOK},
+		"banned":                {viewer: viewer, profile: banProfile(t, createVisibilityProfile(t, db.VisibilityVisible)), code: http.StatusNotFound},
+		"paused viewing itself": {viewer: paused, profile: paused, code: http.StatusOK},
+	}
+
+	for name, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/profiles/%d", test.profile), nil)
+		req.SetPathValue("id", strconv.Itoa(int(test.profile)))
+		req = req.WithContext(context.WithValue(req.Context(), contextKeyUserId, test.viewer))
+		res := httptest.NewRecorder()
+
+		TestServer.getProfileHandler(res, req)
+
+		if res.Code != test.code {
+			t.Errorf("Expected %d for %s profile but got %d", test.code, name, res.Code)
+		}
+	}
+}
+
+func Test_swipeHandlerRejectsProfilesDiscoverWouldNotShow(t *testing.T) {
+	swiper := createVisibilityProfile(t, db.VisibilityVisible)
+
+	likedSwiper := createVisibilityProfile(t, db.VisibilityIncognito)
+	if _, _, err := TestServer.Store.Swipe(context.Background(), likedSwiper, swiper, true); err != nil {
+		t.Fatal("Unexpected error liking swiper", err)
+	}
+
+	tests := map[string]struct {
+		profile int32
+		code    int
+	}{
+		"visible":             {profile: createVisibilityProfile(t, db.VisibilityVisible), code: http.StatusOK},
+		"paused":              {profile: createVisibilityProfile(t, db.VisibilityPaused), code: http.StatusNotFound},
+		"incognito":           {profile: createVisibilityProfile(t, db.VisibilityIncognito), code: http.StatusNotFound},
+		"incognito and liked": {profile: likedSwiper, code: http.StatusOK},
+		"banned":              {profile: banProfile(t, createVisibilityProfile(t, db.VisibilityVisible)), code: http.StatusNotFound},
+	}
+
+	for name, test := range tests {
+		var body bytes.Buffer
+		if err := json.NewEncoder(&body).Encode(&SwipeRequest{UserId: test.profile, Liked: true}); err != nil {
+			t.Fatal("Unexpected error encoding json", err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/swipe", &body)
+		req = req.WithContext(context.WithValue(req.Context(), contextKeyUserId, swiper))
+		res := httptest.NewRecorder()
+
+		TestServer.swipeHandler(res, req)
+
+		if res.Code != test.code {
+			t.Errorf("Expected %d when swiping on %s profile but got %d", test.code, name, res.Code)
+		}
+	}
+}
+
 func Test_createUserHandlerReturnsProfile(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/user/create", nil)
 	res := httptest.NewRecorder()
 
 	TestServer.createUserHandler(res, req)
 
-	resBody := &db.Profile{}
+	resBody := &CreateUserResponse{}
 	err := json.NewDecoder(res.Body).Decode(resBody)
 	if err != nil {
 		t.Error("Unexpected error decoding json", err)
@@ -290,4 +475,841 @@ func Test_createUserHandlerReturnsProfile(t *testing.T) {
 	if resBody.Id < 1 {
 		t.Error("Expected generated user ID but got zero value")
 	}
+	if resBody.Password == "" {
+		t.Error("Expected generated password but got empty string")
+	}
+}
+
+func Test_reportHandlerReturnsValidationErrorForUnknownReason(t *testing.T) {
+	body := &ReportRequest{UserId: 1, Reason: "boring"}
+	var bytes bytes.Buffer
+	err := json.NewEncoder(&bytes).Encode(body)
+	if err != nil {
+		t.Error("Unexpected error encoding json", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/reports", &bytes)
+	res := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), contextKeyUserId, int32(3))
+	req = req.WithContext(ctx)
+
+	TestServer.reportHandler(res, req)
+
+	resBody := &ServerError{}
+	err = json.NewDecoder(res.Body).Decode(resBody)
+	if err != nil {
+		t.Error("Unexpected error decoding json", err)
+	}
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 but got %d", res.Code)
+	}
+
+	if resBody.Error != ErrValidationError.Error() {
+		t.Errorf("Expected validation error but got %s", resBody.Error)
+	}
+}
+
+func Test_authorizeReturnsForbiddenWithoutPermission(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/admin/users", nil)
+	res := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), contextKeyUserId, int32(3))
+	ctx = context.WithValue(ctx, contextKeyRole, db.RoleModerator)
+	req = req.WithContext(ctx)
+
+	called := false
+	TestServer.authorize(PermissionAssignRoles, func(w http.ResponseWriter, r *http.Request) { called = true })(res, req)
+
+	if called {
+		t.Error("Expected handler not to be called")
+	}
+
+	if res.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 but got %d", res.Code)
+	}
+}
+
+func Test_parseAuditFilterReadsQueryParameters(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/admin/audit?actor=1&action=login.failed&since=2024-06-01T00:00:00Z&limit=10", nil)
+
+	filter, err := parseAuditFilter(req)
+	if err != nil {
+		t.Fatal("Unexpected error parsing filter", err)
+	}
+
+	if filter.ActorId == nil || *filter.ActorId != 1 {
+		t.Errorf("Expected actor 1 but got %v", filter.ActorId)
+	}
+
+	if filter.TargetId != nil {
+		t.Errorf("Expected no target but got %d", *filter.TargetId)
+	}
+
+	if filter.Action != "login.failed" || filter.Limit != 10 || filter.Since == nil || filter.Until != nil {
+		t.Errorf("Unexpected filter %+v", filter)
+	}
+}
+
+func Test_updateMeHandlerReturnsValidationErrorForUnknownGender(t *testing.T) {
+	gender := "unknown"
+	body := &UpdateProfileRequest{Gender: &gender}
+	var bytes bytes.Buffer
+	err := json.NewEncoder(&bytes).Encode(body)
+	if err != nil {
+		t.Error("Unexpected error encoding json", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPatch, "/me", &bytes)
+	res := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), contextKeyUserId, int32(3))
+	req = req.WithContext(ctx)
+
+	TestServer.updateMeHandler(res, req)
+
+	resBody := &ServerError{}
+	err = json.NewDecoder(res.Body).Decode(resBody)
+	if err != nil {
+		t.Error("Unexpected error decoding json", err)
+	}
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 but got %d", res.Code)
+	}
+
+	if resBody.Error != ErrValidationError.Error() {
+		t.Errorf("Expected validation error but got %s", resBody.Error)
+	}
+}
+
+func Test_updateMeHandlerRejectsDatesOfBirthUnderMinimumAge(t *testing.T) {
+	now := time.Now()
+	for dateOfBirth, expected := range map[string]error{
+		now.AddDate(-17, 0, 0).Format(db.DateOfBirthLayout):  db.ErrUnderage,
+		now.AddDate(-18, 0, 1).Format(db.DateOfBirthLayout):  db.ErrUnderage,
+		now.AddDate(-130, 0, 0).Format(db.DateOfBirthLayout): ErrValidationError,
+		"01/02/1990": ErrValidationError,
+	} {
+		req := httptest.NewRequest(http.MethodPatch, "/me", strings.NewReader(`{"dateOfBirth": "`+dateOfBirth+`"}`))
+		req = req.WithContext(context.WithValue(req.Context(), contextKeyUserId, int32(3)))
+		res := httptest.NewRecorder()
+
+		TestServer.updateMeHandler(res, req)
+
+		resBody := &ServerError{}
+		json.NewDecoder(res.Body).Decode(resBody)
+		if res.Code != http.StatusBadRequest || resBody.Error != expected.Error() {
+			t.Errorf("Expected %q for %s but got %d %q", expected, dateOfBirth, res.Code, resBody.Error)
+		}
+	}
+}
+
+func Test_randomDateOfBirthIsAlwaysAdult(t *testing.T) {
+	now := time.Date(2024, time.February, 29, 12, 0, 0, 0, time.UTC)
+	for range 1000 {
+		dateOfBirth := randomDateOfBirth(now)
+		if !db.IsAdult(dateOfBirth, now) || dateOfBirth.Before(now.AddDate(-100, 0, 0)) {
+			t.Fatalf("Expected an adult under 100 but got %s", dateOfBirth.Format(db.DateOfBirthLayout))
+		}
+	}
+
+	youngest := dateOfBirthAged(now, db.MinimumAge, 0)
+	if youngest.Format(db.DateOfBirthLayout) != "2006-02-28" || !db.IsAdult(youngest, now) {
+		t.Errorf("Expected the youngest date of birth on 29 February to be 2006-02-28 but got %s", youngest.Format(db.DateOfBirthLayout))
+	}
+
+	oldest := dateOfBirthAged(now, 99, 364)
+	if !db.IsAdult(oldest, now) || oldest.Before(now.AddDate(-100, 0, 0)) {
+		t.Errorf("Expected the oldest date of birth to be under 100 but got %s", oldest.Format(db.DateOfBirthLayout))
+	}
+
+	born := time.Date(2008, time.February, 29, 0, 0, 0, 0, time.UTC)
+	if db.IsAdult(born, time.Date(2026, time.February, 28, 23, 0, 0, 0, time.UTC)) || !db.IsAdult(born, time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Error("Expected someone born on 29 February to turn 18 on 1 March")
+	}
+}
+
+func Test_uploadPhotoHandlerRejectsNonImages(t *testing.T) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("photo", "photo.jpg")
+	if err != nil {
+		t.Fatal("Unexpected error creating form file", err)
+	}
+
+	part.Write([]byte("<html><body>not a photo</body></html>"))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/me/photos", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	res := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), contextKeyUserId, int32(3))
+	req = req.WithContext(ctx)
+
+	TestServer.uploadPhotoHandler(res, req)
+
+	resBody := &ServerError{}
+	err = json.NewDecoder(res.Body).Decode(resBody)
+	if err != nil {
+		t.Error("Unexpected error decoding json", err)
+	}
+
+	if res.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("Expected 415 but got %d", res.Code)
+	}
+
+	if resBody.Error != ErrUnsupportedMediaType.Error() {
+		t.Errorf("Expected unsupported media type error but got %s", resBody.Error)
+	}
+}
+
+func Test_setPromptsHandlerReturnsValidationErrorForInvalidAnswers(t *testing.T) {
+	bodies := []string{
+		`{"answers": [{"prompt": "not-a-prompt", "answer": "Anything"}]}`,
+		`{"answers": [{"prompt": "perfect-weekend", "answer": "   "}]}`,
+	}
+
+	for _, body := range bodies {
+		req := httptest.NewRequest(http.MethodPut, "/me/prompts", strings.NewReader(body))
+		res := httptest.NewRecorder()
+
+		ctx := context.WithValue(req.Context(), contextKeyUserId, int32(3))
+		req = req.WithContext(ctx)
+
+		TestServer.setPromptsHandler(res, req)
+
+		resBody := &ServerError{}
+		if err := json.NewDecoder(res.Body).Decode(resBody); err != nil {
+			t.Error("Unexpected error decoding json", err)
+		}
+
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s but got %d", body, res.Code)
+		}
+
+		if resBody.Error != ErrValidationError.Error() {
+			t.Errorf("Expected validation error for %s but got %s", body, resBody.Error)
+		}
+	}
+}
+
+func Test_setInterestsHandlerReturnsValidationErrorForBlankInterest(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPut, "/me/interests", strings.NewReader(`{"interests": ["hiking", "  "]}`))
+	res := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), contextKeyUserId, int32(3))
+	req = req.WithContext(ctx)
+
+	TestServer.setInterestsHandler(res, req)
+
+	resBody := &ServerError{}
+	if err := json.NewDecoder(res.Body).Decode(resBody); err != nil {
+		t.Error("Unexpected error decoding json", err)
+	}
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 but got %d", res.Code)
+	}
+
+	if resBody.Error != ErrValidationError.Error() {
+		t.Errorf("Expected validation error but got %s", resBody.Error)
+	}
+}
+
+func Test_deleteMeHandlerReturnsValidationErrorWithoutPassword(t *testing.T) {
+	req := httptest.NewRequest(http.MethodDelete, "/me", strings.NewReader(`{}`))
+	res := httptest.NewRecorder()
+
+	ctx := context.WithValue(req.Context(), contextKeyUserId, int32(3))
+	req = req.WithContext(ctx)
+
+	TestServer.deleteMeHandler(res, req)
+
+	resBody := &ServerError{}
+	err := json.NewDecoder(res.Body).Decode(resBody)
+	if err != nil {
+		t.Error("Unexpected error decoding json", err)
+	}
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 but got %d", res.Code)
+	}
+
+	if resBody.Error != ErrValidationError.Error() {
+		t.Errorf("Expected validation error but got %s", resBody.Error)
+	}
+}
+
+func Test_setNotificationPreferencesHandlerReturnsValidationErrorForInvalidQuietHours(t *testing.T) {
+	bodies := []string{
+		`{"matches": true, "likes": true, "quietHours": {"start": "25:00", "end": "07:00"}}`,
+		`{"matches": true, "likes": true, "timezone": "Mars/Olympus_Mons"}`,
+	}
+
+	for _, body := range bodies {
+		req := httptest.NewRequest(http.MethodPut, "/me/notification-preferences", strings.NewReader(body))
+		res := httptest.NewRecorder()
+
+		ctx := context.WithValue(req.Context(), contextKeyUserId, int32(3))
+		req = req.WithContext(ctx)
+
+		TestServer.setNotificationPreferencesHandler(res, req)
+
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s but got %d", body, res.Code)
+		}
+	}
+}
+
+func Test_notificationPreferencesResponseFormatsQuietHours(t *testing.T) {
+	start, end := 22*60+30, 7*60
+	response := notificationPreferencesResponse(notify.Preferences{Matches: true, QuietStart: &start, QuietEnd: &end, Timezone: "Europe/London"})
+
+	if response.QuietHours == nil || response.QuietHours.Start != "22:30" || response.QuietHours.End != "07:00" {
+		t.Errorf("Expected quiet hours 22:30 to 07:00 but got %+v", response.QuietHours)
+	}
+
+	if minutesAfterMidnight("22:30") != start {
+		t.Errorf("Expected 22:30 to be %d minutes after midnight", start)
+	}
+}
+
+func Test_resetPasswordHandlerReturnsValidationErrorForShortPassword(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/password/reset", strings.NewReader(`{"token": "abc", "password": "short"}`))
+	res := httptest.NewRecorder()
+
+	TestServer.resetPasswordHandler(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 but got %d", res.Code)
+	}
+}
+
+func Test_passwordResetLinkAddsTokenToConfiguredURL(t *testing.T) {
+	server := &Server{PasswordResetURL: "https://muzz.com/reset?source=email"}
+
+	link, err := server.passwordResetLink("a-b_c")
+	if err != nil {
+		t.Fatal("Unexpected error building link", err)
+	}
+
+	if link != "https://muzz.com/reset?source=email&token=a-b_c" {
+		t.Errorf("Unexpected reset link %s", link)
+	}
+
+	if hashResetToken("a-b_c") != hashResetToken("a-b_c") || hashResetToken("a-b_c") == "a-b_c" {
+		t.Error("Expected reset tokens to be hashed consistently")
+	}
+}
+
+func Test_verifyExportSignatureAcceptsOnlyUnexpiredSignedLinks(t *testing.T) {
+	server := &Server{ExportSigningKey: []byte("secret")}
+	now := time.Now()
+
+	link, err := url.Parse(server.signExportURL("abc", now.Add(time.Hour)))
+	if err != nil {
+		t.Fatal("Unexpected error parsing link", err)
+	}
+
+	expires, signature := link.Query().Get("expires"), link.Query().Get("signature")
+	if err := server.verifyExportSignature("abc", expires, signature, now); err != nil {
+		t.Errorf("Expected signed link to be valid but got %v", err)
+	}
+
+	if err := server.verifyExportSignature("abd", expires, signature, now); err != ErrForbidden {
+		t.Errorf("Expected link for another export to be forbidden but got %v", err)
+	}
+
+	if err := server.verifyExportSignature("abc", expires, signature, now.Add(2*time.Hour)); err != ErrExportLinkExpired {
+		t.Errorf("Expected link to have expired but got %v", err)
+	}
+}
+
+func Test_mediaHandlerServesOnlySignedUnexpiredLinks(t *testing.T) {
+	key := "photos/3/media-test/small.jpg"
+	if err := TestServer.Blobs.Put(context.Background(), key, strings.NewReader("photo"), "image/jpeg"); err != nil {
+		t.Fatal("Unexpected error storing photo", err)
+	}
+
+	now := time.Now()
+	expired := TestServer.signMediaURL(key, now.Add(-3*defaultMediaURLTTL))
+	tests := map[string]struct {
+		link string
+		code int
+	}{
+		"signed":   {link: TestServer.signMediaURL(key, now), code: http.StatusOK},
+		"unsigned": {link: TestServer.Blobs.URL(key), code: http.StatusForbidden},
+		"other photo": {
+			link: strings.Replace(TestServer.signMediaURL("photos/4/media-test/small.jpg", now), "photos/4", "photos/3", 1),
+			code: http.StatusForbidden,
+		},
+		"expired": {link: expired, code: http.StatusForbidden},
+	}
+
+	for name, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, test.link, nil)
+		req.SetPathValue("key", strings.TrimPrefix(req.URL.Path, "/media/"))
+		res := httptest.NewRecorder()
+
+		TestServer.mediaHandler(res, req)
+
+		if res.Code != test.code {
+			t.Errorf("Expected %d for %s link but got %d", test.code, name, res.Code)
+		}
+	}
+}
+
+func Test_createExportHandlerReturnsPendingExport(t *testing.T) {
+	userId := createVisibilityProfile(t, db.VisibilityVisible)
+	pending, created, err := TestServer.Store.CreateExport(context.Background(), userId)
+	if err != nil || !created {
+		t.Fatal("Unexpected error creating export", created, err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/me/export", nil)
+	req = req.WithContext(context.WithValue(req.Context(), contextKeyUserId, userId))
+	res := httptest.NewRecorder()
+
+	TestServer.createExportHandler(res, req)
+
+	resBody := &ExportResponse{}
+	if err := json.NewDecoder(res.Body).Decode(resBody); err != nil {
+		t.Error("Unexpected error decoding json", err)
+	}
+
+	if res.Code != http.StatusAccepted {
+		t.Errorf("Expected 202 but got %d", res.Code)
+	}
+
+	if resBody.DataExport == nil || resBody.Id != pending.Id {
+		t.Errorf("Expected the pending export %s to be returned but got %+v", pending.Id, resBody.DataExport)
+	}
+}
+
+func Test_writeExportArchiveIncludesDataAndPhotos(t *testing.T) {
+	data := &db.ExportData{
+		Profile:     &db.PrivateProfile{PublicProfile: db.PublicProfile{Id: 1, Name: "Bob"}},
+		Preferences: &db.Preferences{},
+		Photos:      []*db.Photo{{Id: 7, BlobPrefix: "photos/1/abc"}},
+	}
+
+	var archive bytes.Buffer
+	err := writeExportArchive(&archive, data, func(photo *db.Photo) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("jpeg")), nil
+	})
+	if err != nil {
+		t.Fatal("Unexpected error writing archive", err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(archive.Bytes()), int64(archive.Len()))
+	if err != nil {
+		t.Fatal("Unexpected error reading archive", err)
+	}
+
+	var names []string
+	for _, file := range reader.File {
+		names = append(names, file.Name)
+	}
+
+	expected := []string{"profile.json", "preferences.json", "swipes.json", "matches.json", "sessions.json", "photos.json", "photos/7.jpg"}
+	if !slices.Equal(names, expected) {
+		t.Errorf("Expected archive to contain %v but got %v", expected, names)
+	}
+}
+
+func Test_requireVerifiedReturnsForbiddenForRestrictedAction(t *testing.T) {
+	server := &Server{UnverifiedRestrictions: []string{ActionSwipe}}
+
+	for action, expected := range map[string]bool{ActionSwipe: false, ActionDiscover: true} {
+		req := httptest.NewRequest(http.MethodPost, "/"+action, nil)
+		res := httptest.NewRecorder()
+
+		ctx := context.WithValue(req.Context(), contextKeyUserId, int32(3))
+		ctx = context.WithValue(ctx, contextKeyVerified, false)
+		req = req.WithContext(ctx)
+
+		called := false
+		server.requireVerified(action, func(w http.ResponseWriter, r *http.Request) { called = true })(res, req)
+
+		if called != expected {
+			t.Errorf("Expected handler called to be %v for %s", expected, action)
+		}
+
+		if !expected && res.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 for %s but got %d", action, res.Code)
+		}
+	}
+}
+
+func Test_verifyVerificationSignatureAcceptsOnlyUnexpiredLinksForTheSameEmail(t *testing.T) {
+	server := &Server{EmailVerificationKey: []byte("secret"), PublicURL: "https://muzz.com"}
+	now := time.Now()
+
+	link, err := url.Parse(server.signVerificationURL(3, "bob@muzz.com", now.Add(time.Hour)))
+	if err != nil {
+		t.Fatal("Unexpected error parsing link", err)
+	}
+
+	if link.Host != "muzz.com" || link.Path != "/verify-email" {
+		t.Errorf("Unexpected verification link %s", link)
+	}
+
+	user, expires, signature := link.Query().Get("user"), link.Query().Get("expires"), link.Query().Get("signature")
+	if err := server.verifyVerificationSignature(user, "bob@muzz.com", expires, signature, now); err != nil {
+		t.Errorf("Expected signed link to be valid but got %v", err)
+	}
+
+	if err := server.verifyVerificationSignature(user, "eve@muzz.com", expires, signature, now); err != ErrForbidden {
+		t.Errorf("Expected link for a changed email to be forbidden but got %v", err)
+	}
+
+	if err := server.verifyVerificationSignature(user, "bob@muzz.com", expires, signature, now.Add(2*time.Hour)); err != ErrVerificationLinkExpired {
+		t.Errorf("Expected link to have expired but got %v", err)
+	}
+}
+
+func Test_loginTwoFactorHandlerReturnsValidationErrorWithoutCode(t *testing.T) {
+	for _, body := range []string{`{"challenge": "abc"}`, `{"challenge": "abc", "code": "123456", "recoveryCode": "abcde-fghij"}`, `{"challenge": "abc", "code": "12345a"}`} {
+		req := httptest.NewRequest(http.MethodPost, "/login/2fa", strings.NewReader(body))
+		res := httptest.NewRecorder()
+
+		TestServer.loginTwoFactorHandler(res, req)
+
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s but got %d", body, res.Code)
+		}
+	}
+}
+
+func Test_generateRecoveryCodesReturnsDistinctCodesHashedIgnoringFormatting(t *testing.T) {
+	codes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal("Unexpected error generating recovery codes", err)
+	}
+
+	if len(codes) != recoveryCodeCount || len(codes[0]) != 11 || codes[0][5] != '-' {
+		t.Errorf("Unexpected recovery codes %v", codes)
+	}
+
+	slices.Sort(codes)
+	if len(slices.Compact(codes)) != recoveryCodeCount {
+		t.Errorf("Expected distinct recovery codes but got %v", codes)
+	}
+
+	if hashRecoveryCode("ABCDE FGHIJ") != hashRecoveryCode("abcde-fghij") {
+		t.Error("Expected recovery code hash to ignore case, spaces and dashes")
+	}
+}
+
+func Test_oauthHandlersRejectUnknownProvidersAndCancelledSignIns(t *testing.T) {
+	server := &Server{OIDCProviders: map[string]*oidc.Provider{"test": oidc.NewProvider("test", "https://issuer.example.com", "muzz", "", "")}}
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/unknown", nil)
+	req.SetPathValue("provider", "unknown")
+	res := httptest.NewRecorder()
+
+	server.oauthStartHandler(res, req)
+
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown provider but got %d", res.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/auth/test/callback?error=access_denied&state=abc", nil)
+	req.SetPathValue("provider", "test")
+	res = httptest.NewRecorder()
+
+	server.oauthCallbackHandler(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for cancelled sign in but got %d", res.Code)
+	}
+}
+
+func Test_deleteMeHandlerAcceptsPhoneCodeForPhoneProfiles(t *testing.T) {
+	ctx := context.Background()
+	phone := fmt.Sprintf("+4477009%05d", time.Now().UnixNano()%100000)
+	profile, err := TestServer.Store.CreatePhoneProfile(ctx, phone, randomDateOfBirth(time.Now()), "Phone", "other", "unknown", db.Location{})
+	if err != nil {
+		t.Fatal("Unexpected error creating phone profile", err)
+	}
+
+	if err := TestServer.Store.CreatePhoneCode(ctx, phone, "123456", phoneCodeTTL); err != nil {
+		t.Fatal("Unexpected error creating phone code", err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/me", strings.NewReader(`{"code": "123456"}`))
+	req = req.WithContext(context.WithValue(req.Context(), contextKeyUserId, profile.Id))
+	res := httptest.NewRecorder()
+
+	TestServer.deleteMeHandler(res, req)
+
+	resBody := &DeleteAccountResponse{}
+	if err := json.NewDecoder(res.Body).Decode(resBody); err != nil {
+		t.Error("Unexpected error decoding json", err)
+	}
+
+	if res.Code != http.StatusAccepted {
+		t.Errorf("Expected 202 but got %d", res.Code)
+	}
+
+	if resBody.DeletionScheduledFor.IsZero() {
+		t.Error("Expected the deletion to be scheduled")
+	}
+}
+
+func Test_phoneHandlersReturnBadRequestForInvalidNumbersAndCodes(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/phone/otp", strings.NewReader(`{"phone": "07700 900123"}`))
+	res := httptest.NewRecorder()
+
+	TestServer.sendPhoneCodeHandler(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a number without a country code but got %d", res.Code)
+	}
+
+	for _, body := range []string{`{"phone": "+447700900123", "code": "12345"}`, `{"phone": "+447700900123", "code": "12345a"}`, `{"code": "123456"}`} {
+		req := httptest.NewRequest(http.MethodPost, "/phone/login", strings.NewReader(body))
+		res := httptest.NewRecorder()
+
+		TestServer.phoneLoginHandler(res, req)
+
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s but got %d", body, res.Code)
+		}
+	}
+}
+
+func Test_generatePhoneCodeReturnsSixDigits(t *testing.T) {
+	code, err := generatePhoneCode()
+	if err != nil {
+		t.Fatal("Unexpected error generating phone code", err)
+	}
+
+	if len(code) != 6 || strings.Trim(code, "0123456789") != "" {
+		t.Errorf("Expected 6 digit code but got %q", code)
+	}
+}
+
+func Test_drainFinishesInFlightRequestsAndTasks(t *testing.T) {
+	server := &Server{ShutdownTimeout: time.Second}
+	started := make(chan struct{})
+	httpServer := server.httpServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Unexpected error listening", err)
+	}
+	go httpServer.Serve(listener)
+
+	responses := make(chan int, 1)
+	go func() {
+		res, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			responses <- 0
+			return
+		}
+		res.Body.Close()
+		responses <- res.StatusCode
+	}()
+
+	<-started
+	taskDone := false
+	server.tasks.Add(1)
+	go func() {
+		defer server.tasks.Done()
+		time.Sleep(50 * time.Millisecond)
+		taskDone = true
+	}()
+
+	if err := server.drain(httpServer); err != nil {
+		t.Fatal("Unexpected error draining", err)
+	}
+
+	if status := <-responses; status != http.StatusNoContent {
+		t.Errorf("Expected in-flight request to finish but got %d", status)
+	}
+
+	if !taskDone {
+		t.Error("Expected background task to finish before drain returned")
+	}
+}
+
+func Test_drainReturnsErrorWhenTasksOutlastTimeout(t *testing.T) {
+	server := &Server{ShutdownTimeout: 50 * time.Millisecond}
+	httpServer := server.httpServer(http.NotFoundHandler())
+
+	release := make(chan struct{})
+	defer close(release)
+	server.tasks.Add(1)
+	go func() {
+		defer server.tasks.Done()
+		<-release
+	}()
+
+	if err := server.drain(httpServer); err == nil {
+		t.Error("Expected error when background tasks do not finish in time")
+	}
+}
+
+func Test_StartReturnsErrorWhenAddressCannotBeUsed(t *testing.T) {
+	server := &Server{}
+	if err := server.Start(context.Background(), "not-an-address"); err == nil {
+		t.Error("Expected error listening on an invalid address")
+	}
+}
+
+// twoFactorStore fakes a user with two factor authentication who always enters the wrong code, counting rate limits
+// in memory and leaving every other method unimplemented
+type twoFactorStore struct {
+	db.ProfileStore
+	buckets map[string]int
+}
+
+func (f *twoFactorStore) CheckCredentials(ctx context.Context, username string, password string) (int32, bool, error) {
+	return 7, true, nil
+}
+
+func (f *twoFactorStore) CreateLoginChallenge(ctx context.Context, userId int32, tokenHash string, ttl time.Duration) error {
+	return nil
+}
+
+func (f *twoFactorStore) LoginChallengeUser(ctx context.Context, tokenHash string) (int32, error) {
+	return 7, nil
+}
+
+func (f *twoFactorStore) CompleteLoginChallenge(ctx context.Context, tokenHash string, code string, recoveryCodeHash string) (int32, error) {
+	return 7, db.ErrTwoFactorCodeInvalid
+}
+
+func (f *twoFactorStore) ConsumeRateLimit(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	f.buckets[key]++
+	return f.buckets[key] <= limit, window, nil
+}
+
+func (f *twoFactorStore) RecordAudit(ctx context.Context, entry db.AuditEntry) error {
+	return nil
+}
+
+func Test_loginTwoFactorHandlerLimitsCodesAcrossChallenges(t *testing.T) {
+	server := &Server{Store: &twoFactorStore{buckets: map[string]int{}}, Validate: validator.New(validator.WithRequiredStructEnabled())}
+
+	codes := 0
+	for range 3 {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username": "john@muzz.com", "password": "papayas"}`))
+		res := httptest.NewRecorder()
+
+		server.loginHandler(res, req)
+
+		login := &LoginResponse{}
+		if err := json.NewDecoder(res.Body).Decode(login); err != nil || login.Challenge == "" {
+			t.Fatalf("Expected a challenge but got %d", res.Code)
+		}
+
+		// each challenge allows 5 codes, so the user's limit is reached on the third challenge
+		for range 5 {
+			body := fmt.Sprintf(`{"challenge": %q, "code": "000000"}`, login.Challenge)
+			req := httptest.NewRequest(http.MethodPost, "/login/2fa", strings.NewReader(body))
+			res := httptest.NewRecorder()
+
+			server.loginTwoFactorHandler(res, req)
+
+			codes++
+			expected := http.StatusBadRequest
+			if codes > twoFactorAttemptLimit {
+				expected = http.StatusTooManyRequests
+			}
+
+			if res.Code != expected {
+				t.Fatalf("Expected %d for code %d but got %d", expected, codes, res.Code)
+			}
+		}
+	}
+}
+
+func Test_loginHandlerLimitsAttemptsForEachUsername(t *testing.T) {
+	server := &Server{Store: &twoFactorStore{buckets: map[string]int{}}, Validate: validator.New(validator.WithRequiredStructEnabled())}
+
+	for attempt := 1; attempt <= loginLimit+1; attempt++ {
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username": "John@muzz.com", "password": "papayas"}`))
+		res := httptest.NewRecorder()
+
+		server.loginHandler(res, req)
+
+		expected := http.StatusOK
+		if attempt > loginLimit {
+			expected = http.StatusTooManyRequests
+		}
+
+		if res.Code != expected {
+			t.Fatalf("Expected %d for attempt %d but got %d", expected, attempt, res.Code)
+		}
+	}
+}
+
+// healthStore fakes the readiness checks, leaving every other method unimplemented
+type healthStore struct {
+	db.ProfileStore
+	connectionErr error
+	pending       []string
+}
+
+func (h *healthStore) TestConnection(ctx context.Context) error {
+	return h.connectionErr
+}
+
+func (h *healthStore) PendingMigrations(ctx context.Context) ([]string, error) {
+	return h.pending, nil
+}
+
+func Test_readyzHandlerReportsDependencyStatus(t *testing.T) {
+	tests := map[string]struct {
+		store      *healthStore
+		draining   bool
+		code       int
+		status     string
+		database   string
+		migrations string
+	}{
+		"ready":       {&healthStore{}, false, http.StatusOK, readinessReady, checkOK, checkOK},
+		"unreachable": {&healthStore{connectionErr: db.ErrQueryTimedOut}, false, http.StatusServiceUnavailable, readinessNotReady, checkError, checkSkipped},
+		"pending":     {&healthStore{pending: []string{"0001_date_of_birth"}}, false, http.StatusServiceUnavailable, readinessNotReady, checkOK, checkPending},
+		"draining":    {&healthStore{}, true, http.StatusServiceUnavailable, readinessDraining, "", ""},
+	}
+
+	for name, test := range tests {
+		server := &Server{Store: test.store}
+		server.draining.Store(test.draining)
+
+		res := httptest.NewRecorder()
+		server.readyzHandler(res, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		response := &ReadinessResponse{}
+		if err := json.NewDecoder(res.Body).Decode(response); err != nil {
+			t.Fatal("Unexpected error decoding json", err)
+		}
+
+		if res.Code != test.code || response.Status != test.status ||
+			response.Dependencies["database"].Status != test.database || response.Dependencies["migrations"].Status != test.migrations {
+			t.Errorf("Unexpected %s readiness %d %+v", name, res.Code, response)
+		}
+	}
+}
+
+func Test_healthzHandlerReturnsOKWhileDraining(t *testing.T) {
+	server := &Server{}
+	server.draining.Store(true)
+
+	res := httptest.NewRecorder()
+	server.healthzHandler(res, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected 200 but got %d", res.Code)
+	}
 }
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chammond14/muzz/internal/db"
+	"github.com/chammond14/muzz/internal/totp"
+)
+
+const (
+	defaultLoginChallengeTTL = 5 * time.Minute
+	totpIssuer               = "Muzz"
+	recoveryCodeCount        = 10
+	// codes checked for each user per hour, across every challenge, since /login hands out a new challenge each time
+	twoFactorAttemptLimit = 10
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+type TwoFactorSetupResponse struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type TwoFactorConfirmResponse struct {
+	// RecoveryCodes are only ever shown once
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type LoginTwoFactorRequest struct {
+	Challenge    string `json:"challenge" validate:"required,max=100"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,excluded_with=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recoveryCode" validate:"omitempty,max=20"`
+}
+
+func (s *Server) loginChallengeTTL() time.Duration {
+	if s.LoginChallengeTTL <= 0 {
+		return defaultLoginChallengeTTL
+	}
+
+	return s.LoginChallengeTTL
+}
+
+// generateRecoveryCodes returns new recovery codes formatted for reading, e.g. "abcde-fghij".
+func generateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		codeBytes := make([]byte, 7)
+		if _, err := rand.Read(codeBytes); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(recoveryCodeEncoding.EncodeToString(codeBytes))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
+
+// hashRecoveryCode returns the hash a recovery code is stored as, ignoring case, spaces and dashes.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashResetToken(code)
+}
+
+// startLoginChallenge creates a challenge for a user who has passed the first factor, returning its token.
+func (s *Server) startLoginChallenge(ctx context.Context, userId int32) (string, error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(tokenBytes)
+	if err := s.Store.CreateLoginChallenge(ctx, userId, hashResetToken(token), s.loginChallengeTTL()); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *Server) setupTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "setupTwoFactorHandler")
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	profile, err := s.Store.GetProfile(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not load profile", "Handler", "setupTwoFactorHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		slog.Error("Could not generate secret", "Handler", "setupTwoFactorHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	if err := s.Store.SetupTwoFactor(r.Context(), userId, secret); err != nil {
+		slog.Info("Could not set up two factor authentication", "Handler", "setupTwoFactorHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "setupTwoFactorHandler")
+	// profiles which signed up with a phone number have no email to label the secret with
+	account := profile.Email
+	if account == "" {
+		account = profile.Phone
+	}
+
+	writeJsonResponse(w, http.StatusOK, TwoFactorSetupResponse{Secret: secret, URI: totp.URI(totpIssuer, account, secret)})
+}
+
+func (s *Server) confirmTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "confirmTwoFactorHandler")
+
+	codeRequest, err := createRequestBodyFromRequest(r, &TwoFactorCodeRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "confirmTwoFactorHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("confirmTwoFactorHandler", codeRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "confirmTwoFactorHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	recoveryCodes, err := generateRecoveryCodes()
+	if err != nil {
+		slog.Error("Could not generate recovery codes", "Handler", "confirmTwoFactorHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	hashes := make([]string, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	if err := s.Store.ConfirmTwoFactor(r.Context(), userId, codeRequest.Code, hashes); err != nil {
+		slog.Info("Could not confirm two factor authentication", "Handler", "confirmTwoFactorHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditTwoFactorEnabled, &userId, nil)
+
+	slog.Info("Request Complete", "Handler", "confirmTwoFactorHandler")
+	writeJsonResponse(w, http.StatusOK, TwoFactorConfirmResponse{RecoveryCodes: recoveryCodes})
+}
+
+func (s *Server) disableTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "disableTwoFactorHandler")
+
+	codeRequest, err := createRequestBodyFromRequest(r, &TwoFactorCodeRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "disableTwoFactorHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("disableTwoFactorHandler", codeRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "disableTwoFactorHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	if err := s.Store.DisableTwoFactor(r.Context(), userId, codeRequest.Code); err != nil {
+		slog.Info("Could not disable two factor authentication", "Handler", "disableTwoFactorHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditTwoFactorDisabled, &userId, nil)
+
+	slog.Info("Request Complete", "Handler", "disableTwoFactorHandler")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) loginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "loginTwoFactorHandler")
+
+	loginRequest, err := createRequestBodyFromRequest(r, &LoginTwoFactorRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "loginTwoFactorHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("loginTwoFactorHandler", loginRequest)
+	if err != nil {
+		slog.Info("error validating request params", "handler", "loginTwoFactorHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	method := "totp"
+	var recoveryCodeHash string
+	if loginRequest.RecoveryCode != "" {
+		method = "recovery_code"
+		recoveryCodeHash = hashRecoveryCode(loginRequest.RecoveryCode)
+	}
+
+	challengeHash := hashResetToken(loginRequest.Challenge)
+	userId, err := s.Store.LoginChallengeUser(r.Context(), challengeHash)
+	if err != nil {
+		slog.Info("Could not find login challenge", "Handler", "loginTwoFactorHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	if !s.rateLimit(w, r, "2fa:"+strconv.Itoa(int(userId)), twoFactorAttemptLimit, time.Hour) {
+		return
+	}
+
+	userId, err = s.Store.CompleteLoginChallenge(r.Context(), challengeHash, loginRequest.Code, recoveryCodeHash)
+	if err != nil {
+		slog.Info("Could not complete login", "Handler", "loginTwoFactorHandler", "error", err)
+		if err == db.ErrTwoFactorCodeInvalid {
+			s.recordAuditAs(r, nil, AuditLoginFailed, &userId, map[string]string{"method": method})
+		}
+
+		writeErrorResponse(w, err)
+		return
+	}
+
+	session, err := s.Store.StartSession(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not start session", "Handler", "loginTwoFactorHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAuditAs(r, &userId, AuditLoginSucceeded, &userId, map[string]string{"method": method})
+
+	slog.Info("Request Complete", "Handler", "loginTwoFactorHandler")
+	writeJsonResponse(w, http.StatusOK, LoginResponse{Token: session.Token})
+}
+
+func (s *Server) purgeExpiredLoginChallenges(ctx context.Context) error {
+	_, err := s.Store.PurgeExpiredLoginChallenges(ctx)
+	return err
+}
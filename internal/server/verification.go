@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Actions which VerificationPolicy can restrict to profiles with a verified email.
+const (
+	ActionDiscover = "discover"
+	ActionSwipe    = "swipe"
+	ActionPhotos   = "photos"
+)
+
+const (
+	defaultEmailVerificationTTL = 7 * 24 * time.Hour
+	defaultPublicURL            = "http://localhost:8080"
+)
+
+type VerifyEmailResponse struct {
+	EmailVerified bool `json:"emailVerified"`
+}
+
+func (s *Server) emailVerificationTTL() time.Duration {
+	if s.EmailVerificationTTL <= 0 {
+		return defaultEmailVerificationTTL
+	}
+
+	return s.EmailVerificationTTL
+}
+
+// requireVerified rejects users whose email is unverified when the policy restricts the action.
+// It must wrap a handler that has already been authenticated.
+func (s *Server) requireVerified(action string, sh ServerHandler) ServerHandler {
+	return func(w http.ResponseWriter, r *http.Request) {
+		verified, _ := r.Context().Value(contextKeyVerified).(bool)
+		if !verified && slices.Contains(s.UnverifiedRestrictions, action) {
+			slog.Info("Email must be verified", "user", r.Context().Value(contextKeyUserId), "action", action)
+			writeErrorResponse(w, ErrEmailNotVerified)
+			return
+		}
+
+		sh(w, r)
+	}
+}
+
+// sendVerificationEmail queues an email with a signed link which verifies the profile's current address.
+func (s *Server) sendVerificationEmail(ctx context.Context, userId int32, email string) error {
+	return s.Store.SendEmailVerification(ctx, userId, s.signVerificationURL(userId, email, time.Now().Add(s.emailVerificationTTL())))
+}
+
+func (s *Server) signVerificationURL(userId int32, email string, expiresAt time.Time) string {
+	base := s.PublicURL
+	if base == "" {
+		base = defaultPublicURL
+	}
+
+	user := strconv.Itoa(int(userId))
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	query := url.Values{"user": {user}, "expires": {expires}, "signature": {s.verificationSignature(user, email, expires)}}
+
+	return base + "/verify-email?" + query.Encode()
+}
+
+func (s *Server) verifyVerificationSignature(user string, email string, expires string, signature string, now time.Time) error {
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidRequest
+	}
+
+	if !hmac.Equal([]byte(signature), []byte(s.verificationSignature(user, email, expires))) {
+		return ErrForbidden
+	}
+
+	if now.Unix() > expiresAt {
+		return ErrVerificationLinkExpired
+	}
+
+	return nil
+}
+
+// verificationSignature signs the address along with the profile, so a link stops working if the email changes.
+func (s *Server) verificationSignature(user string, email string, expires string) string {
+	mac := hmac.New(sha256.New, s.EmailVerificationKey)
+	mac.Write([]byte(user + "\n" + email + "\n" + expires))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Server) resendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "resendVerificationHandler")
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	profile, err := s.Store.GetProfile(r.Context(), userId)
+	if err != nil {
+		slog.Info("Could not load profile", "Handler", "resendVerificationHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	if err := s.sendVerificationEmail(r.Context(), userId, profile.Email); err != nil {
+		slog.Info("Could not send verification email", "Handler", "resendVerificationHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "resendVerificationHandler")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) verifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "verifyEmailHandler")
+
+	query := r.URL.Query()
+	userId, err := strconv.ParseInt(query.Get("user"), 10, 32)
+	if err != nil {
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	profile, err := s.Store.GetProfile(r.Context(), int32(userId))
+	if err != nil {
+		slog.Info("Could not load profile", "Handler", "verifyEmailHandler", "error", err)
+		writeErrorResponse(w, ErrForbidden)
+		return
+	}
+
+	err = s.verifyVerificationSignature(query.Get("user"), profile.Email, query.Get("expires"), query.Get("signature"), time.Now())
+	if err != nil {
+		slog.Info("Invalid verification link", "Handler", "verifyEmailHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	if err := s.Store.VerifyEmail(r.Context(), profile.Id, profile.Email); err != nil {
+		slog.Info("Could not verify email", "Handler", "verifyEmailHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	if !profile.EmailVerified {
+		s.recordAuditAs(r, &profile.Id, AuditEmailVerified, &profile.Id, nil)
+	}
+
+	slog.Info("Request Complete", "Handler", "verifyEmailHandler")
+	writeJsonResponse(w, http.StatusOK, VerifyEmailResponse{EmailVerified: true})
+}
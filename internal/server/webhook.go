@@ -0,0 +1,176 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/chammond14/muzz/internal/db"
+	"github.com/chammond14/muzz/internal/events"
+	"github.com/chammond14/muzz/internal/webhooks"
+)
+
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+type CreateWebhookRequest struct {
+	URL        string   `json:"url" validate:"required,url,max=2000"`
+	EventTypes []string `json:"eventTypes" validate:"dive,oneof=profile.created swipe.liked match.created"`
+}
+
+type WebhooksResponse struct {
+	Results []*db.WebhookSubscription `json:"results"`
+}
+
+type WebhookDeliveriesResponse struct {
+	Results []*db.WebhookDelivery `json:"results"`
+}
+
+// enqueueWebhookDeliveries is subscribed to the event bus, fanning each event out to the webhooks subscribed to it.
+func (s *Server) enqueueWebhookDeliveries(ctx context.Context, event events.Event) error {
+	_, err := s.Store.EnqueueWebhookDeliveries(ctx, event)
+	return err
+}
+
+func (s *Server) createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "createWebhookHandler")
+
+	webhookRequest, err := createRequestBodyFromRequest(r, &CreateWebhookRequest{})
+	if err != nil {
+		slog.Info("Could not decode request body", "Handler", "createWebhookHandler", "error", err)
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	err = s.validateRequest("createWebhookHandler", webhookRequest)
+	if err != nil || !webhooks.ValidURL(webhookRequest.URL) {
+		slog.Info("error validating request params", "handler", "createWebhookHandler", "error", err)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		slog.Error("Could not generate webhook secret", "Handler", "createWebhookHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	eventTypes := webhookRequest.EventTypes
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+
+	userId := r.Context().Value(contextKeyUserId).(int32)
+	webhook, err := s.Store.CreateWebhook(r.Context(), webhookRequest.URL, eventTypes, "whsec_"+hex.EncodeToString(secret), userId)
+	if err != nil {
+		slog.Info("Could not create webhook", "Handler", "createWebhookHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditWebhookCreated, nil, map[string]string{"webhook": strconv.Itoa(webhook.Id), "url": webhook.URL})
+
+	slog.Info("Request Complete", "Handler", "createWebhookHandler")
+	writeJsonResponse(w, http.StatusOK, webhook)
+}
+
+func (s *Server) listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "listWebhooksHandler")
+
+	webhookList, err := s.Store.GetWebhooks(r.Context())
+	if err != nil {
+		slog.Info("Could not load webhooks", "Handler", "listWebhooksHandler", "error", err)
+		writeErrorResponse(w, ErrUnexpectedError)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "listWebhooksHandler")
+	writeJsonResponse(w, http.StatusOK, WebhooksResponse{Results: webhookList})
+}
+
+func (s *Server) deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "deleteWebhookHandler")
+
+	webhookId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	if err := s.Store.DeleteWebhook(r.Context(), webhookId); err != nil {
+		slog.Info("Could not delete webhook", "Handler", "deleteWebhookHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditWebhookDeleted, nil, map[string]string{"webhook": strconv.Itoa(webhookId)})
+
+	slog.Info("Request Complete", "Handler", "deleteWebhookHandler")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) webhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "webhookDeliveriesHandler")
+
+	webhookId, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	query := r.URL.Query()
+	status := query.Get("status")
+	if status != "" && status != webhooks.StatusPending && status != webhooks.StatusDelivered && status != webhooks.StatusDead {
+		slog.Info("Unknown delivery status", "Handler", "webhookDeliveriesHandler", "status", status)
+		writeErrorResponse(w, ErrValidationError)
+		return
+	}
+
+	limit := defaultDeliveryLimit
+	if query.Has("limit") {
+		limit, err = strconv.Atoi(query.Get("limit"))
+		if err != nil || limit < 1 || limit > maxDeliveryLimit {
+			slog.Info("Invalid limit", "Handler", "webhookDeliveriesHandler", "limit", query.Get("limit"))
+			writeErrorResponse(w, ErrValidationError)
+			return
+		}
+	}
+
+	deliveries, err := s.Store.GetWebhookDeliveries(r.Context(), webhookId, status, limit)
+	if err != nil {
+		slog.Info("Could not load webhook deliveries", "Handler", "webhookDeliveriesHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	slog.Info("Request Complete", "Handler", "webhookDeliveriesHandler")
+	writeJsonResponse(w, http.StatusOK, WebhookDeliveriesResponse{Results: deliveries})
+}
+
+func (s *Server) retryWebhookDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	slog.Info("Request Received", "Handler", "retryWebhookDeliveryHandler")
+
+	deliveryId, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeErrorResponse(w, ErrInvalidRequest)
+		return
+	}
+
+	delivery, err := s.Store.RetryWebhookDelivery(r.Context(), deliveryId)
+	if err != nil {
+		slog.Info("Could not retry webhook delivery", "Handler", "retryWebhookDeliveryHandler", "error", err)
+		writeErrorResponse(w, err)
+		return
+	}
+
+	s.recordAudit(r, AuditWebhookDeliveryRetried, nil, map[string]string{"delivery": strconv.FormatInt(delivery.Id, 10)})
+
+	slog.Info("Request Complete", "Handler", "retryWebhookDeliveryHandler")
+	writeJsonResponse(w, http.StatusOK, delivery)
+}
@@ -0,0 +1,71 @@
+// Package sms sends text messages through a pluggable provider, and normalises phone numbers to E.164.
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// ErrInvalidPhone is returned for numbers which are not in international format.
+var ErrInvalidPhone = errors.New("phone number must be in international format, e.g. +447700900123")
+
+// Provider sends text messages.
+type Provider interface {
+	Send(ctx context.Context, to string, body string) error
+}
+
+// NormalizePhone returns the number in E.164 format, e.g. +447700900123. The number must include its country code,
+// written with a leading + or 00, and spaces, dashes, dots and brackets are ignored.
+func NormalizePhone(raw string) (string, error) {
+	number := strings.TrimSpace(raw)
+	if strings.HasPrefix(number, "00") {
+		number = "+" + number[2:]
+	}
+
+	if !strings.HasPrefix(number, "+") {
+		return "", ErrInvalidPhone
+	}
+
+	digits := make([]byte, 0, len(number))
+	for _, c := range number[1:] {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = append(digits, byte(c))
+		case c == ' ' || c == '-' || c == '.' || c == '(' || c == ')':
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+
+	// E.164 allows up to 15 digits, and country codes never start with 0
+	if len(digits) < 8 || len(digits) > 15 || digits[0] == '0' {
+		return "", ErrInvalidPhone
+	}
+
+	return "+" + string(digits), nil
+}
+
+// ConsoleProvider writes messages to Writer instead of sending them, for local development. Messages are written to
+// the service log when Writer is nil.
+type ConsoleProvider struct {
+	Writer io.Writer
+	mu     sync.Mutex
+}
+
+func (p *ConsoleProvider) Send(ctx context.Context, to string, body string) error {
+	if p.Writer == nil {
+		slog.Info("SMS", "to", to, "body", body)
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	_, err := fmt.Fprintf(p.Writer, "SMS to %s: %s\n", to, body)
+	return err
+}
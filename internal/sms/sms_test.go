@@ -0,0 +1,41 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func Test_normalizePhone(t *testing.T) {
+	valid := map[string]string{
+		"+44 7700 900123":   "+447700900123",
+		"0044 7700-900123":  "+447700900123",
+		"+1 (415) 555.0100": "+14155550100",
+	}
+
+	for raw, expected := range valid {
+		phone, err := NormalizePhone(raw)
+		if err != nil || phone != expected {
+			t.Errorf("Expected %s to normalise to %s but got %s %v", raw, expected, phone, err)
+		}
+	}
+
+	for _, raw := range []string{"07700 900123", "+0 7700 900123", "+44 7700 900123 ext 4", "+1234567", "+1234567890123456", ""} {
+		if _, err := NormalizePhone(raw); err != ErrInvalidPhone {
+			t.Errorf("Expected %q to be invalid but got %v", raw, err)
+		}
+	}
+}
+
+func Test_consoleProviderWritesMessages(t *testing.T) {
+	var out bytes.Buffer
+	provider := &ConsoleProvider{Writer: &out}
+
+	if err := provider.Send(context.Background(), "+447700900123", "Your code is 123456"); err != nil {
+		t.Fatal("Unexpected error sending", err)
+	}
+
+	if out.String() != "SMS to +447700900123: Your code is 123456\n" {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
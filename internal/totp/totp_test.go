@@ -0,0 +1,73 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed from the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func Test_codeMatchesRFCTestVectors(t *testing.T) {
+	// the RFC gives 8 digit codes, of which these are the last 6
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+
+	for seconds, expected := range vectors {
+		code, err := Code(rfcSecret, Step(time.Unix(seconds, 0)))
+		if err != nil {
+			t.Fatal("Unexpected error generating code", err)
+		}
+
+		if code != expected {
+			t.Errorf("Expected %s at %d but got %s", expected, seconds, code)
+		}
+	}
+}
+
+func Test_validateAllowsClockDriftOfOnePeriod(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	previous, _ := Code(rfcSecret, Step(now)-1)
+	stale, _ := Code(rfcSecret, Step(now)-2)
+
+	step, ok := Validate(rfcSecret, previous, now)
+	if !ok || step != Step(now)-1 {
+		t.Errorf("Expected previous code to be valid for its step but got %d %v", step, ok)
+	}
+
+	if _, ok := Validate(rfcSecret, stale, now); ok {
+		t.Error("Expected code from two periods ago to be rejected")
+	}
+
+	if _, ok := Validate(rfcSecret, "12345", now); ok {
+		t.Error("Expected short code to be rejected")
+	}
+}
+
+func Test_uriIncludesSecretAndIssuer(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal("Unexpected error generating secret", err)
+	}
+
+	uri, err := url.Parse(URI("Muzz", "bob@muzz.com", secret))
+	if err != nil {
+		t.Fatal("Unexpected error parsing uri", err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Muzz:bob@muzz.com" {
+		t.Errorf("Unexpected uri %s", uri)
+	}
+
+	if uri.Query().Get("secret") != secret || uri.Query().Get("issuer") != "Muzz" {
+		t.Errorf("Unexpected uri query %s", uri.RawQuery)
+	}
+}
@@ -0,0 +1,180 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultMaxAttempts  = 8
+	defaultBaseBackoff  = 30 * time.Second
+	defaultMaxBackoff   = 6 * time.Hour
+	defaultBatchSize    = 50
+	defaultPollInterval = 5 * time.Second
+	// defaultLease is how long a claimed delivery is hidden from other dispatchers while it is attempted
+	defaultLease = 2 * time.Minute
+)
+
+// Delivery is an event waiting to be sent to a subscription.
+type Delivery struct {
+	Id        int64
+	URL       string
+	Secret    string
+	EventId   int64
+	EventType string
+	Body      json.RawMessage
+	// Attempts counts previous failed attempts
+	Attempts int
+}
+
+// Result describes the outcome of an attempt to send a delivery.
+type Result struct {
+	Status        string
+	StatusCode    *int
+	Error         *string
+	NextAttemptAt time.Time
+}
+
+// Store holds deliveries waiting to be sent.
+type Store interface {
+	// ClaimWebhookDeliveries returns up to limit due deliveries, hiding them from other claims for the lease.
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error)
+	RecordWebhookAttempt(ctx context.Context, id int64, result Result) error
+}
+
+// Dispatcher sends pending deliveries, retrying failures with exponential backoff until MaxAttempts is reached.
+type Dispatcher struct {
+	Store        Store
+	Client       *http.Client
+	MaxAttempts  int
+	BaseBackoff  time.Duration
+	MaxBackoff   time.Duration
+	BatchSize    int
+	PollInterval time.Duration
+	Lease        time.Duration
+	now          func() time.Time
+}
+
+func NewDispatcher(store Store) *Dispatcher {
+	return &Dispatcher{
+		Store:        store,
+		Client:       &http.Client{Timeout: 10 * time.Second},
+		MaxAttempts:  defaultMaxAttempts,
+		BaseBackoff:  defaultBaseBackoff,
+		MaxBackoff:   defaultMaxBackoff,
+		BatchSize:    defaultBatchSize,
+		PollInterval: defaultPollInterval,
+		Lease:        defaultLease,
+		now:          time.Now,
+	}
+}
+
+// Run sends deliveries until the context is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		sent, err := d.DispatchOnce(ctx)
+		if err != nil {
+			slog.Error("Could not dispatch webhooks", "error", err)
+		}
+
+		if sent == d.BatchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.PollInterval):
+		}
+	}
+}
+
+// DispatchOnce attempts a single batch of deliveries, returning how many were attempted.
+func (d *Dispatcher) DispatchOnce(ctx context.Context) (int, error) {
+	deliveries, err := d.Store.ClaimWebhookDeliveries(ctx, d.BatchSize, d.Lease)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, delivery := range deliveries {
+		result := d.attempt(ctx, delivery)
+		if err := d.Store.RecordWebhookAttempt(ctx, delivery.Id, result); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(deliveries), nil
+}
+
+func (d *Dispatcher) attempt(ctx context.Context, delivery Delivery) Result {
+	statusCode, err := d.send(ctx, delivery)
+	result := Result{Status: StatusDelivered}
+	if statusCode != 0 {
+		result.StatusCode = &statusCode
+	}
+
+	if err == nil {
+		return result
+	}
+
+	message := err.Error()
+	result.Error = &message
+
+	attempts := delivery.Attempts + 1
+	if attempts >= d.MaxAttempts {
+		slog.Info("Webhook delivery is dead", "delivery", delivery.Id, "attempts", attempts, "error", err)
+		result.Status = StatusDead
+		return result
+	}
+
+	result.Status = StatusPending
+	result.NextAttemptAt = d.now().Add(d.backoff(attempts))
+	slog.Info("Webhook delivery failed", "delivery", delivery.Id, "attempts", attempts, "retryAt", result.NextAttemptAt, "error", err)
+	return result
+}
+
+func (d *Dispatcher) send(ctx context.Context, delivery Delivery) (int, error) {
+	timestamp := d.now().Unix()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, timestamp, delivery.Body))
+	req.Header.Set(HeaderEventId, strconv.FormatInt(delivery.EventId, 10))
+	req.Header.Set(HeaderEventType, delivery.EventType)
+	req.Header.Set(HeaderDeliveryId, strconv.FormatInt(delivery.Id, 10))
+
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64<<10))
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("receiver responded with %d", res.StatusCode)
+	}
+
+	return res.StatusCode, nil
+}
+
+// backoff doubles the wait after each failed attempt, up to MaxBackoff.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.BaseBackoff
+	for i := 1; i < attempts && wait < d.MaxBackoff; i++ {
+		wait *= 2
+	}
+
+	return min(wait, d.MaxBackoff)
+}
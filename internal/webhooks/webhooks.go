@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers sent with every delivery.
+const (
+	HeaderTimestamp  = "X-Muzz-Timestamp"
+	HeaderSignature  = "X-Muzz-Signature"
+	HeaderEventId    = "X-Muzz-Event-Id"
+	HeaderEventType  = "X-Muzz-Event-Type"
+	HeaderDeliveryId = "X-Muzz-Delivery-Id"
+)
+
+// Delivery statuses.
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	// StatusDead deliveries failed too many times and are no longer retried
+	StatusDead = "dead"
+)
+
+// DefaultTolerance is how old a timestamp receivers should accept, to limit replayed deliveries.
+const DefaultTolerance = 5 * time.Minute
+
+var (
+	ErrInvalidSignature = errors.New("webhook signature is invalid")
+	ErrStaleTimestamp   = errors.New("webhook timestamp is outside the tolerance")
+)
+
+// Sign returns the signature header value for a body sent at the given unix timestamp. The timestamp is signed
+// with the body, so it cannot be changed to replay an old delivery.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the timestamp and signature headers of a delivery, as a receiver should.
+func Verify(secret string, timestampHeader string, signatureHeader string, body []byte, now time.Time, tolerance time.Duration) error {
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	if !hmac.Equal([]byte(signatureHeader), []byte(Sign(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+
+	if age := now.Sub(time.Unix(timestamp, 0)); age > tolerance || age < -tolerance {
+		return ErrStaleTimestamp
+	}
+
+	return nil
+}
+
+// ValidURL reports whether a subscription URL is an absolute http or https URL.
+func ValidURL(url string) bool {
+	return strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://")
+}
@@ -0,0 +1,106 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	deliveries []Delivery
+	results    map[int64]Result
+}
+
+func (f *fakeStore) ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) ([]Delivery, error) {
+	claimed := f.deliveries
+	f.deliveries = nil
+	return claimed, nil
+}
+
+func (f *fakeStore) RecordWebhookAttempt(ctx context.Context, id int64, result Result) error {
+	f.results[id] = result
+	return nil
+}
+
+// receiver is a partner endpoint which verifies signatures, responding with the given status once they are valid.
+func receiver(t *testing.T, secret string, status int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		err := Verify(secret, r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderSignature), body, time.Now(), DefaultTolerance)
+		if err != nil {
+			t.Errorf("Expected a valid signature but got %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		w.WriteHeader(status)
+	}))
+}
+
+func Test_DispatcherDeliversSignedPayload(t *testing.T) {
+	partner := receiver(t, "whsec_test", http.StatusOK)
+	defer partner.Close()
+
+	store := &fakeStore{
+		deliveries: []Delivery{{Id: 1, URL: partner.URL, Secret: "whsec_test", EventId: 5, EventType: "match.created", Body: json.RawMessage(`{"id":5}`)}},
+		results:    map[int64]Result{},
+	}
+
+	if _, err := NewDispatcher(store).DispatchOnce(context.Background()); err != nil {
+		t.Fatal("Unexpected error dispatching", err)
+	}
+
+	if result := store.results[1]; result.Status != StatusDelivered || result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+		t.Errorf("Expected delivery to succeed but got %+v", result)
+	}
+}
+
+func Test_DispatcherBacksOffThenDeadLetters(t *testing.T) {
+	partner := receiver(t, "whsec_test", http.StatusServiceUnavailable)
+	defer partner.Close()
+
+	now := time.Now()
+	store := &fakeStore{results: map[int64]Result{}}
+	dispatcher := NewDispatcher(store)
+	dispatcher.now = func() time.Time { return now }
+
+	store.deliveries = []Delivery{{Id: 1, URL: partner.URL, Secret: "whsec_test", Body: json.RawMessage(`{}`), Attempts: 2}}
+	dispatcher.DispatchOnce(context.Background())
+
+	result := store.results[1]
+	if result.Status != StatusPending || !result.NextAttemptAt.Equal(now.Add(4*dispatcher.BaseBackoff)) {
+		t.Errorf("Expected third failure to retry after %v but got %+v", 4*dispatcher.BaseBackoff, result)
+	}
+
+	store.deliveries = []Delivery{{Id: 1, URL: partner.URL, Secret: "whsec_test", Body: json.RawMessage(`{}`), Attempts: dispatcher.MaxAttempts - 1}}
+	dispatcher.DispatchOnce(context.Background())
+
+	if result := store.results[1]; result.Status != StatusDead || result.Error == nil {
+		t.Errorf("Expected final failure to be dead lettered but got %+v", result)
+	}
+}
+
+func Test_VerifyRejectsTamperedAndReplayedDeliveries(t *testing.T) {
+	now := time.Now()
+	body := []byte(`{"id":1}`)
+	timestamp := now.Unix()
+	signature := Sign("secret", timestamp, body)
+	timestampHeader := strconv.FormatInt(timestamp, 10)
+
+	if err := Verify("secret", timestampHeader, signature, []byte(`{"id":2}`), now, DefaultTolerance); err != ErrInvalidSignature {
+		t.Errorf("Expected tampered body to be rejected but got %v", err)
+	}
+
+	if err := Verify("secret", timestampHeader, signature, body, now.Add(time.Hour), DefaultTolerance); err != ErrStaleTimestamp {
+		t.Errorf("Expected replayed delivery to be rejected but got %v", err)
+	}
+
+	if err := Verify("secret", timestampHeader, signature, body, now, DefaultTolerance); err != nil {
+		t.Errorf("Expected delivery to be valid but got %v", err)
+	}
+}
@@ -2,12 +2,25 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"fmt"
 	"log/slog"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/0x6flab/namegenerator"
+	"github.com/chammond14/muzz/internal/blob"
 	"github.com/chammond14/muzz/internal/db"
+	"github.com/chammond14/muzz/internal/events"
+	"github.com/chammond14/muzz/internal/mail"
+	"github.com/chammond14/muzz/internal/notify"
+	"github.com/chammond14/muzz/internal/oidc"
 	"github.com/chammond14/muzz/internal/server"
+	"github.com/chammond14/muzz/internal/sms"
 	"github.com/go-playground/validator/v10"
 	"github.com/joho/godotenv"
 )
@@ -26,20 +39,322 @@ func main() {
 		panic(err)
 	}
 
-	ctx := context.Background()
+	// SIGTERM, sent by docker on a deploy, and SIGINT start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
 	slog.Info("===== testing connection =====")
 	if testErr := datastore.TestConnection(ctx); testErr != nil {
 		slog.Info("Testing error")
 		panic(testErr)
 	}
 
+	admins, err := parseUserIds(os.Getenv("ADMIN_IDS"))
+	if err != nil {
+		slog.Error("Failed to parse ADMIN_IDS, ending", "Function", "main", "error", err)
+		return
+	}
+
+	for _, admin := range admins {
+		if _, err := datastore.SetRole(ctx, admin, db.RoleAdmin); err != nil {
+			slog.Error("Failed to promote admin", "Function", "main", "user", admin, "error", err)
+		}
+	}
+
+	auditRetention, err := time.ParseDuration(os.Getenv("AUDIT_RETENTION"))
+	if err != nil {
+		slog.Info("Could not load AUDIT_RETENTION variable, keeping audit log forever", "Function", "main")
+	}
+
+	deletionGracePeriod, err := time.ParseDuration(os.Getenv("ACCOUNT_DELETION_GRACE"))
+	if err != nil {
+		slog.Info("Could not load ACCOUNT_DELETION_GRACE variable, using default", "Function", "main")
+	}
+
+	exportSigningKey := []byte(os.Getenv("EXPORT_SIGNING_KEY"))
+	if len(exportSigningKey) == 0 {
+		slog.Info("Could not load EXPORT_SIGNING_KEY variable, export links will not survive a restart", "Function", "main")
+		exportSigningKey = make([]byte, 32)
+		if _, err := rand.Read(exportSigningKey); err != nil {
+			slog.Error("Failed to generate export signing key, ending", "Function", "main", "error", err)
+			return
+		}
+	}
+
+	exportURLTTL, err := time.ParseDuration(os.Getenv("EXPORT_URL_TTL"))
+	if err != nil {
+		slog.Info("Could not load EXPORT_URL_TTL variable, using default", "Function", "main")
+	}
+
+	mediaSigningKey := []byte(os.Getenv("MEDIA_SIGNING_KEY"))
+	if len(mediaSigningKey) == 0 {
+		slog.Info("Could not load MEDIA_SIGNING_KEY variable, photo links will not survive a restart", "Function", "main")
+		mediaSigningKey = make([]byte, 32)
+		if _, err := rand.Read(mediaSigningKey); err != nil {
+			slog.Error("Failed to generate media signing key, ending", "Function", "main", "error", err)
+			return
+		}
+	}
+
+	mediaURLTTL, err := time.ParseDuration(os.Getenv("MEDIA_URL_TTL"))
+	if err != nil {
+		slog.Info("Could not load MEDIA_URL_TTL variable, using default", "Function", "main")
+	}
+
+	bus := events.NewBus()
+	eventSinks := []events.Sink{events.LogSink{}, bus}
+	if webhookURL := os.Getenv("EVENTS_WEBHOOK_URL"); webhookURL != "" {
+		eventSinks = append(eventSinks, events.NewWebhookSink(webhookURL))
+	}
+
+	// without credentials for a platform, notifications for it are written to PUSH_LOG_FILE or the log
+	devProvider := &notify.LogProvider{Path: os.Getenv("PUSH_LOG_FILE")}
+	notificationProviders := map[string]notify.Provider{notify.PlatformIOS: devProvider, notify.PlatformAndroid: devProvider}
+	if endpoint := os.Getenv("APNS_ENDPOINT"); endpoint != "" {
+		notificationProviders[notify.PlatformIOS] = notify.NewAPNsProvider(endpoint, os.Getenv("APNS_TOPIC"), os.Getenv("APNS_AUTH_TOKEN"))
+	}
+
+	if endpoint := os.Getenv("FCM_ENDPOINT"); endpoint != "" {
+		notificationProviders[notify.PlatformAndroid] = notify.NewFCMProvider(endpoint, os.Getenv("FCM_AUTH_TOKEN"))
+	}
+
+	likeDigestWindow, err := time.ParseDuration(os.Getenv("LIKE_DIGEST_WINDOW"))
+	if err != nil {
+		slog.Info("Could not load LIKE_DIGEST_WINDOW variable, using default", "Function", "main")
+	}
+
+	mailTemplates, err := mail.LoadTemplates()
+	if err != nil {
+		slog.Error("Failed to load email templates, ending", "Function", "main", "error", err)
+		return
+	}
+
+	mailFrom := os.Getenv("MAIL_FROM")
+	if mailFrom == "" {
+		mailFrom = "Muzz <no-reply@muzz.com>"
+	}
+
+	// without an SMTP server, email is written to MAIL_DIR as .eml files
+	var mailer mail.Mailer
+	if smtpAddr := os.Getenv("SMTP_ADDR"); smtpAddr != "" {
+		mailer = &mail.SMTPMailer{Addr: smtpAddr, From: mailFrom, Username: os.Getenv("SMTP_USERNAME"), Password: os.Getenv("SMTP_PASSWORD")}
+	} else {
+		mailDir := os.Getenv("MAIL_DIR")
+		if mailDir == "" {
+			mailDir = "mail"
+		}
+
+		mailer, err = mail.NewFileMailer(mailDir, mailFrom)
+		if err != nil {
+			slog.Error("Failed to create mail directory, ending", "Function", "main", "error", err)
+			return
+		}
+	}
+
+	passwordResetTTL, err := time.ParseDuration(os.Getenv("PASSWORD_RESET_TTL"))
+	if err != nil {
+		slog.Info("Could not load PASSWORD_RESET_TTL variable, using default", "Function", "main")
+	}
+
+	emailVerificationKey := []byte(os.Getenv("EMAIL_VERIFICATION_KEY"))
+	if len(emailVerificationKey) == 0 {
+		slog.Info("Could not load EMAIL_VERIFICATION_KEY variable, verification links will not survive a restart", "Function", "main")
+		emailVerificationKey = make([]byte, 32)
+		if _, err := rand.Read(emailVerificationKey); err != nil {
+			slog.Error("Failed to generate email verification key, ending", "Function", "main", "error", err)
+			return
+		}
+	}
+
+	emailVerificationTTL, err := time.ParseDuration(os.Getenv("EMAIL_VERIFICATION_TTL"))
+	if err != nil {
+		slog.Info("Could not load EMAIL_VERIFICATION_TTL variable, using default", "Function", "main")
+	}
+
+	// an empty UNVERIFIED_RESTRICTIONS lets unverified accounts do everything, so only default it when unset
+	restrictions, ok := os.LookupEnv("UNVERIFIED_RESTRICTIONS")
+	if !ok {
+		restrictions = server.ActionSwipe
+	}
+
+	unverifiedRestrictions, err := parseActions(restrictions)
+	if err != nil {
+		slog.Error("Failed to parse UNVERIFIED_RESTRICTIONS, ending", "Function", "main", "error", err)
+		return
+	}
+
+	discoverVerifiedFirst, _ := strconv.ParseBool(os.Getenv("DISCOVER_VERIFIED_FIRST"))
+
+	loginChallengeTTL, err := time.ParseDuration(os.Getenv("LOGIN_CHALLENGE_TTL"))
+	if err != nil {
+		slog.Info("Could not load LOGIN_CHALLENGE_TTL variable, using default", "Function", "main")
+	}
+
+	publicURL := os.Getenv("PUBLIC_URL")
+	if publicURL == "" {
+		publicURL = "http://localhost:8080"
+	}
+
+	oidcProviders := map[string]*oidc.Provider{}
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		issuer, clientId := os.Getenv(prefix+"ISSUER"), os.Getenv(prefix+"CLIENT_ID")
+		if issuer == "" || clientId == "" {
+			slog.Error("Failed to load sign in provider, its ISSUER and CLIENT_ID must be set, ending", "Function", "main", "provider", name)
+			return
+		}
+
+		redirectURL := strings.TrimSuffix(publicURL, "/") + "/auth/" + name + "/callback"
+		oidcProviders[name] = oidc.NewProvider(name, issuer, clientId, os.Getenv(prefix+"CLIENT_SECRET"), redirectURL)
+	}
+
+	// there is no SMS gateway yet, so codes are written to SMS_LOG_FILE or the log
+	smsProvider := &sms.ConsoleProvider{}
+	if smsLogFile := os.Getenv("SMS_LOG_FILE"); smsLogFile != "" {
+		file, err := os.OpenFile(smsLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			slog.Error("Failed to open SMS_LOG_FILE, ending", "Function", "main", "error", err)
+			return
+		}
+		defer file.Close()
+
+		smsProvider.Writer = file
+	}
+
+	readTimeout, err := time.ParseDuration(os.Getenv("HTTP_READ_TIMEOUT"))
+	if err != nil {
+		slog.Info("Could not load HTTP_READ_TIMEOUT variable, using default", "Function", "main")
+	}
+
+	writeTimeout, err := time.ParseDuration(os.Getenv("HTTP_WRITE_TIMEOUT"))
+	if err != nil {
+		slog.Info("Could not load HTTP_WRITE_TIMEOUT variable, using default", "Function", "main")
+	}
+
+	idleTimeout, err := time.ParseDuration(os.Getenv("HTTP_IDLE_TIMEOUT"))
+	if err != nil {
+		slog.Info("Could not load HTTP_IDLE_TIMEOUT variable, using default", "Function", "main")
+	}
+
+	shutdownTimeout, err := time.ParseDuration(os.Getenv("SHUTDOWN_TIMEOUT"))
+	if err != nil {
+		slog.Info("Could not load SHUTDOWN_TIMEOUT variable, using default", "Function", "main")
+	}
+
+	shutdownDelay, err := time.ParseDuration(os.Getenv("SHUTDOWN_DELAY"))
+	if err != nil {
+		slog.Info("Could not load SHUTDOWN_DELAY variable, stopping straight away", "Function", "main")
+	}
+
 	slog.Info("Starting HTTP Server", "Function", "main")
+	mediaDir := os.Getenv("MEDIA_DIR")
+	if mediaDir == "" {
+		mediaDir = "media"
+	}
+
+	mediaURL := os.Getenv("MEDIA_URL")
+	if mediaURL == "" {
+		mediaURL = "/media"
+	}
+
+	blobs, err := blob.NewLocalStore(mediaDir, mediaURL)
+	if err != nil {
+		slog.Error("Failed to create media directory, ending", "Function", "main", "error", err)
+		return
+	}
+
+	maxPhotoBytes, err := strconv.ParseInt(os.Getenv("MAX_PHOTO_BYTES"), 10, 64)
+	if err != nil {
+		slog.Info("Could not load MAX_PHOTO_BYTES variable, using default", "Function", "main")
+	}
+
+	photoMatchDistance, err := strconv.Atoi(os.Getenv("PHOTO_MATCH_DISTANCE"))
+	if err != nil {
+		slog.Info("Could not load PHOTO_MATCH_DISTANCE variable, using default", "Function", "main")
+	}
+
 	server := &server.Server{
-		Store:     datastore,
-		Validate:  validator.New(validator.WithRequiredStructEnabled()),
-		Generator: namegenerator.NewGenerator(),
+		Store:                  datastore,
+		Validate:               validator.New(validator.WithRequiredStructEnabled()),
+		Generator:              namegenerator.NewGenerator(),
+		Blobs:                  blobs,
+		AuditRetention:         auditRetention,
+		MaxPhotoBytes:          maxPhotoBytes,
+		PhotoMatchDistance:     photoMatchDistance,
+		DeletionGracePeriod:    deletionGracePeriod,
+		ExportSigningKey:       exportSigningKey,
+		ExportURLTTL:           exportURLTTL,
+		MediaSigningKey:        mediaSigningKey,
+		MediaURLTTL:            mediaURLTTL,
+		EventSinks:             eventSinks,
+		Bus:                    bus,
+		NotificationProviders:  notificationProviders,
+		LikeDigestWindow:       likeDigestWindow,
+		Mailer:                 mailer,
+		MailTemplates:          mailTemplates,
+		PasswordResetURL:       os.Getenv("PASSWORD_RESET_URL"),
+		PasswordResetTTL:       passwordResetTTL,
+		PublicURL:              publicURL,
+		EmailVerificationKey:   emailVerificationKey,
+		EmailVerificationTTL:   emailVerificationTTL,
+		UnverifiedRestrictions: unverifiedRestrictions,
+		DiscoverVerifiedFirst:  discoverVerifiedFirst,
+		LoginChallengeTTL:      loginChallengeTTL,
+		OIDCProviders:          oidcProviders,
+		SMSProvider:            smsProvider,
+		ReadTimeout:            readTimeout,
+		WriteTimeout:           writeTimeout,
+		IdleTimeout:            idleTimeout,
+		ShutdownTimeout:        shutdownTimeout,
+		ShutdownDelay:          shutdownDelay,
 	}
 
-	server.Start(os.Getenv("ADDR"))
+	err = server.Start(ctx, os.Getenv("ADDR"))
+	datastore.Close()
+	if err != nil {
+		slog.Error("Server did not shut down cleanly", "Function", "main", "error", err)
+		os.Exit(1)
+	}
+}
+
+// parseUserIds parses a comma separated list of profile ids.
+func parseUserIds(ids string) ([]int32, error) {
+	var userIds []int32
+	for _, id := range strings.Split(ids, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+
+		userId, err := strconv.ParseInt(id, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		userIds = append(userIds, int32(userId))
+	}
+
+	return userIds, nil
+}
+
+func parseActions(actions string) ([]string, error) {
+	var parsed []string
+	for _, action := range strings.Split(actions, ",") {
+		action = strings.TrimSpace(action)
+		switch action {
+		case "":
+			continue
+		case server.ActionDiscover, server.ActionSwipe, server.ActionPhotos:
+			parsed = append(parsed, action)
+		default:
+			return nil, fmt.Errorf("unknown action %q", action)
+		}
+	}
 
+	return parsed, nil
 }